@@ -3,12 +3,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"KernelSandersBot/internal/app"
 	"KernelSandersBot/internal/types"
@@ -62,7 +65,20 @@ func main() {
 
 	log.Printf("Server successfully bound to %s", listener.Addr().String())
 
-	if err := http.Serve(listener, nil); err != nil {
+	server := &http.Server{}
+	go func() {
+		// Stop serving and shut the application down on SIGINT or SIGTERM
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		log.Println("Shutting down...")
+		if err := server.Shutdown(context.Background()); err != nil {
+			log.Printf("Failed to shut down server: %v", err)
+		}
+	}()
+
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+	botApp.Shutdown()
 }
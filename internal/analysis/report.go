@@ -0,0 +1,113 @@
+// internal/analysis/report.go
+
+package analysis
+
+import (
+	"fmt"
+	"html"
+	"path"
+	"strings"
+)
+
+// DirectoryOverview is the model's architecture overview of one directory, reduced from the
+// summaries of its files.
+type DirectoryOverview struct {
+	Directory
+	Overview      string
+	FileSummaries map[string]string // By path; files whose summary failed are missing
+}
+
+// Report is the full analysis of an upload: local statistics, per-directory overviews and a summary.
+type Report struct {
+	Project   string
+	Stats     Stats
+	Summary   string
+	Overviews []DirectoryOverview
+	Omitted   []Directory // Directories without an overview because of the analysis limits
+	Cached    int         // File summaries reused from earlier uploads
+	Failed    int         // Files that could not be summarized
+	// RateLimited counts model requests skipped because the user's rate limit was used up.
+	RateLimited int
+}
+
+// LanguageShare describes the largest languages by share of lines, e.g. "Go 82%, YAML 10%".
+func (s Stats) LanguageShare(limit int) string {
+	var parts []string
+	for i, lang := range s.Languages {
+		if i == limit || s.Lines == 0 {
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%s %d%%", lang.Name, lang.Lines*100/s.Lines))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Markdown renders the report as a Markdown document for the web response page.
+func (r Report) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# Project analysis: %s\n\n", r.Project))
+	if r.Summary != "" {
+		sb.WriteString("## Summary\n\n" + r.Summary + "\n\n")
+	}
+
+	s := r.Stats
+	sb.WriteString("## Statistics\n\n")
+	sb.WriteString(fmt.Sprintf("- **Files:** %d\n- **Lines:** %d (%d non-blank)\n- **Size:** %d bytes\n- **Directories:** %d\n\n",
+		s.Files, s.Lines, s.CodeLines, s.Bytes, len(s.Directories)))
+
+	sb.WriteString("### Languages\n\n| Language | Files | Lines |\n|---|---|---|\n")
+	for _, lang := range s.Languages {
+		sb.WriteString(fmt.Sprintf("| %s | %d | %d |\n", cell(lang.Name), lang.Files, lang.Lines))
+	}
+
+	sb.WriteString("\n### Largest files\n\n| File | Lines | Bytes |\n|---|---|---|\n")
+	for _, file := range s.LargestFiles {
+		sb.WriteString(fmt.Sprintf("| %s | %d | %d |\n", cell(file.Path), file.Lines, file.Bytes))
+	}
+
+	sb.WriteString("\n### Dependency manifests\n\n")
+	if len(s.Manifests) == 0 {
+		sb.WriteString("None detected.\n")
+	} else {
+		sb.WriteString("| Manifest | Ecosystem | Dependencies |\n|---|---|---|\n")
+		for _, m := range s.Manifests {
+			deps := "-"
+			if m.Dependencies >= 0 {
+				deps = fmt.Sprintf("%d", m.Dependencies)
+			}
+			sb.WriteString(fmt.Sprintf("| %s | %s | %s |\n", cell(m.Path), cell(m.Kind), deps))
+		}
+	}
+
+	sb.WriteString("\n## Architecture by directory\n")
+	for _, o := range r.Overviews {
+		sb.WriteString(fmt.Sprintf("\n### %s\n\n*%d files, %d lines*\n\n%s\n\n", o.Path, len(o.Files), o.Lines, o.Overview))
+		for _, filePath := range o.Files {
+			if summary, ok := o.FileSummaries[filePath]; ok {
+				sb.WriteString(fmt.Sprintf("- `%s`: %s\n", path.Base(filePath), strings.ReplaceAll(summary, "\n", " ")))
+			}
+		}
+	}
+	if len(r.Omitted) > 0 {
+		var names []string
+		for _, d := range r.Omitted {
+			names = append(names, fmt.Sprintf("%s (%d lines)", d.Path, d.Lines))
+		}
+		sb.WriteString(fmt.Sprintf("\nDirectories without an overview: %s\n", strings.Join(names, ", ")))
+	}
+	if r.Cached > 0 {
+		sb.WriteString(fmt.Sprintf("\n%d unchanged file(s) reused their summary from an earlier upload.\n", r.Cached))
+	}
+	if r.Failed > 0 {
+		sb.WriteString(fmt.Sprintf("\n%d file(s) could not be summarized.\n", r.Failed))
+	}
+	if r.RateLimited > 0 {
+		sb.WriteString(fmt.Sprintf("\n%d model request(s) were skipped because your rate limit was used up.\n", r.RateLimited))
+	}
+	return sb.String()
+}
+
+// cell escapes text for a single Markdown table cell.
+func cell(text string) string {
+	return strings.ReplaceAll(html.EscapeString(text), "|", `\|`)
+}
@@ -0,0 +1,202 @@
+// internal/analysis/stats.go
+
+package analysis
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+
+	"KernelSandersBot/internal/sourcetree"
+)
+
+// maxLargestFiles is the number of files listed as the largest.
+const maxLargestFiles = 5
+
+// languages maps file extensions to language names.
+var languages = map[string]string{
+	".go": "Go", ".py": "Python", ".js": "JavaScript", ".jsx": "JavaScript", ".mjs": "JavaScript",
+	".ts": "TypeScript", ".tsx": "TypeScript", ".java": "Java", ".kt": "Kotlin", ".rs": "Rust",
+	".c": "C", ".h": "C", ".cpp": "C++", ".cc": "C++", ".hpp": "C++", ".cs": "C#", ".rb": "Ruby",
+	".php": "PHP", ".swift": "Swift", ".scala": "Scala", ".sh": "Shell", ".bash": "Shell",
+	".ps1": "PowerShell", ".sql": "SQL", ".html": "HTML", ".css": "CSS", ".scss": "CSS",
+	".md": "Markdown", ".yaml": "YAML", ".yml": "YAML", ".json": "JSON", ".toml": "TOML",
+	".xml": "XML", ".proto": "Protocol Buffers", ".tf": "Terraform", ".lua": "Lua", ".dart": "Dart",
+}
+
+// manifestKinds maps dependency manifest file names to their ecosystem.
+var manifestKinds = map[string]string{
+	"go.mod":           "Go modules",
+	"package.json":     "npm",
+	"requirements.txt": "pip",
+	"pyproject.toml":   "Python project",
+	"Pipfile":          "Pipenv",
+	"Cargo.toml":       "Cargo",
+	"pom.xml":          "Maven",
+	"build.gradle":     "Gradle",
+	"build.gradle.kts": "Gradle",
+	"Gemfile":          "Bundler",
+	"composer.json":    "Composer",
+	"Dockerfile":       "Docker",
+}
+
+// Language is the size of one language in a tree.
+type Language struct {
+	Name  string
+	Files int
+	Lines int
+}
+
+// FileSize is the size of one file.
+type FileSize struct {
+	Path  string
+	Lines int
+	Bytes int
+}
+
+// Manifest is a dependency manifest found in the tree.
+type Manifest struct {
+	Path         string
+	Kind         string
+	Dependencies int // -1 when the manifest format is not counted
+}
+
+// Directory groups the files of one directory.
+type Directory struct {
+	Path  string
+	Files []string
+	Lines int
+}
+
+// Stats are locally computed statistics of a source tree.
+type Stats struct {
+	Files        int
+	Lines        int
+	CodeLines    int // Non-blank lines
+	Bytes        int
+	Languages    []Language // Largest first
+	LargestFiles []FileSize
+	Manifests    []Manifest
+	Directories  []Directory // Largest first
+}
+
+// Compute gathers statistics of a tree without calling the model.
+func Compute(tree *sourcetree.Tree) Stats {
+	stats := Stats{Files: len(tree.Files)}
+	byLanguage := make(map[string]*Language)
+	byDirectory := make(map[string]*Directory)
+	var sizes []FileSize
+
+	for _, file := range tree.Files {
+		lines := lineCount(file.Content)
+		stats.Lines += lines
+		stats.Bytes += len(file.Content)
+		for _, line := range strings.Split(file.Content, "\n") {
+			if strings.TrimSpace(line) != "" {
+				stats.CodeLines++
+			}
+		}
+		sizes = append(sizes, FileSize{Path: file.Path, Lines: lines, Bytes: len(file.Content)})
+
+		name, ok := languages[strings.ToLower(path.Ext(file.Path))]
+		if !ok {
+			name = "Other"
+		}
+		if byLanguage[name] == nil {
+			byLanguage[name] = &Language{Name: name}
+		}
+		byLanguage[name].Files++
+		byLanguage[name].Lines += lines
+
+		dir := path.Dir(file.Path)
+		if byDirectory[dir] == nil {
+			byDirectory[dir] = &Directory{Path: dir}
+		}
+		byDirectory[dir].Files = append(byDirectory[dir].Files, file.Path)
+		byDirectory[dir].Lines += lines
+
+		if kind, ok := manifestKinds[path.Base(file.Path)]; ok {
+			stats.Manifests = append(stats.Manifests, Manifest{Path: file.Path, Kind: kind, Dependencies: countDependencies(path.Base(file.Path), file.Content)})
+		}
+	}
+
+	for _, lang := range byLanguage {
+		stats.Languages = append(stats.Languages, *lang)
+	}
+	sort.Slice(stats.Languages, func(i, j int) bool {
+		if stats.Languages[i].Lines != stats.Languages[j].Lines {
+			return stats.Languages[i].Lines > stats.Languages[j].Lines
+		}
+		return stats.Languages[i].Name < stats.Languages[j].Name
+	})
+
+	sort.SliceStable(sizes, func(i, j int) bool { return sizes[i].Lines > sizes[j].Lines })
+	if len(sizes) > maxLargestFiles {
+		sizes = sizes[:maxLargestFiles]
+	}
+	stats.LargestFiles = sizes
+
+	for _, dir := range byDirectory {
+		stats.Directories = append(stats.Directories, *dir)
+	}
+	sort.Slice(stats.Directories, func(i, j int) bool {
+		if stats.Directories[i].Lines != stats.Directories[j].Lines {
+			return stats.Directories[i].Lines > stats.Directories[j].Lines
+		}
+		return stats.Directories[i].Path < stats.Directories[j].Path
+	})
+	sort.Slice(stats.Manifests, func(i, j int) bool { return stats.Manifests[i].Path < stats.Manifests[j].Path })
+	return stats
+}
+
+// lineCount returns the number of lines in content.
+func lineCount(content string) int {
+	if content == "" {
+		return 0
+	}
+	return strings.Count(strings.TrimSuffix(content, "\n"), "\n") + 1
+}
+
+// countDependencies counts the dependencies declared in the manifests it understands, or returns -1.
+func countDependencies(name, content string) int {
+	switch name {
+	case "go.mod":
+		count := 0
+		inRequire := false
+		for _, line := range strings.Split(content, "\n") {
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 0 || strings.HasPrefix(fields[0], "//"):
+			case inRequire && fields[0] == ")":
+				inRequire = false
+			case inRequire:
+				count++
+			case fields[0] == "require" && len(fields) > 1 && fields[1] == "(":
+				inRequire = true
+			case fields[0] == "require":
+				count++
+			}
+		}
+		return count
+	case "package.json":
+		var pkg struct {
+			Dependencies    map[string]string `json:"dependencies"`
+			DevDependencies map[string]string `json:"devDependencies"`
+		}
+		if err := json.Unmarshal([]byte(content), &pkg); err != nil {
+			return -1
+		}
+		return len(pkg.Dependencies) + len(pkg.DevDependencies)
+	case "requirements.txt":
+		count := 0
+		for _, line := range strings.Split(content, "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "-") {
+				count++
+			}
+		}
+		return count
+	}
+	return -1
+}
@@ -0,0 +1,101 @@
+// internal/analysis/summarize.go
+
+package analysis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"KernelSandersBot/internal/cache"
+	"KernelSandersBot/internal/sourcetree"
+)
+
+// maxFileExcerpt is the part of one file sent to the model for its summary.
+const maxFileExcerpt = 24 << 10
+
+// fileSummaryPrompt asks for the summary of one file.
+const fileSummaryPrompt = "Summarize the file %q in 1-2 sentences for a developer: its purpose and its main types " +
+	"and functions. Reply with the summary only.\n\n%s"
+
+// contentHash returns the hex SHA-256 of content.
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Summarizer runs summary prompts against the model with a bounded number of concurrent requests.
+type Summarizer struct {
+	Query   func(prompt string) (string, error)
+	Workers int
+	Cache   *cache.Cache // Optional; file summaries keyed by the hash of the file content
+	// CachePrefix is prepended to cache keys, so the summaries of one user can be deleted together.
+	CachePrefix string
+}
+
+// Run answers the prompts with at most Workers queries in flight. Results and errors are returned
+// in the order of the prompts.
+func (s *Summarizer) Run(prompts []string) ([]string, []error) {
+	results := make([]string, len(prompts))
+	errs := make([]error, len(prompts))
+	workers := s.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = s.Query(prompts[i])
+			}
+		}()
+	}
+	for i := range prompts {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results, errs
+}
+
+// SummarizeFiles summarizes each file, taking unchanged files from the cache. It returns the summaries
+// by path, the number served from the cache and the number that failed; failed files have no summary.
+func (s *Summarizer) SummarizeFiles(files []sourcetree.File) (map[string]string, int, int) {
+	summaries := make(map[string]string)
+	var pending []sourcetree.File
+	var prompts []string
+	for _, file := range files {
+		if s.Cache != nil {
+			if summary, ok := s.Cache.Get(s.CachePrefix + contentHash(file.Content)); ok {
+				summaries[file.Path] = summary.(string)
+				continue
+			}
+		}
+		content := file.Content
+		if len(content) > maxFileExcerpt {
+			content = content[:maxFileExcerpt] + "\n... (truncated)"
+		}
+		pending = append(pending, file)
+		prompts = append(prompts, fmt.Sprintf(fileSummaryPrompt, file.Path, content))
+	}
+	cached := len(summaries)
+
+	results, errs := s.Run(prompts)
+	failed := 0
+	for i, file := range pending {
+		if errs[i] != nil || results[i] == "" {
+			failed++
+			continue
+		}
+		summaries[file.Path] = results[i]
+		if s.Cache != nil {
+			s.Cache.Set(s.CachePrefix+contentHash(file.Content), results[i])
+		}
+	}
+	return summaries, cached, failed
+}
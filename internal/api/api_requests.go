@@ -15,6 +15,25 @@ import (
 	"KernelSandersBot/internal/types"
 )
 
+// Defaults used when a query does not override them.
+const (
+	DefaultModel       = "gpt-4o-mini"
+	DefaultTemperature = 0.7
+	DefaultMaxTokens   = 1500
+)
+
+// QueryOptions overrides the model parameters of a single query. Zero values use the defaults.
+type QueryOptions struct {
+	Model       string
+	Temperature *float64 // nil uses DefaultTemperature; 0 is a valid temperature
+	MaxTokens   int
+	JSON        bool // Require the reply to be a JSON object
+	// OnRequest, if set, is called before each request made with these options, including every
+	// round of a tool-calling loop, so callers can charge each one to a rate limit. If it returns an
+	// error the request is not sent and the query fails with that error.
+	OnRequest func() error
+}
+
 // APIHandler handles interactions with the OpenAI API.
 type APIHandler struct {
 	APIKey      string
@@ -36,23 +55,64 @@ func NewAPIHandler(apiKey, endpointURL string) *APIHandler {
 
 // QueryOpenAIWithMessages sends a conversation history to OpenAI and retrieves the assistant's response.
 func (ah *APIHandler) QueryOpenAIWithMessages(messages []types.OpenAIMessage) (string, error) {
+	return ah.QueryOpenAIWithOptions(messages, QueryOptions{})
+}
+
+// QueryOpenAIWithOptions sends a conversation history to OpenAI using the given model parameters.
+func (ah *APIHandler) QueryOpenAIWithOptions(messages []types.OpenAIMessage, opts QueryOptions) (string, error) {
+	if err := opts.requested(); err != nil {
+		return "", err
+	}
+	reply, err := ah.send(newQuery(messages, opts))
+	if err != nil {
+		return "", err
+	}
+	return reply.Content, nil
+}
+
+// requested calls OnRequest, if set, and returns its error.
+func (opts QueryOptions) requested() error {
+	if opts.OnRequest != nil {
+		return opts.OnRequest()
+	}
+	return nil
+}
+
+// newQuery builds a chat completion request, applying the defaults for options that are not set.
+func newQuery(messages []types.OpenAIMessage, opts QueryOptions) types.OpenAIQuery {
 	query := types.OpenAIQuery{
-		Model:       "gpt-4o-mini", // Should be set to "gpt-4o-mini"
+		Model:       DefaultModel,
 		Messages:    messages,
-		Temperature: 0.7,
-		MaxTokens:   1500,
+		Temperature: DefaultTemperature,
+		MaxTokens:   DefaultMaxTokens,
+	}
+	if opts.Model != "" {
+		query.Model = opts.Model
+	}
+	if opts.Temperature != nil {
+		query.Temperature = *opts.Temperature
+	}
+	if opts.MaxTokens > 0 {
+		query.MaxTokens = opts.MaxTokens
 	}
+	if opts.JSON {
+		query.ResponseFormat = &types.OpenAIResponseFormat{Type: "json_object"}
+	}
+	return query
+}
 
+// send posts a chat completion request and returns the assistant message of the first choice.
+func (ah *APIHandler) send(query types.OpenAIQuery) (types.OpenAIMessage, error) {
 	reqBody, err := json.Marshal(query)
 	if err != nil {
-		return "", err
+		return types.OpenAIMessage{}, err
 	}
 
 	log.Printf("Making OpenAI API request to: %s", ah.EndpointURL) // Added logging
 
 	req, err := http.NewRequest("POST", ah.EndpointURL, bytes.NewBuffer(reqBody))
 	if err != nil {
-		return "", err
+		return types.OpenAIMessage{}, err
 	}
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ah.APIKey))
@@ -60,23 +120,23 @@ func (ah *APIHandler) QueryOpenAIWithMessages(messages []types.OpenAIMessage) (s
 
 	resp, err := ah.HTTPClient.Do(req)
 	if err != nil {
-		return "", err
+		return types.OpenAIMessage{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("OpenAI API error: %s - %s", resp.Status, string(bodyBytes))
+		return types.OpenAIMessage{}, fmt.Errorf("OpenAI API error: %s - %s", resp.Status, string(bodyBytes))
 	}
 
 	var openAIResp types.OpenAIResponse
 	if err := json.NewDecoder(resp.Body).Decode(&openAIResp); err != nil {
-		return "", err
+		return types.OpenAIMessage{}, err
 	}
 
 	if len(openAIResp.Choices) == 0 {
-		return "", errors.New("no response from OpenAI")
+		return types.OpenAIMessage{}, errors.New("no response from OpenAI")
 	}
 
-	return openAIResp.Choices[0].Message.Content, nil
+	return openAIResp.Choices[0].Message, nil
 }
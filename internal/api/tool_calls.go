@@ -0,0 +1,65 @@
+// internal/api/tool_calls.go
+
+package api
+
+import (
+	"fmt"
+	"log"
+
+	"KernelSandersBot/internal/types"
+)
+
+// DefaultMaxToolSteps is the number of tool-calling rounds allowed before the model must answer.
+const DefaultMaxToolSteps = 6
+
+// ToolExecutor provides the tools a model may call and runs them.
+type ToolExecutor interface {
+	// Definitions describes the available tools.
+	Definitions() []types.OpenAITool
+	// Call runs a tool with JSON-encoded arguments. Failures are reported in the result text so
+	// the model can correct itself.
+	Call(name, arguments string) string
+}
+
+// QueryOpenAIWithTools runs a tool-calling loop: the model may call the executor's tools for up to
+// maxSteps rounds, each round's results being sent back with the next request. After the last round
+// tools are withdrawn so the model has to answer with what it has gathered. The intermediate tool
+// messages are not returned; only the final answer is. The loop stops with an error if the options'
+// OnRequest refuses a round.
+func (ah *APIHandler) QueryOpenAIWithTools(messages []types.OpenAIMessage, opts QueryOptions, executor ToolExecutor, maxSteps int) (string, error) {
+	// Work on a copy so the caller's conversation only ever holds the final answer
+	transcript := append([]types.OpenAIMessage(nil), messages...)
+	tools := executor.Definitions()
+
+	for step := 0; ; step++ {
+		query := newQuery(transcript, opts)
+		query.Tools = tools
+		if step >= maxSteps {
+			query.ToolChoice = "none"
+		}
+
+		if err := opts.requested(); err != nil {
+			return "", err
+		}
+		reply, err := ah.send(query)
+		if err != nil {
+			return "", err
+		}
+		if len(reply.ToolCalls) == 0 || step >= maxSteps {
+			return reply.Content, nil
+		}
+
+		transcript = append(transcript, reply)
+		for _, call := range reply.ToolCalls {
+			log.Printf("Tool call %d/%d: %s(%s)", step+1, maxSteps, call.Function.Name, call.Function.Arguments)
+			result := executor.Call(call.Function.Name, call.Function.Arguments)
+			transcript = append(transcript, types.OpenAIMessage{Role: "tool", ToolCallID: call.ID, Content: result})
+		}
+		if step == maxSteps-1 {
+			transcript = append(transcript, types.OpenAIMessage{
+				Role:    "system",
+				Content: fmt.Sprintf("You have used all %d tool steps. Answer now using the information gathered so far.", maxSteps),
+			})
+		}
+	}
+}
@@ -17,12 +17,15 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"KernelSandersBot/internal/api"
 	"KernelSandersBot/internal/cache"
 	"KernelSandersBot/internal/conversation"
+	"KernelSandersBot/internal/encryption"
 	"KernelSandersBot/internal/handlers"
+	"KernelSandersBot/internal/markdown"
 	"KernelSandersBot/internal/s3client"
 	"KernelSandersBot/internal/telegram"
 	"KernelSandersBot/internal/types"
@@ -30,6 +33,7 @@ import (
 	"KernelSandersBot/internal/utils"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/joho/godotenv"
 	"github.com/russross/blackfriday/v2"
@@ -46,19 +50,26 @@ type App struct {
 	OpenAIEndpoint       string
 	BotUsername          string
 	Cache                *cache.Cache
+	SummaryCache         *cache.Cache
 	HTTPClient           *http.Client
 	RateLimiter          *rate.Limiter
 	S3BucketName         string
 	S3Client             s3client.S3ClientInterface
 	UsageCache           *usage.UsageCache
+	AnalysisUsage        *usage.UsageCache // Allowance for the analysis after uploads, separate from UsageCache
 	NoLimitUsers         map[int]struct{}
+	AllowedModels        []AllowedModel
+	MaxCompletionTokens  int
 	ConversationContexts *conversation.ConversationCache
 	APIHandler           *api.APIHandler
 	TelegramHandler      *telegram.TelegramHandler
 	logMutex             sync.Mutex
 	ResponseStore        *ResponseStore
+	Keys                 *encryption.KeyManager
 	ShutdownChan         chan struct{}
-	wg                   sync.WaitGroup
+	settingsMutex        sync.Mutex
+	userSettings         map[int]UserSettings
+	chatSettings         map[int64]ChatSettings
 }
 
 // NewApp initializes the App with configurations from environment variables.
@@ -80,10 +91,20 @@ func NewApp() *App {
 	apiHandler := api.NewAPIHandler(os.Getenv("OPENAI_KEY"), os.Getenv("OPENAI_ENDPOINT"))
 	log.Printf("OpenAI API Endpoint URL: %s", apiHandler.EndpointURL)
 
+	// Initialize envelope encryption for stored user data
+	keys, err := encryption.NewKeyManager(os.Getenv("MASTER_ENCRYPTION_KEY"), s3Client, os.Getenv("BUCKET_NAME"))
+	if err != nil {
+		log.Fatalf("Invalid MASTER_ENCRYPTION_KEY: %v", err)
+	}
+	if !keys.Enabled() {
+		log.Println("Warning: MASTER_ENCRYPTION_KEY is not set. User data will be stored unencrypted.")
+	}
+
 	// Initialize ResponseStore with S3Client for persistent storage
-	responseStore := NewResponseStore(s3Client, os.Getenv("BUCKET_NAME"))
+	responseStore := NewResponseStore(s3Client, os.Getenv("BUCKET_NAME"), keys)
 
-	// Load existing web responses from S3 into ResponseStore to ensure persistence across restarts
+	// Load existing web responses from S3 into ResponseStore to ensure persistence across restarts.
+	// Responses stored before encryption was enabled are sealed as they load.
 	if err := responseStore.LoadResponsesFromS3(); err != nil {
 		log.Printf("Failed to load responses from S3: %v", err)
 	}
@@ -93,18 +114,25 @@ func NewApp() *App {
 		OpenAIKey:            os.Getenv("OPENAI_KEY"),
 		OpenAIEndpoint:       os.Getenv("OPENAI_ENDPOINT"),
 		BotUsername:          os.Getenv("BOT_USERNAME"),
-		Cache:                cache.NewCache(),
+		Cache:                cache.New(cache.Options{TTL: completionCacheTTL, MaxEntries: completionCacheEntries, MaxBytes: completionCacheBytes}),
+		SummaryCache:         cache.New(cache.Options{TTL: summaryCacheTTL, MaxEntries: summaryCacheEntries, MaxBytes: summaryCacheBytes}),
 		HTTPClient:           &http.Client{Timeout: 15 * time.Second},
 		RateLimiter:          rate.NewLimiter(rate.Every(time.Second), 5),
 		S3BucketName:         os.Getenv("BUCKET_NAME"),
 		S3Client:             s3Client,
 		UsageCache:           usage.NewUsageCache(),
+		AnalysisUsage:        usage.NewUsageCacheWithLimit(analysisAllowance, analysisAllowanceWindow),
 		NoLimitUsers:         noLimitUsers,
+		AllowedModels:        parseAllowedModels(os.Getenv("ALLOWED_MODELS")),
+		MaxCompletionTokens:  parseMaxCompletionTokens(os.Getenv("MAX_COMPLETION_TOKENS")),
 		ConversationContexts: conversation.NewConversationCache(),
 		APIHandler:           apiHandler,
 		logMutex:             sync.Mutex{},
 		ResponseStore:        responseStore,
+		Keys:                 keys,
 		ShutdownChan:         make(chan struct{}),
+		userSettings:         make(map[int]UserSettings),
+		chatSettings:         make(map[int64]ChatSettings),
 	}
 
 	if app.BotUsername == "" {
@@ -113,6 +141,38 @@ func NewApp() *App {
 		log.Printf("Bot username is set to: %s", app.BotUsername)
 	}
 
+	// Drop expired completions and file summaries in the background, and log how well the caches work
+	app.Cache.StartEviction(cacheEvictionInterval)
+	app.SummaryCache.StartEviction(cacheEvictionInterval)
+	app.startCacheStatsLog(cacheStatsInterval)
+
+	// Persist conversations so context survives restarts
+	app.ConversationContexts.SetPersister(&conversationStore{
+		s3Client: s3Client,
+		bucket:   app.S3BucketName,
+		keys:     keys,
+	})
+
+	// Encrypt data stored before encryption was enabled so crypto-shredding covers it
+	app.MigrateLegacyPlaintext()
+
+	// Delete conversations that expired while the bot was stopped
+	go func() {
+		if _, err := app.ConversationContexts.SweepExpired(); err != nil {
+			log.Printf("Failed to delete expired conversations: %v", err)
+		}
+	}()
+
+	// Enforce the retention of saved conversations that are never read again
+	app.startSavedConversationSweep(savedConversationSweepInterval)
+
+	// Let replies to answers sent before a restart continue their conversations
+	if restored, err := app.ConversationContexts.RestoreLinks(); err != nil {
+		log.Printf("Failed to restore reply links: %v", err)
+	} else if restored > 0 {
+		log.Printf("Restored %d reply links", restored)
+	}
+
 	// Initialize TelegramHandler with the App as the MessageProcessor
 	app.TelegramHandler = telegram.NewTelegramHandler(app)
 
@@ -153,15 +213,13 @@ func EscapeHTML(text string) string {
 // HandleWebRequest handles web requests to serve the full response with enhanced formatting and expiration time.
 func (a *App) HandleWebRequest(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/")
-	responseText, exists := a.ResponseStore.GetResponse(path)
-	createdAt, hasCreated := a.ResponseStore.GetCreationTime(path)
-	expiresAt, hasExpires := a.ResponseStore.GetExpirationTime(path)
-	timeRemaining := time.Until(expiresAt)
-
-	if !exists || !hasCreated || !hasExpires {
+	response, exists := a.ResponseStore.Lookup(path)
+	if !exists {
 		http.Error(w, "Response not found or expired.", http.StatusNotFound)
 		return
 	}
+	responseText, createdAt, expiresAt := response.Content, response.CreatedAt, response.ExpiresAt
+	timeRemaining := time.Until(expiresAt)
 
 	// Convert Markdown to HTML using blackfriday
 	parsedHTML := blackfriday.Run([]byte(responseText))
@@ -212,6 +270,42 @@ func (a *App) HandleWebRequest(w http.ResponseWriter, r *http.Request) {
 			font-style: italic;
 			color: #a0a0a0;
 		}
+		table {
+			border-collapse: collapse;
+			width: 100%%;
+			margin: 10px 0;
+		}
+		th, td {
+			border: 1px solid #3a3a3a;
+			padding: 6px 8px;
+			text-align: left;
+			vertical-align: top;
+		}
+		th {
+			background-color: #2c2c2c;
+			cursor: pointer;
+			user-select: none;
+		}
+		th[data-order="asc"]::after {
+			content: " \25B2";
+		}
+		th[data-order="desc"]::after {
+			content: " \25BC";
+		}
+		.badge {
+			display: inline-block;
+			padding: 3px 8px;
+			margin-right: 6px;
+			border-radius: 10px;
+			font-size: 0.85em;
+			font-weight: bold;
+			background-color: #1b5e20;
+			color: #c8e6c9;
+		}
+		.badge-warning {
+			background-color: #7f4f00;
+			color: #ffe0b2;
+		}
 		.view-raw-button, .copy-clipboard-button {
 			margin-top: 10px;
 			padding: 5px 10px;
@@ -233,6 +327,39 @@ func (a *App) HandleWebRequest(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		// Tables sort by a column when its header is clicked; severities sort by seriousness
+		var severityRank = {critical: 0, high: 1, medium: 2, low: 3, info: 4};
+
+		function compareCells(x, y) {
+			var lx = x.toLowerCase(), ly = y.toLowerCase();
+			if (lx in severityRank && ly in severityRank) {
+				return severityRank[lx] - severityRank[ly];
+			}
+			return x.localeCompare(y, undefined, {numeric: true});
+		}
+
+		function makeSortable(table) {
+			var headers = table.querySelectorAll("th");
+			headers.forEach(function(th, col) {
+				th.addEventListener("click", function() {
+					var tbody = table.tBodies[0];
+					var rows = Array.prototype.slice.call(tbody.rows);
+					var asc = th.getAttribute("data-order") !== "asc";
+					headers.forEach(function(h) { h.removeAttribute("data-order"); });
+					th.setAttribute("data-order", asc ? "asc" : "desc");
+					rows.sort(function(a, b) {
+						var c = compareCells(a.cells[col].innerText.trim(), b.cells[col].innerText.trim());
+						return asc ? c : -c;
+					});
+					rows.forEach(function(row) { tbody.appendChild(row); });
+				});
+			});
+		}
+
+		document.addEventListener("DOMContentLoaded", function() {
+			document.querySelectorAll("#formatted-content table").forEach(makeSortable);
+		});
+
 		function copyToClipboard() {
 			var rawContent = document.getElementById("raw-content").innerText;
 			navigator.clipboard.writeText(rawContent).then(function() {
@@ -249,6 +376,7 @@ func (a *App) HandleWebRequest(w http.ResponseWriter, r *http.Request) {
 		<p><strong>Created At:</strong> UTC: %s | EDT: %s</p>
 		<p><strong>Deletion Time:</strong> UTC: %s | EDT: %s</p>
 		<p><strong>Time Remaining:</strong> %s</p>
+		%s
 		<button class="view-raw-button" onclick="toggleRaw()">View RAW</button>
 		<button class="copy-clipboard-button" onclick="copyToClipboard()">Copy to Clipboard</button>
 		<hr>
@@ -261,48 +389,51 @@ func (a *App) HandleWebRequest(w http.ResponseWriter, r *http.Request) {
 	</div>
 </body>
 </html>`,
-		creationTimeUTC, creationTimeEDT, deletionTimeUTC, deletionTimeEDT, timeRemaining.Truncate(time.Second).String(), string(parsedHTML), html.EscapeString(responseText))
+		creationTimeUTC, creationTimeEDT, deletionTimeUTC, deletionTimeEDT, timeRemaining.Truncate(time.Second).String(),
+		renderBadges(response.Badges), string(parsedHTML), html.EscapeString(responseText))
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	fmt.Fprint(w, formattedText)
 }
 
-// ProcessMessage processes a user's message, queries OpenAI, sends the response, and logs the interaction.
-func (a *App) ProcessMessage(chatID int64, userID int, username, userQuestion string, messageID int) error {
-	// Rate limit check
-	isNoLimitUser := false
-	if _, ok := a.NoLimitUsers[userID]; ok {
-		isNoLimitUser = true
-	}
-
-	if !isNoLimitUser && !a.UsageCache.CanUserChat(userID) {
-		// Calculate remaining time until limit reset
-		timeRemaining := a.UsageCache.TimeUntilLimitReset(userID)
-		minutes := int(timeRemaining.Minutes())
-		seconds := int(timeRemaining.Seconds()) % 60
-
-		limitMsg := "✅ *Rate Limit Exceeded*\n\nYou have reached the maximum number of messages allowed within the last 10 minutes. Please try again in " +
-			fmt.Sprintf("%d minutes and %d seconds.", minutes, seconds)
-		if err := a.SendMessage(chatID, limitMsg, messageID); err != nil {
-			log.Printf("Failed to send rate limit message to Telegram: %v", err)
+// renderBadges renders a response's badges for its web page.
+func renderBadges(badges []Badge) string {
+	var sb strings.Builder
+	for _, badge := range badges {
+		class := "badge"
+		if badge.Warning {
+			class += " badge-warning"
 		}
-
-		// Log the attempt to S3
-		a.logToS3(userID, username, userQuestion, "", isNoLimitUser)
-		return fmt.Errorf("user rate limited")
+		sb.WriteString(fmt.Sprintf(`<span class="%s">%s</span>`, class, html.EscapeString(badge.Label)))
 	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return "<p>" + sb.String() + "</p>"
+}
 
-	a.UsageCache.AddUsage(userID)
-
-	// Detect and replace '#source_code' reference with actual source code
-	if strings.Contains(strings.ToLower(userQuestion), "#source_code") {
+// ProcessMessage processes a user's message, queries OpenAI, sends the response, and logs the interaction.
+// replyToMessageID is the bot message the user replied to, or 0.
+func (a *App) ProcessMessage(chatID int64, userID int, username, userQuestion string, messageID, replyToMessageID int) error {
+	opts, model := a.queryOptionsFor(userID)
+	if err := a.enforceRateLimit(chatID, userID, username, userQuestion, messageID, model); err != nil {
+		return err
+	}
+	opts = a.meterRequests(userID, model, opts)
+
+	// Detect and replace '#source_code' reference with actual source code. Without it, an uploaded
+	// project is browsed through tool calls instead in private chats, or in groups when asked with
+	// #browse. The log records the question as asked, not the source.
+	originalQuestion := userQuestion
+	sourceInjected := strings.Contains(strings.ToLower(userQuestion), "#source_code")
+	if sourceInjected {
 		sourceCode, hasSource := a.GetUserSourceCode(userID)
 		if hasSource {
 			// Replace '#source_code' with the actual source code
 			userQuestion = strings.ReplaceAll(strings.ToLower(userQuestion), "#source_code", sourceCode)
 		} else {
 			// Inform the user that no source code is available
-			errMsg := "❗ *No Source Code Found*\n\nYou have not uploaded any source code yet. Please upload a `.txt` file using the /upload command."
+			errMsg := "❗ <b>No Source Code Found</b>\n\nYou have not uploaded any source code yet. Please upload a <code>.txt</code> file, a source file, or a .zip / .tar.gz archive of your project."
 			if err := a.SendMessage(chatID, errMsg, messageID); err != nil {
 				log.Printf("Failed to send no source code message: %v", err)
 			}
@@ -311,21 +442,11 @@ func (a *App) ProcessMessage(chatID int64, userID int, username, userQuestion st
 	}
 
 	// Maintain conversation context
-	conversationKey := fmt.Sprintf("user_%d", userID)
-	var messages []types.OpenAIMessage
-	if history, exists := a.ConversationContexts.Get(conversationKey); exists {
-		if err := json.Unmarshal([]byte(history), &messages); err != nil {
-			log.Printf("Failed to unmarshal conversation history: %v", err)
-			messages = []types.OpenAIMessage{
-				{Role: "system", Content: "You are a helpful assistant."},
-			}
-		}
-	} else {
-		// Initialize with system prompt
-		messages = []types.OpenAIMessage{
-			{Role: "system", Content: "You are a helpful assistant."},
-		}
-	}
+	conversationKey, parentKey := a.conversationKey(chatID, userID, replyToMessageID)
+	messages := a.continueConversation(conversationKey, parentKey)
+
+	// Start or refresh the conversation with the configured system prompt
+	messages = withSystemPrompt(messages, a.systemPromptFor(chatID, conversationKey))
 
 	// Append the new user message
 	messages = append(messages, types.OpenAIMessage{Role: "user", Content: userQuestion})
@@ -333,9 +454,16 @@ func (a *App) ProcessMessage(chatID int64, userID int, username, userQuestion st
 	// Query OpenAI
 	startTime := time.Now()
 
-	responseText, err := a.APIHandler.QueryOpenAIWithMessages(messages)
+	browse := !sourceInjected && shouldBrowse(chatID, userID, originalQuestion)
+	responseText, err := a.queryModel(userID, messages, opts, browse)
 	if err != nil {
 		log.Printf("OpenAI query failed: %v", err)
+		if errors.Is(err, errRequestRateLimited) {
+			limitMsg := "✅ <b>Rate Limit Exceeded</b>\n\nAnswering needed more requests than your rate limit has left. Please try again later."
+			if err := a.SendMessage(chatID, limitMsg, messageID); err != nil {
+				log.Printf("Failed to send rate limit message to Telegram: %v", err)
+			}
+		}
 		return err
 	}
 
@@ -345,47 +473,192 @@ func (a *App) ProcessMessage(chatID int64, userID int, username, userQuestion st
 	messages = append(messages, types.OpenAIMessage{Role: "assistant", Content: responseText})
 
 	// Update conversation context
-	messagesJSON, _ := json.Marshal(messages)
-	a.ConversationContexts.Set(conversationKey, string(messagesJSON))
+	a.setConversation(conversationKey, messages)
+
+	if err := a.deliverAnswer(chatID, userID, conversationKey, responseText, messageID); err != nil {
+		return err
+	}
+
+	// Log the interaction in S3
+	a.logToS3(userID, username, originalQuestion, fmt.Sprintf("%d ms", responseTime), a.isNoLimitUser(userID))
+	return nil
+}
+
+// isNoLimitUser reports whether the user is exempt from rate limiting.
+func (a *App) isNoLimitUser(userID int) bool {
+	_, ok := a.NoLimitUsers[userID]
+	return ok
+}
+
+// enforceRateLimit records a query against the user's rate limit, charging the model's cost tier. If the
+// limit is reached the user is told when to try again, the attempt is logged and an error is returned.
+func (a *App) enforceRateLimit(chatID int64, userID int, username, prompt string, messageID int, model AllowedModel) error {
+	if a.isNoLimitUser(userID) {
+		a.UsageCache.AddUsageCost(userID, model.Cost)
+		return nil
+	}
+
+	if !a.UsageCache.TrySpend(userID, model.Cost) {
+		// Calculate remaining time until enough usage has expired
+		timeRemaining := a.UsageCache.TimeUntilAffordable(userID, model.Cost)
+		minutes := int(timeRemaining.Minutes())
+		seconds := int(timeRemaining.Seconds()) % 60
+
+		limitMsg := "✅ <b>Rate Limit Exceeded</b>\n\nYou have reached the maximum number of messages allowed within the last 10 minutes. Please try again in " +
+			fmt.Sprintf("%d minutes and %d seconds.", minutes, seconds)
+		if model.Cost > 1 {
+			limitMsg += fmt.Sprintf("\n\n<b>%s</b> costs %s. Switch to a cheaper model with /model to keep chatting sooner.",
+				EscapeHTML(model.Name), formatCost(model.Cost))
+		}
+		if err := a.SendMessage(chatID, limitMsg, messageID); err != nil {
+			log.Printf("Failed to send rate limit message to Telegram: %v", err)
+		}
+
+		// Log the attempt to S3
+		a.logToS3(userID, username, prompt, "", false)
+		return fmt.Errorf("user rate limited")
+	}
+	return nil
+}
+
+// errRequestRateLimited is returned by queries stopped because a further request would exceed the user's rate limit.
+var errRequestRateLimited = errors.New("rate limit reached before the answer was complete")
+
+// meterRequests makes every model request sent with opts after the first, which enforceRateLimit
+// already charged, count against the user's rate limit at the model's cost, so tool-calling rounds
+// and retries are not free. A request the limit cannot cover is not sent, and the query fails with
+// errRequestRateLimited; users exempt from the limit are charged without a check.
+func (a *App) meterRequests(userID int, model AllowedModel, opts api.QueryOptions) api.QueryOptions {
+	var requests int32
+	opts.OnRequest = func() error {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			return nil
+		}
+		if a.isNoLimitUser(userID) {
+			a.UsageCache.AddUsageCost(userID, model.Cost)
+			return nil
+		}
+		if !a.UsageCache.TrySpend(userID, model.Cost) {
+			return errRequestRateLimited
+		}
+		return nil
+	}
+	return opts
+}
+
+// getConversation returns the messages of a conversation, if it exists and has not expired.
+func (a *App) getConversation(key string) ([]types.OpenAIMessage, bool) {
+	history, exists := a.ConversationContexts.Get(key)
+	if !exists {
+		return nil, false
+	}
+	var messages []types.OpenAIMessage
+	if err := json.Unmarshal([]byte(history), &messages); err != nil {
+		log.Printf("Failed to unmarshal conversation history: %v", err)
+		return nil, false
+	}
+	return messages, true
+}
+
+// setConversation stores the messages of a conversation, resetting its inactivity timer.
+func (a *App) setConversation(key string, messages []types.OpenAIMessage) {
+	messagesJSON, err := json.Marshal(messages)
+	if err != nil {
+		log.Printf("Failed to marshal conversation history: %v", err)
+		return
+	}
+	a.ConversationContexts.Set(key, string(messagesJSON))
+}
 
+// deliverAnswer stores an answer as a web response, sends it to the chat rendered as HTML with a link to
+// the full response, links the sent message to its conversation and attaches large code blocks.
+func (a *App) deliverAnswer(chatID int64, userID int, conversationKey, responseText string, messageID int) error {
 	// Store the full response in the ResponseStore (now persisted in S3)
-	responseID := a.ResponseStore.StoreResponseForUser(responseText, userID)
+	responseID := a.ResponseStore.StoreResponseWithBadges(responseText, userID, syntaxBadges(responseText))
 
-	// Escape HTML in responseText
-	escapedResponse := EscapeHTML(responseText)
+	// Render the Markdown answer into Telegram's HTML subset
+	renderedResponse := markdown.RenderTelegramHTML(responseText)
 
-	// Prepare final message with truncation if necessary
-	var finalMessage string
+	// Send the message to Telegram with HTML parse mode, falling back to escaped text if Telegram rejects the markup
 	link := a.GenerateResponseURL(responseID)
-	linkLength := len(link) + len("<a href=\"\"></a>") // Account for HTML tags
-	maxTelegramLength := 4096
-	if len(escapedResponse)+linkLength > maxTelegramLength {
-		// Truncate the message to accommodate the link
-		truncatedLength := maxTelegramLength - linkLength - len("\n\n")
-		if truncatedLength < 0 {
-			truncatedLength = 0
-		}
-		truncatedResponse := escapedResponse
-		if len(escapedResponse) > truncatedLength {
-			truncatedResponse = escapedResponse[:truncatedLength] + "..."
+	sentMessageID, err := a.sendMessage(chatID, formatResponseMessage(renderedResponse, link), messageID)
+	if err != nil {
+		log.Printf("Failed to send rendered message to Telegram, retrying as plain text: %v", err)
+		sentMessageID, err = a.sendMessage(chatID, formatResponseMessage(EscapeHTML(responseText), link), messageID)
+		if err != nil {
+			log.Printf("Failed to send message to Telegram: %v", err)
+			return err
 		}
-		finalMessage = fmt.Sprintf("%s\n\n<a href=\"%s\">View Formatted Response in its entirety</a>", truncatedResponse, link)
-	} else {
-		// Message is within limit; append the link
-		finalMessage = fmt.Sprintf("%s\n\n<a href=\"%s\">View Formatted Response in its entirety</a>", escapedResponse, link)
 	}
 
-	// Send the message to Telegram with HTML parse mode
-	if err := a.SendMessage(chatID, finalMessage, messageID); err != nil {
-		log.Printf("Failed to send message to Telegram: %v", err)
-		return err
+	// Replies to this answer continue the same conversation
+	if sentMessageID != 0 {
+		a.ConversationContexts.LinkMessage(chatID, sentMessageID, conversationKey)
 	}
 
-	// Log the interaction in S3
-	a.logToS3(userID, username, userQuestion, fmt.Sprintf("%d ms", responseTime), isNoLimitUser)
+	// Offer large code blocks as downloadable files
+	a.sendCodeAttachments(chatID, responseText, responseID, messageID)
 	return nil
 }
 
+// userConversationKey returns the ConversationContexts key of a user's private chat conversation.
+func userConversationKey(userID int) string {
+	return fmt.Sprintf("user_%d", userID)
+}
+
+// conversationKey selects the conversation a message continues, which is always owned by the user.
+// Private chats use the user's own conversation and group chats give each user a conversation per
+// chat. Replying to one of the bot's messages continues the conversation that message belongs to if
+// the user owns it. If another user owns it, parent is that conversation's key: the reply continues
+// the user's own conversation in the chat, seeded with a copy of the parent, which is never written.
+func (a *App) conversationKey(chatID int64, userID, replyToMessageID int) (key, parent string) {
+	if chatID == int64(userID) {
+		key = userConversationKey(userID)
+	} else {
+		key = fmt.Sprintf("chat_%d_user_%d", chatID, userID)
+	}
+	if replyToMessageID != 0 {
+		if thread, ok := a.ConversationContexts.ThreadKey(chatID, replyToMessageID); ok {
+			if owner, err := conversationOwner(thread); err == nil && owner == userID {
+				return thread, ""
+			}
+			return key, thread
+		}
+	}
+	return key, ""
+}
+
+// continueConversation returns the messages a message continues: the user's conversation, or a copy
+// of the parent conversation when the message replies to another user's thread.
+func (a *App) continueConversation(key, parent string) []types.OpenAIMessage {
+	if parent != "" {
+		if messages, ok := a.getConversation(parent); ok {
+			return messages
+		}
+	}
+	messages, _ := a.getConversation(key)
+	return messages
+}
+
+// ownsConversation returns a matcher for the conversation keys owned by a user.
+func ownsConversation(userID int) func(key string) bool {
+	return func(key string) bool {
+		owner, err := conversationOwner(key)
+		return err == nil && owner == userID
+	}
+}
+
+// formatResponseMessage appends the web response link to rendered HTML, truncating the HTML so the message fits Telegram's limit.
+func formatResponseMessage(renderedHTML, link string) string {
+	linkHTML := fmt.Sprintf("<a href=\"%s\">View Formatted Response in its entirety</a>", link)
+	maxTelegramLength := 4096
+	truncatedLength := maxTelegramLength - len(linkHTML) - len("\n\n")
+	if truncatedLength < 0 {
+		truncatedLength = 0
+	}
+	return fmt.Sprintf("%s\n\n%s", markdown.TruncateHTML(renderedHTML, truncatedLength), linkHTML)
+}
+
 // GenerateResponseURL generates the URL for the stored response.
 func (a *App) GenerateResponseURL(responseID string) string {
 	baseURL := os.Getenv("BASE_URL")
@@ -397,11 +670,12 @@ func (a *App) GenerateResponseURL(responseID string) string {
 
 // SendMessage sends a message to a Telegram chat.
 func (a *App) SendMessage(chatID int64, text string, replyToMessageID int) error {
-	return a.sendMessage(chatID, text, replyToMessageID)
+	_, err := a.sendMessage(chatID, text, replyToMessageID)
+	return err
 }
 
-// sendMessage sends a message to a Telegram chat using HTML parse mode.
-func (a *App) sendMessage(chatID int64, text string, replyToMessageID int) error {
+// sendMessage sends a message to a Telegram chat using HTML parse mode and returns the ID of the sent message.
+func (a *App) sendMessage(chatID int64, text string, replyToMessageID int) (int, error) {
 	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", a.TelegramToken)
 	payload := map[string]interface{}{
 		"chat_id":                  chatID,
@@ -416,7 +690,7 @@ func (a *App) sendMessage(chatID int64, text string, replyToMessageID int) error
 
 	reqBody, err := json.Marshal(payload)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -424,22 +698,30 @@ func (a *App) sendMessage(chatID int64, text string, replyToMessageID int) error
 
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
 	if err != nil {
-		return err
+		return 0, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := a.HTTPClient.Do(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status: %s - %s", resp.Status, string(bodyBytes))
+		return 0, fmt.Errorf("unexpected status: %s - %s", resp.Status, string(bodyBytes))
 	}
 
-	return nil
+	var sent struct {
+		Result struct {
+			MessageID int `json:"message_id"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&sent); err != nil {
+		log.Printf("Failed to decode sendMessage response: %v", err)
+	}
+	return sent.Result.MessageID, nil
 }
 
 // HandleUpdate handles incoming Telegram updates by delegating to TelegramHandler.
@@ -447,8 +729,53 @@ func (a *App) HandleUpdate(update *types.TelegramUpdate) {
 	a.TelegramHandler.HandleTelegramMessage(update)
 }
 
+// parseCommand splits a command message into the command, without any @BotUsername suffix, and its arguments.
+// Commands addressed to a different bot yield an empty command.
+func (a *App) parseCommand(text string) (string, string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	command := fields[0]
+	if i := strings.Index(command, "@"); i >= 0 {
+		if !strings.EqualFold(command[i+1:], a.BotUsername) {
+			return "", ""
+		}
+		command = command[:i]
+	}
+	args := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), fields[0]))
+	return strings.ToLower(command), args
+}
+
 // HandleCommand processes Telegram commands.
 func (a *App) HandleCommand(message *types.TelegramMessage, userID int, username string) (string, error) {
+	// Commands that take arguments are routed first
+	command, args := a.parseCommand(message.Text)
+	switch command {
+	case "/project_new", "/project_use", "/projects":
+		return a.handleProjectCommand(command, args, message, userID)
+	case "/export_my_data":
+		return a.handleExportCommand(message, userID)
+	case "/reset", "/history", "/undo", "/retry":
+		return a.handleConversationCommand(command, args, message, userID, username)
+	case "/save", "/load", "/threads":
+		return a.handleSavedConversationCommand(command, args, message, userID)
+	case "/system":
+		return a.handleSystemCommand(args, message, userID)
+	case "/model":
+		return a.handleModelCommand(args, message, userID)
+	case "/settings":
+		return a.handleSettingsCommand(args, message, userID)
+	case "/symbols", "/whereis":
+		return a.handleSymbolCommand(command, args, message, userID)
+	case "/review":
+		return a.handleReviewCommand(args, message, userID, username)
+	case "/tests":
+		return a.handleTestsCommand(args, message, userID, username)
+	case "/nocache":
+		return a.handleNoCacheCommand(args, message, userID)
+	}
+
 	switch {
 	case strings.HasPrefix(message.Text, "/mydata@"+a.BotUsername):
 		// Extract the command without the bot username
@@ -473,41 +800,66 @@ func (a *App) HandleCommand(message *types.TelegramMessage, userID int, username
 	default:
 		switch message.Text {
 		case "/start":
-			welcomeMsg := "✅ *Welcome to Kernel Sanders Bot!*\n\nYou can ask me questions about your application or upload your source code files for more context."
+			welcomeMsg := "✅ <b>Welcome to Kernel Sanders Bot!</b>\n\nYou can ask me questions about your application or upload your source code files for more context."
 			err := a.SendMessage(message.Chat.ID, welcomeMsg, message.MessageID)
 			return "", err
 		case "/help":
 			helpMsg := fmt.Sprintf(
-				"✅ *Help Menu:*\n\n"+
-					"*Commands:*\n"+
+				"✅ <b>Help Menu:</b>\n\n"+
+					"<b>Commands:</b>\n"+
 					"/start - Start interacting with the bot\n"+
 					"/help - Show this help message\n"+
-					"/upload - Upload your source code file (only .txt files are supported)\n"+
+					"/upload - Upload your source code (.txt, source files, .zip or .tar.gz archives, or a .patch / .diff to update it)\n"+
 					"/mydata - View your uploaded files and web responses\n"+
-					"/delete_my_data - Delete all your uploaded data and web responses\n"+
+					"/export_my_data - Download everything the bot stores about you as a zip\n"+
+					"/delete_my_data - Delete all your data and receive a deletion receipt\n"+
 					"/security - Learn about the bot's security measures\n"+
 					"/project - Learn about the KernelSanders project and how to contribute\n"+
-					"/my_source_code - Get scripts to prepare your source code for upload\n\n"+
-					"*File Uploads:*\n"+
-					"In group chats, upload .txt files by tagging me in the caption using @%s. In 1-on-1 chats, simply send the .txt file without tagging.\n\n"+
-					"These files will be stored for *4 hours* only. Uploading a new file will overwrite the existing one and reset the storage time.\n\n"+
-					"*Short-Lived Web Responses:*\n"+
+					"/my_source_code - Get scripts to prepare your source code for upload\n"+
+					"/reset - Forget the current conversation\n"+
+					"/history - Show a condensed view of the current conversation\n"+
+					"/undo - Remove your last question and my answer\n"+
+					"/retry [temperature] [model] - Regenerate the last answer\n"+
+					"/save &lt;name&gt; - Save the current conversation\n"+
+					"/load &lt;name&gt; - Continue a saved conversation\n"+
+					"/threads - List your saved conversations\n"+
+					"/system [prompt | persona &lt;name&gt; | reset] - Show or change your system prompt\n"+
+					"/model [name] - List the available models or choose one\n"+
+					"/settings - Show or change your temperature and max tokens\n"+
+					"/nocache [on | off] - Turn off reuse of identical /review, /tests and analysis results\n"+
+					"/review [path] - Review your active project, or one file or directory, for bugs and security issues\n"+
+					"/tests &lt;file or function&gt; - Generate table-driven tests as a downloadable file\n"+
+					"/symbols [package] - List the Go packages and symbols of your active project\n"+
+					"/whereis &lt;name&gt; - Find where a Go function, type or method is defined\n"+
+					"/projects - List your projects\n"+
+					"/project_new &lt;name&gt; - Create a project and make it active\n"+
+					"/project_use &lt;name&gt; - Switch your active project\n\n"+
+					"<b>Conversations:</b>\n"+
+					"Your private chat with me and each group chat have separate conversations. In groups, reply to one of my answers to continue that thread without tagging me.\n\n"+
+					"<b>System Prompts:</b>\n"+
+					"Use /system to give me your own instructions or pick a built-in persona (code_reviewer, go_expert, security_auditor). Group admins can set a default for the group with /system group.\n\n"+
+					"<b>File Uploads:</b>\n"+
+					"In group chats, upload files by tagging me in the caption using @%s. In 1-on-1 chats, simply send the file without tagging. Archives are unpacked for you; binaries and vendored directories (vendor, node_modules, .git, ...) are skipped.\n\n"+
+					"These files will be stored for <b>4 hours</b> only. Uploading a new file will overwrite your active project and reset its storage time; each project has its own storage time and size quota.\n\n"+
+					"<b>Short-Lived Web Responses:</b>\n"+
 					"The bot provides short-lived web response links for easier reading and navigation of your code outputs. Please save any outputs or files you wish to use for long-term purposes, as the web responses will expire after the specified duration.\n\n"+
-					"✅ *Reference Source Code:* After uploading your source code, you can reference it in your messages using `#source_code`. The bot will utilize your uploaded code to provide context-aware responses as long as the file is stored.",
+					"<b>Code Attachments:</b>\n"+
+					"Answers with large code blocks also include them as a downloadable file (or a zip when there are several).\n\n"+
+					"✅ <b>Reference Source Code:</b> After uploading your source code, you can reference it in your messages using <code>#source_code</code>. The bot will utilize your uploaded code to provide context-aware responses as long as the file is stored. Without it, I browse your active project myself in private chats, reading only the files I need, and include the definitions of Go functions and types you name. In group chats I only browse when you add <code>#browse</code> to your message.",
 				a.BotUsername,
 			)
 			err := a.SendMessage(message.Chat.ID, helpMsg, message.MessageID)
 			return "", err
 		case "/upload":
 			// This message will be handled in telegram_handler.go
-			uploadMsg := "✅ *Upload Command Removed in Group Chats*\n\nFor privacy reasons, please message me directly by clicking @" + a.BotUsername + " to upload your source code files."
+			uploadMsg := "✅ <b>Upload Command Removed in Group Chats</b>\n\nFor privacy reasons, please message me directly by clicking @" + a.BotUsername + " to upload your source code files."
 			err := a.SendMessage(message.Chat.ID, uploadMsg, message.MessageID)
 			return "", err
 		case "/mydata":
 			myData, err := a.GetUserData(userID)
 			if err != nil {
 				log.Printf("Failed to retrieve user data: %v", err)
-				errorMsg := "✅ *Error Retrieving Data*\n\nUnable to fetch your data at this time. Please try again later."
+				errorMsg := "✅ <b>Error Retrieving Data</b>\n\nUnable to fetch your data at this time. Please try again later."
 				a.SendMessage(message.Chat.ID, errorMsg, message.MessageID)
 				return "", err
 			}
@@ -515,34 +867,33 @@ func (a *App) HandleCommand(message *types.TelegramMessage, userID int, username
 			return "", err
 		case "/security":
 			securityMsg := fmt.Sprintf(
-				"🔒 *Security Information:*\n\n" +
+				"🔒 <b>Security Information:</b>\n\n" +
 					"Your data and responses are handled with the utmost security. Uploaded files are stored securely in S3 with strict access controls and are automatically deleted after 4 hours. All interactions are logged for auditing purposes.\n\n" +
-					"The project's source code is open-source, allowing for community review and contributions. You can view the code on GitHub here: [KernelSanders GitHub](https://github.com/joelradon/KernelSanders).\n\n" +
+					"The project's source code is open-source, allowing for community review and contributions. You can view the code on GitHub here: <a href=\"https://github.com/joelradon/KernelSanders\">KernelSanders GitHub</a>.\n\n" +
 					"Feel free to review the code and contribute to its development!\n\n" +
-					"✅ *Delete Your Data:* Use /delete_my_data to remove all your uploaded files and web responses.",
+					"✅ <b>Delete Your Data:</b> Use /delete_my_data to remove your uploaded files, web responses, conversation, settings, usage history and log entries. You will receive an itemized receipt.",
 			)
 			err := a.SendMessage(message.Chat.ID, securityMsg, message.MessageID)
 			return "", err
 		case "/project":
-			projectMsg := "🌟 *KernelSanders Project:*\n\n" +
+			projectMsg := "🌟 <b>KernelSanders Project:</b>\n\n" +
 				"The KernelSanders bot is an open-source project designed to assist you with your coding needs. Contributions are welcome! You can view the source code and contribute on GitHub: <a href=\"https://github.com/joelradon/KernelSanders\">KernelSanders GitHub</a>.\n\n" +
 				"If you find this tool useful, consider buying me a coffee: <a href=\"https://paypal.me/joelradon\">Buy me a Coffee</a>. Your support is greatly appreciated! ☕"
 			err := a.SendMessage(message.Chat.ID, projectMsg, message.MessageID)
 			return "", err
 		case "/my_source_code":
 			mySourceCodeMsg := fmt.Sprintf(
-				"# Overview\n\n"+
-					"These scripts facilitate the preparation and management of source code files, allowing users to easily gather and format their code for AI interactions with KernelSanders. By excluding certain files and ensuring only relevant file types are processed, they optimize the user’s experience when interacting with the AI bot.\n\n"+
-					"Both scripts are designed to:\n"+
-					"- List all files in the current directory and its subdirectories.\n"+
-					"- Print the contents of each file, excluding README.md.\n"+
-					"- Copy the output to the clipboard for easy pasting.\n\n"+
-					"Create a source code text file and upload it to Telegram. It will be stored for 4 hours and linked directly to your username. After that, it will be deleted.\n\n"+
-					"(short link)https://github.com/joelradon/KernelSanders/blob/main/utility_scripts/copy_source_code.bash\n"+
-					"(short link)https://github.com/joelradon/KernelSanders/blob/main/utility_scripts/copy_source_code.ps1\n\n"+
-					"**REMEMBER TO NEVER PUT SENSITIVE CODE ANYWHERE.** While this bot has a private store for each user and deletes each file after 4 hours, practice safe coding and don't put any sensitive information in your code base.\n\n"+
-					"✅ *Reference Source Code:* After uploading your source code, you can reference it in your messages using `#source_code`. The bot will utilize your uploaded code to provide context-aware responses as long as the file is stored.",
-				a.BotUsername,
+				"<b>Overview</b>\n\n" +
+					"These scripts facilitate the preparation and management of source code files, allowing users to easily gather and format their code for AI interactions with KernelSanders. By excluding certain files and ensuring only relevant file types are processed, they optimize the user’s experience when interacting with the AI bot.\n\n" +
+					"Both scripts are designed to:\n" +
+					"- List all files in the current directory and its subdirectories.\n" +
+					"- Print the contents of each file, excluding README.md.\n" +
+					"- Copy the output to the clipboard for easy pasting.\n\n" +
+					"Create a source code text file and upload it to Telegram. It will be stored for 4 hours and linked directly to your username. After that, it will be deleted.\n\n" +
+					"(short link)https://github.com/joelradon/KernelSanders/blob/main/utility_scripts/copy_source_code.bash\n" +
+					"(short link)https://github.com/joelradon/KernelSanders/blob/main/utility_scripts/copy_source_code.ps1\n\n" +
+					"<b>REMEMBER TO NEVER PUT SENSITIVE CODE ANYWHERE.</b> While this bot has a private store for each user and deletes each file after 4 hours, practice safe coding and don't put any sensitive information in your code base. Uploads are scanned and likely credentials (API keys, tokens, private keys, passwords) are replaced with <code>[REDACTED:kind]</code> before storage.\n\n" +
+					"✅ <b>Reference Source Code:</b> After uploading your source code, you can reference it in your messages using <code>#source_code</code>. The bot will utilize your uploaded code to provide context-aware responses as long as the file is stored.",
 			)
 			err := a.SendMessage(message.Chat.ID, mySourceCodeMsg, message.MessageID)
 			return "", err
@@ -550,14 +901,14 @@ func (a *App) HandleCommand(message *types.TelegramMessage, userID int, username
 			deleteMsg, err := a.DeleteUserData(userID)
 			if err != nil {
 				log.Printf("Failed to delete user data: %v", err)
-				errorMsg := "✅ *Error Deleting Data*\n\nUnable to delete your data at this time. Please try again later."
+				errorMsg := "✅ <b>Error Deleting Data</b>\n\nUnable to delete your data at this time. Please try again later."
 				a.SendMessage(message.Chat.ID, errorMsg, message.MessageID)
 				return "", err
 			}
 			err = a.SendMessage(message.Chat.ID, deleteMsg, message.MessageID)
 			return "", err
 		default:
-			unknownCmd := "❓ *Unknown command.* Type /help to see available commands."
+			unknownCmd := "❓ <b>Unknown command.</b> Type /help to see available commands."
 			err := a.SendMessage(message.Chat.ID, unknownCmd, message.MessageID)
 			return "", err
 		}
@@ -571,7 +922,7 @@ func (a *App) HandleSpecificCommand(command string, message *types.TelegramMessa
 		myData, err := a.GetUserData(userID)
 		if err != nil {
 			log.Printf("Failed to retrieve user data: %v", err)
-			errorMsg := "✅ *Error Retrieving Data*\n\nUnable to fetch your data at this time. Please try again later."
+			errorMsg := "✅ <b>Error Retrieving Data</b>\n\nUnable to fetch your data at this time. Please try again later."
 			a.SendMessage(message.Chat.ID, errorMsg, message.MessageID)
 			return "", err
 		}
@@ -579,34 +930,33 @@ func (a *App) HandleSpecificCommand(command string, message *types.TelegramMessa
 		return "", err
 	case "/security":
 		securityMsg := fmt.Sprintf(
-			"🔒 *Security Information:*\n\n" +
+			"🔒 <b>Security Information:</b>\n\n" +
 				"Your data and responses are handled with the utmost security. Uploaded files are stored securely in S3 with strict access controls and are automatically deleted after 4 hours. All interactions are logged for auditing purposes.\n\n" +
-				"The project's source code is open-source, allowing for community review and contributions. You can view the code on GitHub here: [KernelSanders GitHub](https://github.com/joelradon/KernelSanders).\n\n" +
+				"The project's source code is open-source, allowing for community review and contributions. You can view the code on GitHub here: <a href=\"https://github.com/joelradon/KernelSanders\">KernelSanders GitHub</a>.\n\n" +
 				"Feel free to review the code and contribute to its development!\n\n" +
-				"✅ *Delete Your Data:* Use /delete_my_data to remove all your uploaded files and web responses.",
+				"✅ <b>Delete Your Data:</b> Use /delete_my_data to remove your uploaded files, web responses, conversation, settings, usage history and log entries. You will receive an itemized receipt.",
 		)
 		err := a.SendMessage(message.Chat.ID, securityMsg, message.MessageID)
 		return "", err
 	case "/project":
-		projectMsg := "🌟 *KernelSanders Project:*\n\n" +
+		projectMsg := "🌟 <b>KernelSanders Project:</b>\n\n" +
 			"The KernelSanders bot is an open-source project designed to assist you with your coding needs. Contributions are welcome! You can view the source code and contribute on GitHub: <a href=\"https://github.com/joelradon/KernelSanders\">KernelSanders GitHub</a>.\n\n" +
 			"If you find this tool useful, consider buying me a coffee: <a href=\"https://paypal.me/joelradon\">Buy me a Coffee</a>. Your support is greatly appreciated! ☕"
 		err := a.SendMessage(message.Chat.ID, projectMsg, message.MessageID)
 		return "", err
 	case "/my_source_code":
 		mySourceCodeMsg := fmt.Sprintf(
-			"# Overview\n\n"+
-				"These scripts facilitate the preparation and management of source code files, allowing users to easily gather and format their code for AI interactions with KernelSanders. By excluding certain files and ensuring only relevant file types are processed, they optimize the user’s experience when interacting with the AI bot.\n\n"+
-				"Both scripts are designed to:\n"+
-				"- List all files in the current directory and its subdirectories.\n"+
-				"- Print the contents of each file, excluding README.md.\n"+
-				"- Copy the output to the clipboard for easy pasting.\n\n"+
-				"Create a source code text file and upload it to Telegram. It will be stored for 4 hours and linked directly to your username. After that, it will be deleted.\n\n"+
-				"(short link)https://github.com/joelradon/KernelSanders/blob/main/utility_scripts/copy_source_code.bash\n"+
-				"(short link)https://github.com/joelradon/KernelSanders/blob/main/utility_scripts/copy_source_code.ps1\n\n"+
-				"**REMEMBER TO NEVER PUT SENSITIVE CODE ANYWHERE.** While this bot has a private store for each user and deletes each file after 4 hours, practice safe coding and don't put any sensitive information in your code base.\n\n"+
-				"✅ *Reference Source Code:* After uploading your source code, you can reference it in your messages using `#source_code`. The bot will utilize your uploaded code to provide context-aware responses as long as the file is stored.",
-			a.BotUsername,
+			"<b>Overview</b>\n\n" +
+				"These scripts facilitate the preparation and management of source code files, allowing users to easily gather and format their code for AI interactions with KernelSanders. By excluding certain files and ensuring only relevant file types are processed, they optimize the user’s experience when interacting with the AI bot.\n\n" +
+				"Both scripts are designed to:\n" +
+				"- List all files in the current directory and its subdirectories.\n" +
+				"- Print the contents of each file, excluding README.md.\n" +
+				"- Copy the output to the clipboard for easy pasting.\n\n" +
+				"Create a source code text file and upload it to Telegram. It will be stored for 4 hours and linked directly to your username. After that, it will be deleted.\n\n" +
+				"(short link)https://github.com/joelradon/KernelSanders/blob/main/utility_scripts/copy_source_code.bash\n" +
+				"(short link)https://github.com/joelradon/KernelSanders/blob/main/utility_scripts/copy_source_code.ps1\n\n" +
+				"<b>REMEMBER TO NEVER PUT SENSITIVE CODE ANYWHERE.</b> While this bot has a private store for each user and deletes each file after 4 hours, practice safe coding and don't put any sensitive information in your code base. Uploads are scanned and likely credentials (API keys, tokens, private keys, passwords) are replaced with <code>[REDACTED:kind]</code> before storage.\n\n" +
+				"✅ <b>Reference Source Code:</b> After uploading your source code, you can reference it in your messages using <code>#source_code</code>. The bot will utilize your uploaded code to provide context-aware responses as long as the file is stored.",
 		)
 		err := a.SendMessage(message.Chat.ID, mySourceCodeMsg, message.MessageID)
 		return "", err
@@ -614,14 +964,14 @@ func (a *App) HandleSpecificCommand(command string, message *types.TelegramMessa
 		deleteMsg, err := a.DeleteUserData(userID)
 		if err != nil {
 			log.Printf("Failed to delete user data: %v", err)
-			errorMsg := "✅ *Error Deleting Data*\n\nUnable to delete your data at this time. Please try again later."
+			errorMsg := "✅ <b>Error Deleting Data</b>\n\nUnable to delete your data at this time. Please try again later."
 			a.SendMessage(message.Chat.ID, errorMsg, message.MessageID)
 			return "", err
 		}
 		err = a.SendMessage(message.Chat.ID, deleteMsg, message.MessageID)
 		return "", err
 	default:
-		unknownCmd := "❓ *Unknown command.* Type /help to see available commands."
+		unknownCmd := "❓ <b>Unknown command.</b> Type /help to see available commands."
 		err := a.SendMessage(message.Chat.ID, unknownCmd, message.MessageID)
 		return "", err
 	}
@@ -643,28 +993,28 @@ func (a *App) GetUserData(userID int) (string, error) {
 
 	// Build the response message
 	var sb strings.Builder
-	sb.WriteString("✅ *Your Data:*\n\n")
+	sb.WriteString("✅ <b>Your Data:</b>\n\n")
 
 	if len(files) > 0 {
-		sb.WriteString("*Uploaded Files:*\n")
+		sb.WriteString("<b>Uploaded Files:</b>\n")
 		for _, file := range files {
 			fileURL := a.GenerateFileURL(file.FileName)
-			sb.WriteString(fmt.Sprintf("- <a href=\"%s\">%s</a>\n", fileURL, file.FileName))
+			sb.WriteString(fmt.Sprintf("- <a href=\"%s\">%s</a> (project <b>%s</b>)\n", fileURL, EscapeHTML(file.FileName), EscapeHTML(file.Project)))
 		}
 		sb.WriteString("\n")
 	} else {
-		sb.WriteString("*No uploaded files found.*\n\n")
+		sb.WriteString("<b>No uploaded files found.</b>\n\n")
 	}
 
 	if len(responses) > 0 {
-		sb.WriteString("*Web Responses:*\n")
+		sb.WriteString("<b>Web Responses:</b>\n")
 		for _, resp := range responses {
 			responseURL := a.GenerateResponseURL(resp.ID)
 			sb.WriteString(fmt.Sprintf("- <a href=\"%s\">Response ID: %s</a>\n", responseURL, resp.ID))
 		}
 		sb.WriteString("\n")
 	} else {
-		sb.WriteString("*No web responses found.*\n")
+		sb.WriteString("<b>No web responses found.</b>\n")
 	}
 
 	return sb.String(), nil
@@ -689,8 +1039,13 @@ func (a *App) ListUserFiles(userID int) ([]types.UserFile, error) {
 
 	var files []types.UserFile
 
+	legacy := false
 	err := a.S3Client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
 		for _, obj := range page.Contents {
+			if *obj.Key == legacySourceCodeKey(userID) {
+				legacy = true
+				continue
+			}
 			// Retrieve metadata to get upload time
 			headInput := &s3.HeadObjectInput{
 				Bucket: aws.String(a.S3BucketName),
@@ -702,22 +1057,37 @@ func (a *App) ListUserFiles(userID int) ([]types.UserFile, error) {
 				continue
 			}
 
-			uploadedAtStr, exists := headResp.Metadata["uploaded_at"]
-			if !exists || uploadedAtStr == nil {
+			uploadedAtStr, exists := metadataValue(headResp.Metadata, "uploaded_at")
+			if !exists {
 				log.Printf("No 'uploaded_at' metadata for object %s. Skipping.", *obj.Key)
 				continue
 			}
 
-			uploadedAt, err := time.Parse(time.RFC3339, *uploadedAtStr)
+			uploadedAt, err := time.Parse(time.RFC3339, uploadedAtStr)
 			if err != nil {
 				log.Printf("Invalid 'uploaded_at' format for object %s: %v", *obj.Key, err)
 				continue
 			}
 
 			deletionTime := uploadedAt.Add(types.FileRetentionTime)
+			if time.Now().After(deletionTime) {
+				// Enforce the retention period for this project
+				a.deleteObject(*obj.Key)
+				continue
+			}
+
+			// Quotas apply to the source code, not to the encrypted object
+			size := aws.Int64Value(obj.Size)
+			if sourceBytes, ok := metadataValue(headResp.Metadata, "source_bytes"); ok {
+				if n, err := strconv.ParseInt(sourceBytes, 10, 64); err == nil {
+					size = n
+				}
+			}
 
 			files = append(files, types.UserFile{
 				FileName:        *obj.Key,
+				Project:         projectFromKey(*obj.Key),
+				SizeBytes:       size,
 				UploadedAtUTC:   uploadedAt.UTC(),
 				UploadedAtEDT:   uploadedAt.In(time.FixedZone("EDT", -4*3600)),
 				DeletionTimeUTC: deletionTime.UTC(),
@@ -731,13 +1101,28 @@ func (a *App) ListUserFiles(userID int) ([]types.UserFile, error) {
 		return nil, err
 	}
 
+	if legacy {
+		// Source code uploaded before projects existed becomes the default project
+		if migrated, err := a.migrateLegacySource(userID); err != nil {
+			log.Printf("Failed to migrate legacy source code for user %d: %v", userID, err)
+		} else if migrated {
+			return a.ListUserFiles(userID)
+		}
+	}
+
 	return files, nil
 }
 
-// Shutdown gracefully shuts down the application, ensuring all goroutines are terminated.
+// Shutdown gracefully shuts down the application, stopping the cleanup goroutines of its caches and stores.
 func (a *App) Shutdown() {
 	close(a.ShutdownChan)
-	a.wg.Wait()
+	a.Cache.Stop()
+	a.SummaryCache.Stop()
+	a.logCacheStats()
+	a.ConversationContexts.Close()
+	a.ResponseStore.Close()
+	a.UsageCache.Close()
+	a.AnalysisUsage.Close()
 	log.Println("Application has been shut down gracefully.")
 }
 
@@ -749,17 +1134,18 @@ func (a *App) logToS3(userID int, username, userPrompt, responseTime string, isN
 	// Extract keywords from the user prompt
 	keywords := utils.ExtractKeywords(userPrompt)
 
+	// The prompt and its keywords are sealed with the user's key so destroying it covers the log
 	record := []string{
 		fmt.Sprintf("%d", userID),
 		username,
-		userPrompt,
-		keywords, // Added keywords to the CSV record
+		a.sealLogCell(userID, userPrompt),
+		a.sealLogCell(userID, keywords), // Added keywords to the CSV record
 		responseTime,
 		fmt.Sprintf("No limit user: %t", isNoLimitUser),
 	}
 
 	bucketName := a.S3BucketName
-	objectKey := "logs/telegram_logs.csv"
+	objectKey := logObjectKey
 
 	// Check if the CSV file exists
 	_, err := a.S3Client.HeadObject(&s3.HeadObjectInput{
@@ -828,17 +1214,44 @@ func (a *App) logToS3(userID int, username, userPrompt, responseTime string, isN
 	}
 }
 
-// StoreUserSourceCode stores the user's source code to S3.
+// logObjectKey is the S3 object holding the interaction log CSV.
+const logObjectKey = "logs/telegram_logs.csv"
+
+// StoreUserSourceCode stores the user's source code in their active project, resetting its retention time.
 func (a *App) StoreUserSourceCode(userID int, code string) error {
-	objectKey := fmt.Sprintf("user_source_code/%d/source_code.txt", userID)
+	project := a.GetActiveProject(userID)
+	if len(code) > types.MaxProjectBytes {
+		return fmt.Errorf("%w: %d bytes exceeds the %d byte limit", types.ErrProjectQuotaExceeded, len(code), types.MaxProjectBytes)
+	}
+	if err := a.checkProjectCapacity(userID, project); err != nil {
+		return err
+	}
+	return a.putSourceCode(userID, project, code)
+}
+
+// putSourceCode encrypts a project's source code and uploads it to S3 with a fresh upload timestamp.
+func (a *App) putSourceCode(userID int, project, code string) error {
+	return a.putSourceCodeUploadedAt(userID, project, code, time.Now())
+}
+
+// putSourceCodeUploadedAt encrypts a project's source code and uploads it to S3 with the given upload
+// timestamp, which starts its retention period. The plaintext size is recorded for quota reporting.
+func (a *App) putSourceCodeUploadedAt(userID int, project, code string, uploadedAt time.Time) error {
+	body, err := a.Keys.Encrypt(userID, []byte(code))
+	if err != nil {
+		log.Printf("Failed to encrypt source code for user %d: %v", userID, err)
+		return err
+	}
+
 	metadata := map[string]*string{
-		"uploaded_at": aws.String(time.Now().Format(time.RFC3339)),
+		"uploaded_at":  aws.String(uploadedAt.Format(time.RFC3339)),
+		"source_bytes": aws.String(strconv.Itoa(len(code))),
 	}
 
-	_, err := a.S3Client.PutObject(&s3.PutObjectInput{
+	_, err = a.S3Client.PutObject(&s3.PutObjectInput{
 		Bucket:   aws.String(a.S3BucketName),
-		Key:      aws.String(objectKey),
-		Body:     strings.NewReader(code),
+		Key:      aws.String(sourceCodeKey(userID, project)),
+		Body:     bytes.NewReader(body),
 		Metadata: metadata,
 	})
 	if err != nil {
@@ -848,54 +1261,87 @@ func (a *App) StoreUserSourceCode(userID int, code string) error {
 	return nil
 }
 
-// GetUserSourceCode retrieves the user's stored source code from S3.
+// GetUserSourceCode retrieves the source code of the user's active project from S3.
+// Expired or empty projects are reported as missing.
 func (a *App) GetUserSourceCode(userID int) (string, bool) {
-	objectKey := fmt.Sprintf("user_source_code/%d/source_code.txt", userID)
+	return a.getProjectSource(userID, a.GetActiveProject(userID))
+}
+
+// getProjectSource retrieves and decrypts the source code of one of the user's projects.
+func (a *App) getProjectSource(userID int, project string) (string, bool) {
+	objectKey := sourceCodeKey(userID, project)
 	resp, err := a.S3Client.GetObject(&s3.GetObjectInput{
 		Bucket: aws.String(a.S3BucketName),
 		Key:    aws.String(objectKey),
 	})
 	if err != nil {
-		log.Printf("Failed to retrieve source code from S3 for user %d: %v", userID, err)
-		return "", false
+		if !isNotFound(err) {
+			log.Printf("Failed to retrieve source code from S3 for user %d: %v", userID, err)
+			return "", false
+		}
+		if project != types.DefaultProjectName {
+			return "", false
+		}
+		if migrated, err := a.migrateLegacySource(userID); err != nil {
+			log.Printf("Failed to migrate legacy source code for user %d: %v", userID, err)
+			return "", false
+		} else if !migrated {
+			return "", false
+		}
+		return a.getProjectSource(userID, project)
 	}
 	defer resp.Body.Close()
 
+	if uploadedAtStr, ok := metadataValue(resp.Metadata, "uploaded_at"); ok {
+		if uploadedAt, err := time.Parse(time.RFC3339, uploadedAtStr); err == nil && time.Since(uploadedAt) > types.FileRetentionTime {
+			a.deleteObject(objectKey)
+			return "", false
+		}
+	}
+
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("Failed to read source code body for user %d: %v", userID, err)
 		return "", false
 	}
+	bodyBytes, err = a.Keys.Decrypt(userID, bodyBytes)
+	if err != nil {
+		log.Printf("Failed to decrypt source code for user %d: %v", userID, err)
+		return "", false
+	}
+	if len(bodyBytes) == 0 {
+		return "", false
+	}
 
 	return string(bodyBytes), true
 }
 
-// DeleteUserData deletes all uploaded source code files and web responses for a user.
-func (a *App) DeleteUserData(userID int) (string, error) {
-	// Delete source code files
-	objectKey := fmt.Sprintf("user_source_code/%d/source_code.txt", userID)
+// metadataValue looks up an S3 metadata entry case-insensitively, since S3 returns canonicalized header names.
+func metadataValue(metadata map[string]*string, key string) (string, bool) {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) && v != nil {
+			return *v, true
+		}
+	}
+	return "", false
+}
+
+// isNotFound reports whether an S3 error means the requested object does not exist.
+func isNotFound(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey
+}
+
+// deleteObject removes a single object from the bucket, logging failures.
+func (a *App) deleteObject(objectKey string) error {
 	_, err := a.S3Client.DeleteObject(&s3.DeleteObjectInput{
 		Bucket: aws.String(a.S3BucketName),
 		Key:    aws.String(objectKey),
 	})
 	if err != nil {
-		log.Printf("Failed to delete source code from S3 for user %d: %v", userID, err)
-		return "", err
-	}
-
-	// Delete all web responses associated with the user
-	responses, err := a.ResponseStore.GetUserResponsesByUserID(userID)
-	if err != nil {
-		log.Printf("Failed to retrieve user responses for deletion: %v", err)
-		return "", err
-	}
-
-	for _, resp := range responses {
-		a.ResponseStore.DeleteResponse(resp.ID)
+		log.Printf("Failed to delete %s from S3: %v", objectKey, err)
 	}
-
-	deleteMsg := "✅ *Data Deleted Successfully*\n\nAll your uploaded files and web responses have been deleted."
-	return deleteMsg, nil
+	return err
 }
 
 // GetSummary generates a brief summary using the OpenAI API.
@@ -916,23 +1362,3 @@ func (a *App) GetSummary(prompt string) (string, error) {
 	summary = strings.TrimSpace(summary)
 	return summary, nil
 }
-
-// AnalyzeUserCode generates a brief summary of the user's uploaded code.
-func (a *App) AnalyzeUserCode(userID int) (string, error) {
-	// Retrieve the user's source code
-	code, exists := a.GetUserSourceCode(userID)
-	if !exists {
-		return "", errors.New("no source code found for user")
-	}
-
-	// Create a prompt for summarization
-	prompt := fmt.Sprintf("Provide a concise two-sentence summary of the following source code:\n\n%s", code)
-
-	// Get the summary using the GetSummary method
-	summary, err := a.GetSummary(prompt)
-	if err != nil {
-		return "", err
-	}
-
-	return summary, nil
-}
@@ -0,0 +1,143 @@
+// internal/app/attachments.go
+
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"KernelSandersBot/internal/markdown"
+)
+
+// codeAttachmentMinLines is the size at which a code block is considered large enough to be sent as a file.
+const codeAttachmentMinLines = 30
+
+// SendDocument uploads a file to a Telegram chat using the sendDocument API.
+func (a *App) SendDocument(chatID int64, fileName string, data []byte, caption string, replyToMessageID int) error {
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendDocument", a.TelegramToken)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	fields := map[string]string{
+		"chat_id":    fmt.Sprintf("%d", chatID),
+		"caption":    caption,
+		"parse_mode": "HTML",
+	}
+	if replyToMessageID != 0 {
+		fields["reply_to_message_id"] = fmt.Sprintf("%d", replyToMessageID)
+	}
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+
+	part, err := writer.CreateFormFile("document", fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := a.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	return nil
+}
+
+// sendCodeAttachments sends the large code blocks of an answer as a downloadable file.
+// A single large block is sent as a source file named after its language; several are bundled into a zip.
+func (a *App) sendCodeAttachments(chatID int64, responseText, responseID string, replyToMessageID int) {
+	var large []markdown.CodeBlock
+	for _, block := range markdown.ExtractCodeBlocks(responseText) {
+		if block.LineCount() >= codeAttachmentMinLines {
+			large = append(large, block)
+		}
+	}
+	if len(large) == 0 {
+		return
+	}
+
+	baseName := "kernelsanders_" + shortResponseID(responseID)
+	var (
+		fileName string
+		data     []byte
+	)
+	if len(large) == 1 {
+		fileName = fmt.Sprintf("%s.%s", baseName, large[0].FileExtension())
+		data = []byte(large[0].Code)
+	} else {
+		zipped, err := zipCodeBlocks(large)
+		if err != nil {
+			log.Printf("Failed to zip code blocks for response %s: %v", responseID, err)
+			return
+		}
+		fileName = baseName + ".zip"
+		data = zipped
+	}
+
+	caption := fmt.Sprintf("📎 <b>Code from this answer</b> (%d block(s))", len(large))
+	if err := a.SendDocument(chatID, fileName, data, caption, replyToMessageID); err != nil {
+		log.Printf("Failed to send code attachment to Telegram: %v", err)
+	}
+}
+
+// zipCodeBlocks bundles code blocks into a zip archive, one numbered file per block.
+func zipCodeBlocks(blocks []markdown.CodeBlock) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i, block := range blocks {
+		w, err := zw.Create(fmt.Sprintf("block_%02d.%s", i+1, block.FileExtension()))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(w, block.Code); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// shortResponseID returns the first segment of a response UUID for use in file names.
+func shortResponseID(responseID string) string {
+	if i := strings.Index(responseID, "-"); i > 0 {
+		return responseID[:i]
+	}
+	if responseID == "" {
+		return "answer"
+	}
+	return responseID
+}
@@ -0,0 +1,237 @@
+// internal/app/code_analysis.go
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"KernelSandersBot/internal/analysis"
+	"KernelSandersBot/internal/api"
+	"KernelSandersBot/internal/sourcetree"
+	"KernelSandersBot/internal/types"
+)
+
+// Analysis limits.
+const (
+	maxOverviewDirectories = 20      // Directories given a model-generated overview, largest first
+	maxSummarizedFiles     = 200     // Files summarized in one analysis
+	summaryWorkers         = 4       // Concurrent model requests while summarizing
+	summaryCacheEntries    = 5000    // File summaries kept for re-uploads
+	summaryCacheBytes      = 8 << 20 // Bytes of file summaries kept for re-uploads
+	digestOverviewChars    = 600     // Characters of each overview used to write the summary
+)
+
+// Analysis limits for users subject to the rate limit. The model requests of an analysis are charged to
+// a separate analysis allowance rather than the per-question budget; it covers one full analysis, at most
+// limitedSummarizedFiles+limitedOverviewDirectories+1 requests, per window.
+const (
+	limitedOverviewDirectories = 2
+	limitedSummarizedFiles     = 5
+	analysisAllowance          = limitedSummarizedFiles + limitedOverviewDirectories + 1
+	analysisAllowanceWindow    = 30 * time.Minute
+)
+
+// errAnalysisRateLimited is returned for analysis requests the user's analysis allowance cannot cover.
+var errAnalysisRateLimited = errors.New("analysis allowance reached")
+
+// directoryOverviewPrompt asks for the architecture overview of one directory from its file summaries.
+const directoryOverviewPrompt = "Below are summaries of the files in the directory %q of the project %q. In 3-5 sentences " +
+	"of Markdown, describe this directory's role in the architecture: its responsibilities, the main types and functions, " +
+	"and how it relates to the rest of the project. Do not list every file.\n\n%s"
+
+// projectSummaryPrompt asks for a short summary of a project from its statistics and directory overviews.
+const projectSummaryPrompt = "Write a 2-3 sentence summary of the project %q for its developer: what it is, how it is " +
+	"structured and its main technologies. Use these statistics and directory overviews.\n\n%s"
+
+// AnalyzeUserCode analyzes the source code of the user's active project with map-reduce: local statistics
+// (files, languages, lines, largest files, dependency manifests), a summary of each file, an architecture
+// overview of each directory reduced from its file summaries, and a short project summary reduced from
+// those. Model requests run on a bounded worker pool, and file summaries are cached by content hash so a
+// re-upload only re-summarizes changed files; the other prompts go through the completion cache. Users
+// with /nocache bypass both. Users subject to the rate limit get a smaller analysis, taking the largest
+// files of the largest directories, whose model requests are charged to their analysis allowance and
+// skipped once it is used up. The full report is stored as a web response; the returned Telegram HTML
+// digest links to it.
+func (a *App) AnalyzeUserCode(userID int) (string, error) {
+	tree, project, ok := a.activeSourceTree(userID)
+	if !ok {
+		return "", errors.New("no source code found for user")
+	}
+
+	maxDirectories, maxFiles := maxOverviewDirectories, maxSummarizedFiles
+	if !a.isNoLimitUser(userID) {
+		maxDirectories, maxFiles = limitedOverviewDirectories, limitedSummarizedFiles
+	}
+
+	report := analysis.Report{Project: project, Stats: analysis.Compute(tree)}
+	var rateLimited int32
+	query := a.summaryQuery(userID, &rateLimited)
+	summarizer := &analysis.Summarizer{Query: query, Workers: summaryWorkers, CachePrefix: summaryPrefix(userID)}
+	if !a.GetUserSettings(userID).NoCache {
+		summarizer.Cache = a.SummaryCache
+	}
+
+	// Map: summarize the files of the largest directories
+	var dirs []analysis.Directory
+	var files []sourcetree.File
+	for _, dir := range report.Stats.Directories {
+		if len(dirs) == maxDirectories || len(files) == maxFiles {
+			report.Omitted = append(report.Omitted, dir)
+			continue
+		}
+		dirFiles := largestFiles(tree, dir, maxFiles-len(files))
+		if len(dirFiles) == 0 {
+			report.Omitted = append(report.Omitted, dir)
+			continue
+		}
+		dirs = append(dirs, dir)
+		files = append(files, dirFiles...)
+	}
+	fileSummaries, cached, failed := summarizer.SummarizeFiles(files)
+	report.Cached, report.Failed = cached, failed
+	log.Printf("Summarized %d files for user %d (%d cached, %d failed)", len(files), userID, cached, failed)
+
+	// Reduce: an overview of each directory from its file summaries
+	prompts := make([]string, len(dirs))
+	for i, dir := range dirs {
+		prompts[i] = fmt.Sprintf(directoryOverviewPrompt, dir.Path, project, directorySummaries(dir, fileSummaries))
+	}
+	overviews, errs := summarizer.Run(prompts)
+	for i, dir := range dirs {
+		overview := overviews[i]
+		if errs[i] != nil || overview == "" {
+			log.Printf("Failed to get overview of directory %s for user %d: %v", dir.Path, userID, errs[i])
+			overview = "_Overview unavailable._"
+		}
+		summaries := make(map[string]string)
+		for _, filePath := range dir.Files {
+			if summary, ok := fileSummaries[filePath]; ok {
+				summaries[filePath] = summary
+			}
+		}
+		report.Overviews = append(report.Overviews, analysis.DirectoryOverview{Directory: dir, Overview: overview, FileSummaries: summaries})
+	}
+
+	// Reduce: the project summary from the statistics and overviews
+	summary, err := query(fmt.Sprintf(projectSummaryPrompt, project, summaryInput(report)))
+	if err != nil {
+		log.Printf("Failed to summarize project for user %d: %v", userID, err)
+	} else {
+		report.Summary = summary
+	}
+
+	report.RateLimited = int(atomic.LoadInt32(&rateLimited))
+	responseID := a.ResponseStore.StoreResponseForUser(report.Markdown(), userID)
+	return formatAnalysisDigest(report, a.GenerateResponseURL(responseID)), nil
+}
+
+// summaryQuery returns the query used by an analysis: GetSummary through the completion cache, so
+// re-analyzing unchanged code reuses the directory overviews and project summary. Requests that miss
+// the cache are charged to the user's analysis allowance; those it cannot cover fail and are counted in rateLimited.
+func (a *App) summaryQuery(userID int, rateLimited *int32) func(prompt string) (string, error) {
+	return func(prompt string) (string, error) {
+		messages := []types.OpenAIMessage{{Role: "user", Content: prompt}}
+		opts := api.QueryOptions{}
+		if summary, ok := a.cachedCompletion(userID, messages, opts); ok {
+			return strings.TrimSpace(summary), nil
+		}
+		if !a.chargeAnalysisRequest(userID) {
+			atomic.AddInt32(rateLimited, 1)
+			return "", errAnalysisRateLimited
+		}
+		summary, err := a.APIHandler.QueryOpenAIWithOptions(messages, opts)
+		if err != nil {
+			return "", err
+		}
+		a.cacheCompletion(userID, messages, opts, summary)
+		return strings.TrimSpace(summary), nil
+	}
+}
+
+// chargeAnalysisRequest charges one analysis request to the user's analysis allowance and reports
+// whether the allowance covered it. The questions the user asks keep their own budget. Users exempt
+// from the rate limit are always covered.
+func (a *App) chargeAnalysisRequest(userID int) bool {
+	if a.isNoLimitUser(userID) {
+		return true
+	}
+	return a.AnalysisUsage.TrySpend(userID, 1)
+}
+
+// largestFiles returns up to limit files of a directory, largest first.
+func largestFiles(tree *sourcetree.Tree, dir analysis.Directory, limit int) []sourcetree.File {
+	var files []sourcetree.File
+	for _, filePath := range dir.Files {
+		if file, ok := tree.Get(filePath); ok {
+			files = append(files, file)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return len(files[i].Content) > len(files[j].Content) })
+	if len(files) > limit {
+		files = files[:limit]
+	}
+	return files
+}
+
+// directorySummaries lists the file summaries of a directory for its overview prompt.
+func directorySummaries(dir analysis.Directory, fileSummaries map[string]string) string {
+	var sb strings.Builder
+	for _, filePath := range dir.Files {
+		summary, ok := fileSummaries[filePath]
+		if !ok {
+			summary = "(no summary)"
+		}
+		sb.WriteString(fmt.Sprintf("- %s: %s\n", filePath, summary))
+	}
+	return sb.String()
+}
+
+// summaryInput condenses a report's statistics and overviews for the summary prompt.
+func summaryInput(report analysis.Report) string {
+	s := report.Stats
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%d files, %d lines. Languages: %s.\n", s.Files, s.Lines, s.LanguageShare(5)))
+	for _, m := range s.Manifests {
+		sb.WriteString(fmt.Sprintf("Manifest: %s (%s)\n", m.Path, m.Kind))
+	}
+	for _, o := range report.Overviews {
+		sb.WriteString(fmt.Sprintf("\nDirectory %s: %s\n", o.Path, previewText(o.Overview, digestOverviewChars)))
+	}
+	return sb.String()
+}
+
+// formatAnalysisDigest renders the chat digest of an analysis with a link to the full report.
+func formatAnalysisDigest(report analysis.Report, link string) string {
+	s := report.Stats
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📊 <b>Project Analysis: %s</b>\n\n", EscapeHTML(report.Project)))
+	sb.WriteString(fmt.Sprintf("• <b>Size:</b> %d files, %d lines in %d directories\n", s.Files, s.Lines, len(s.Directories)))
+	if share := s.LanguageShare(3); share != "" {
+		sb.WriteString(fmt.Sprintf("• <b>Languages:</b> %s\n", EscapeHTML(share)))
+	}
+	if len(s.LargestFiles) > 0 {
+		sb.WriteString(fmt.Sprintf("• <b>Largest file:</b> <code>%s</code> (%d lines)\n", EscapeHTML(s.LargestFiles[0].Path), s.LargestFiles[0].Lines))
+	}
+	if len(s.Manifests) > 0 {
+		var manifests []string
+		for _, m := range s.Manifests {
+			manifests = append(manifests, m.Path)
+		}
+		sb.WriteString(fmt.Sprintf("• <b>Manifests:</b> %s\n", EscapeHTML(strings.Join(manifests, ", "))))
+	}
+	if report.Summary != "" {
+		sb.WriteString("\n" + EscapeHTML(previewText(report.Summary, 1200)) + "\n")
+	}
+	if report.RateLimited > 0 {
+		sb.WriteString("\n⚠️ <i>Part of this analysis was skipped because your rate limit was used up.</i>\n")
+	}
+	sb.WriteString(fmt.Sprintf("\n<a href=\"%s\">View the full analysis with an architecture overview per directory</a>\n\n", link))
+	sb.WriteString("Ask about your code directly and I'll browse it (add <code>#browse</code> in group chats), or use <code>#source_code</code> to include all of it.")
+	return sb.String()
+}
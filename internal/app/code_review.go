@@ -0,0 +1,178 @@
+// internal/app/code_review.go
+
+package app
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"KernelSandersBot/internal/review"
+	"KernelSandersBot/internal/sourcetree"
+	"KernelSandersBot/internal/types"
+)
+
+// Review limits.
+const (
+	maxReviewBytes       = 120 << 10 // Numbered source sent to the model in one review
+	reviewMaxTokens      = 4000      // Room for the findings JSON, unless the user asked for more
+	reviewTemperature    = 0.2
+	maxChatReviewFinding = 10 // Findings shown in chat; the web response has all of them
+)
+
+// handleReviewCommand processes /review [path]: it reviews the user's active project, or a file or
+// directory of it, and replies with the findings in chat and as a sortable table on the web response page.
+func (a *App) handleReviewCommand(args string, message *types.TelegramMessage, userID int, username string) (string, error) {
+	chatID := message.Chat.ID
+	tree, project, ok := a.activeSourceTree(userID)
+	if !ok {
+		errMsg := "❗ <b>No Source Code Found</b>\n\nUpload your project first; /review works on your active project."
+		return "", a.SendMessage(chatID, errMsg, message.MessageID)
+	}
+
+	target := sourcetree.CleanPath(args)
+	selected := selectFiles(tree, target)
+	if len(selected.Files) == 0 {
+		errMsg := fmt.Sprintf("❗ <b>Nothing to Review</b>\n\nNo file or directory <code>%s</code> in project <b>%s</b>.", EscapeHTML(target), EscapeHTML(project))
+		return "", a.SendMessage(chatID, errMsg, message.MessageID)
+	}
+	code, skipped := review.Format(selected, maxReviewBytes)
+	if code == "" {
+		errMsg := "❗ <b>Too Large to Review</b>\n\nThe selected code exceeds the review size limit. Use /review &lt;file&gt; to review a single file."
+		return "", a.SendMessage(chatID, errMsg, message.MessageID)
+	}
+
+	opts, model := a.queryOptionsFor(userID)
+	opts.JSON = true
+	if opts.MaxTokens < reviewMaxTokens {
+		opts.MaxTokens = reviewMaxTokens
+		if opts.MaxTokens > a.MaxCompletionTokens {
+			opts.MaxTokens = a.MaxCompletionTokens
+		}
+	}
+	if opts.Temperature == nil {
+		temperature := reviewTemperature
+		opts.Temperature = &temperature
+	}
+	prompt := []types.OpenAIMessage{
+		{Role: "system", Content: review.Prompt},
+		{Role: "user", Content: code},
+	}
+
+	// A review of unchanged code is answered from the cache without counting against the limit
+	startTime := time.Now()
+	reply, cached := a.cachedCompletion(userID, prompt, opts)
+	if !cached {
+		if err := a.enforceRateLimit(chatID, userID, username, "/review "+args, message.MessageID, model); err != nil {
+			return "", err
+		}
+		opts = a.meterRequests(userID, model, opts)
+		a.SendMessage(chatID, fmt.Sprintf("🔍 <b>Reviewing</b> %s…", EscapeHTML(selected.Summary())), message.MessageID)
+
+		var err error
+		if reply, err = a.APIHandler.QueryOpenAIWithOptions(prompt, opts); err != nil {
+			log.Printf("Review query failed: %v", err)
+			a.SendMessage(chatID, "❌ <b>Review Failed</b>\n\nThe review could not be completed. Please try again later.", message.MessageID)
+			return "", err
+		}
+	}
+	findings, dropped, err := review.Parse(reply, selected)
+	if err != nil {
+		// Ask once for a corrected reply before giving up
+		log.Printf("Review reply did not match the schema, retrying: %v", err)
+		messages := append(append([]types.OpenAIMessage(nil), prompt...),
+			types.OpenAIMessage{Role: "assistant", Content: reply},
+			types.OpenAIMessage{Role: "user", Content: fmt.Sprintf("Your reply could not be used: %v. Reply again with only the JSON object in the required form.", err)},
+		)
+		if reply, err = a.APIHandler.QueryOpenAIWithOptions(messages, opts); err == nil {
+			findings, dropped, err = review.Parse(reply, selected)
+		}
+		if err != nil {
+			log.Printf("Review reply invalid after retry: %v", err)
+			a.SendMessage(chatID, "❌ <b>Review Failed</b>\n\nThe review did not produce valid findings. Please try again.", message.MessageID)
+			return "", err
+		}
+	}
+	if dropped > 0 {
+		log.Printf("Dropped %d invalid review findings for user %d", dropped, userID)
+	}
+	// Only replies that passed validation are reused
+	a.cacheCompletion(userID, prompt, opts, reply)
+
+	title := fmt.Sprintf("Code review of %s", project)
+	if target != "" {
+		title = fmt.Sprintf("Code review of %s/%s", project, target)
+	}
+	responseID := a.ResponseStore.StoreResponseForUser(review.Markdown(title, findings, skipped), userID)
+	reviewMessage := formatReviewMessage(findings, skipped, a.GenerateResponseURL(responseID))
+	if cached {
+		reviewMessage = cachedNote + reviewMessage
+	}
+	err = a.SendMessage(chatID, reviewMessage, message.MessageID)
+
+	a.logToS3(userID, username, "/review "+args, fmt.Sprintf("%d ms", time.Since(startTime).Milliseconds()), a.isNoLimitUser(userID))
+	return "", err
+}
+
+// selectFiles returns the files of a tree at or under target. An empty target selects the whole tree.
+func selectFiles(tree *sourcetree.Tree, target string) *sourcetree.Tree {
+	if target == "" {
+		return tree
+	}
+	selected := &sourcetree.Tree{}
+	for _, file := range tree.Files {
+		if file.Path == target || strings.HasPrefix(file.Path, target+"/") {
+			selected.Files = append(selected.Files, file)
+		}
+	}
+	return selected
+}
+
+// severityIcons marks findings by severity in chat.
+var severityIcons = map[string]string{
+	"critical": "🟥",
+	"high":     "🔴",
+	"medium":   "🟠",
+	"low":      "🟡",
+	"info":     "🔵",
+}
+
+// formatReviewMessage renders the most severe findings for chat, with a link to the full table.
+func formatReviewMessage(findings []review.Finding, skipped []string, link string) string {
+	var sb strings.Builder
+	if len(findings) == 0 {
+		sb.WriteString("✅ <b>Review Complete</b>\n\nNo issues found.\n")
+	} else {
+		counts := review.Counts(findings)
+		var summary []string
+		for _, s := range review.Severities {
+			if counts[s] > 0 {
+				summary = append(summary, fmt.Sprintf("%s %d %s", severityIcons[s], counts[s], s))
+			}
+		}
+		sb.WriteString(fmt.Sprintf("🔍 <b>Review Complete</b> — %d findings\n%s\n\n", len(findings), strings.Join(summary, " · ")))
+		for i, f := range findings {
+			if i == maxChatReviewFinding {
+				sb.WriteString(fmt.Sprintf("… %d more in the full review.\n\n", len(findings)-maxChatReviewFinding))
+				break
+			}
+			entry := fmt.Sprintf("%s <b>%s</b> · %s — <code>%s</code>\n%s\n",
+				severityIcons[f.Severity], f.Severity, EscapeHTML(f.Category), EscapeHTML(f.Location()), EscapeHTML(previewText(f.Message, 300)))
+			if f.SuggestedFix != "" {
+				entry += fmt.Sprintf("💡 %s\n", EscapeHTML(previewText(f.SuggestedFix, 200)))
+			}
+			// Leave room for the link and the skipped-files note within Telegram's limit
+			if sb.Len()+len(entry) > 3400 {
+				sb.WriteString(fmt.Sprintf("… %d more in the full review.\n\n", len(findings)-i))
+				break
+			}
+			sb.WriteString(entry + "\n")
+		}
+	}
+	if len(skipped) > 0 {
+		sb.WriteString(fmt.Sprintf("⚠️ %d file(s) were not reviewed because of the size limit. Use /review &lt;path&gt; to review them separately.\n\n", len(skipped)))
+	}
+	sb.WriteString(fmt.Sprintf("<a href=\"%s\">View the full review as a sortable table</a>", link))
+	return sb.String()
+}
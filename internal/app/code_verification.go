@@ -0,0 +1,56 @@
+// internal/app/code_verification.go
+
+package app
+
+import (
+	"fmt"
+	"log"
+
+	"KernelSandersBot/internal/api"
+	"KernelSandersBot/internal/gocheck"
+	"KernelSandersBot/internal/types"
+)
+
+// syntaxFixPrompt asks the model to repair the Go code blocks of its answer.
+const syntaxFixPrompt = "The Go code blocks in your answer do not parse:\n%s\n\n" +
+	"Reply again with your complete answer, changing nothing but the syntax of the Go code so that every ```go " +
+	"block parses. Fragments are fine as long as they are complete declarations or statements."
+
+// verifyGoCode checks the ```go blocks of an answer with go/parser and formats them with go/format. If a
+// block does not parse, the model is asked once to fix the syntax; the fix is used if it has fewer errors.
+func (a *App) verifyGoCode(messages []types.OpenAIMessage, opts api.QueryOptions, answer string) string {
+	checked, result := gocheck.Check(answer)
+	if len(result.Errors) == 0 {
+		return checked
+	}
+	log.Printf("Answer has %d Go block(s) with syntax errors, asking for a fix", len(result.Errors))
+
+	fixMessages := append(append([]types.OpenAIMessage(nil), messages...),
+		types.OpenAIMessage{Role: "assistant", Content: answer},
+		types.OpenAIMessage{Role: "user", Content: fmt.Sprintf(syntaxFixPrompt, result.Summary())},
+	)
+	fixed, err := a.APIHandler.QueryOpenAIWithOptions(fixMessages, opts)
+	if err != nil {
+		log.Printf("Syntax fix query failed: %v", err)
+		return checked
+	}
+	fixedChecked, fixedResult := gocheck.Check(fixed)
+	if fixedResult.Blocks == 0 || len(fixedResult.Errors) >= len(result.Errors) {
+		log.Printf("Syntax fix did not help (%d error(s) remain), keeping the original answer", len(fixedResult.Errors))
+		return checked
+	}
+	return fixedChecked
+}
+
+// syntaxBadges returns the web page badges for the Go code of an answer, if it has any.
+func syntaxBadges(answer string) []Badge {
+	_, result := gocheck.Check(answer)
+	switch {
+	case result.Blocks == 0:
+		return nil
+	case result.Verified():
+		return []Badge{{Label: fmt.Sprintf("✓ Go syntax verified (%d block(s))", result.Blocks)}}
+	default:
+		return []Badge{{Label: fmt.Sprintf("⚠ Go syntax errors in %d of %d block(s)", len(result.Errors), result.Blocks), Warning: true}}
+	}
+}
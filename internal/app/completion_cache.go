@@ -0,0 +1,148 @@
+// internal/app/completion_cache.go
+
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"KernelSandersBot/internal/api"
+	"KernelSandersBot/internal/cache"
+	"KernelSandersBot/internal/types"
+)
+
+// Completion cache bounds.
+const (
+	completionCacheTTL     = time.Hour
+	completionCacheEntries = 500
+	completionCacheBytes   = 32 << 20
+	cacheEvictionInterval  = 5 * time.Minute
+	cacheStatsInterval     = time.Hour
+)
+
+// summaryCacheTTL keeps file summaries as long as uploads are stored, which is when re-uploads reuse them.
+const summaryCacheTTL = types.FileRetentionTime
+
+// cachedNote tells the user that a result came from the completion cache.
+const cachedNote = "♻️ <i>Cached result of an identical earlier request; it did not count against your limit. Use /nocache to always get fresh results.</i>\n\n"
+
+// completionPrefix returns the prefix of a user's completion cache keys. Completions are cached per
+// user, so /delete_my_data can remove them.
+func completionPrefix(userID int) string {
+	return fmt.Sprintf("completion:%d:", userID)
+}
+
+// summaryPrefix returns the prefix of a user's file summary cache keys.
+func summaryPrefix(userID int) string {
+	return fmt.Sprintf("summary:%d:", userID)
+}
+
+// completionKey hashes everything that determines a completion for a user: the model, its parameters and the messages.
+func completionKey(userID int, messages []types.OpenAIMessage, opts api.QueryOptions) string {
+	model := opts.Model
+	if model == "" {
+		model = api.DefaultModel
+	}
+	temperature := api.DefaultTemperature
+	if opts.Temperature != nil {
+		temperature = *opts.Temperature
+	}
+	maxTokens := opts.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = api.DefaultMaxTokens
+	}
+	data, _ := json.Marshal(struct {
+		Model       string                `json:"model"`
+		Temperature float64               `json:"temperature"`
+		MaxTokens   int                   `json:"max_tokens"`
+		JSON        bool                  `json:"json"`
+		Messages    []types.OpenAIMessage `json:"messages"`
+	}{model, temperature, maxTokens, opts.JSON, messages})
+	sum := sha256.Sum256(data)
+	return completionPrefix(userID) + hex.EncodeToString(sum[:])
+}
+
+// cachedCompletion returns a cached completion of the messages, unless the user turned caching off with /nocache.
+func (a *App) cachedCompletion(userID int, messages []types.OpenAIMessage, opts api.QueryOptions) (string, bool) {
+	if a.GetUserSettings(userID).NoCache {
+		return "", false
+	}
+	if reply, ok := a.Cache.Get(completionKey(userID, messages, opts)); ok {
+		return reply.(string), true
+	}
+	return "", false
+}
+
+// cacheCompletion stores a completion for the user's identical requests. Users with /nocache still refresh the cache.
+func (a *App) cacheCompletion(userID int, messages []types.OpenAIMessage, opts api.QueryOptions, reply string) {
+	a.Cache.Set(completionKey(userID, messages, opts), reply)
+}
+
+// logCacheStats logs the hit rate and size of the completion and file summary caches.
+func (a *App) logCacheStats() {
+	for _, c := range []struct {
+		name  string
+		cache *cache.Cache
+	}{{"Completion", a.Cache}, {"File summary", a.SummaryCache}} {
+		s := c.cache.Stats()
+		hitRate := 0.0
+		if lookups := s.Hits + s.Misses; lookups > 0 {
+			hitRate = float64(s.Hits) / float64(lookups) * 100
+		}
+		log.Printf("%s cache: %d hits, %d misses (%.1f%% hit rate), %d evictions, %d expirations, %d entries, %d bytes",
+			c.name, s.Hits, s.Misses, hitRate, s.Evictions, s.Expirations, s.Entries, s.Bytes)
+	}
+}
+
+// startCacheStatsLog logs the cache statistics every interval until the app shuts down.
+func (a *App) startCacheStatsLog(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.logCacheStats()
+			case <-a.ShutdownChan:
+				return
+			}
+		}
+	}()
+}
+
+// handleNoCacheCommand processes /nocache [on|off]: it turns off the completion cache for the user, so
+// /review, /tests and upload analysis always query the model, or turns it back on. Without an argument
+// it toggles the setting.
+func (a *App) handleNoCacheCommand(args string, message *types.TelegramMessage, userID int) (string, error) {
+	var set func(current bool) bool
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+		set = func(current bool) bool { return !current }
+	case "on":
+		set = func(bool) bool { return true }
+	case "off":
+		set = func(bool) bool { return false }
+	default:
+		return "", a.SendMessage(message.Chat.ID, "Usage: /nocache [on | off]", message.MessageID)
+	}
+
+	var reply string
+	var noCache bool
+	if err := a.UpdateUserSettings(userID, func(s *UserSettings) {
+		s.NoCache = set(s.NoCache)
+		noCache = s.NoCache
+	}); err != nil {
+		reply = "❌ <b>Error Saving Settings</b>\n\nPlease try again later."
+	} else if noCache {
+		reply = "♻️ <b>Caching Off</b>\n\n/review, /tests and upload analysis will always query the model and count against your limit. Use /nocache off to reuse identical results again."
+	} else {
+		reply = fmt.Sprintf("♻️ <b>Caching On</b>\n\nIdentical /review, /tests and upload analysis requests are answered from the cache for %d minutes, instantly and without counting against your limit.", int(completionCacheTTL.Minutes()))
+	}
+	err := a.SendMessage(message.Chat.ID, reply, message.MessageID)
+	return "", err
+}
@@ -0,0 +1,193 @@
+// internal/app/conversation_commands.go
+
+package app
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"KernelSandersBot/internal/api"
+	"KernelSandersBot/internal/types"
+)
+
+// historyPreviewLength is the number of characters of each message shown by /history.
+const historyPreviewLength = 160
+
+// historyMaxMessages is the number of most recent messages shown by /history.
+const historyMaxMessages = 20
+
+// maxTemperature is the highest sampling temperature accepted by the OpenAI API.
+const maxTemperature = 2.0
+
+// replyToBotMessageID returns the ID of the bot message the given message replies to, or 0.
+func (a *App) replyToBotMessageID(message *types.TelegramMessage) int {
+	if reply := message.ReplyToMessage; reply != nil && reply.From.IsBot && strings.EqualFold(reply.From.Username, a.BotUsername) {
+		return reply.MessageID
+	}
+	return 0
+}
+
+// handleConversationCommand processes /reset, /history, /undo and /retry on the conversation the
+// command belongs to: the chat's conversation, or the thread of the bot message it replies to. Only
+// /history works on another user's thread; the other commands change it and are limited to its owner.
+func (a *App) handleConversationCommand(command, args string, message *types.TelegramMessage, userID int, username string) (string, error) {
+	key, parent := a.conversationKey(message.Chat.ID, userID, a.replyToBotMessageID(message))
+	if parent != "" && command != "/history" {
+		reply := "🔒 <b>Not Your Conversation</b>\n\nOnly the user who started this conversation can change it. Reply without quoting it to use your own conversation."
+		return "", a.SendMessage(message.Chat.ID, reply, message.MessageID)
+	}
+	if parent != "" {
+		key = parent
+	}
+
+	var reply string
+	switch command {
+	case "/reset":
+		a.ConversationContexts.Delete(key)
+		reply = "🧹 <b>Conversation Reset</b>\n\nI've forgotten our conversation. Your next message starts a new one."
+	case "/history":
+		messages, exists := a.getConversation(key)
+		if !exists {
+			reply = "💬 <b>No Conversation</b>\n\nThere is no active conversation in this chat."
+			break
+		}
+		reply = formatConversationHistory(messages)
+	case "/undo":
+		messages, exists := a.getConversation(key)
+		if !exists {
+			reply = "💬 <b>No Conversation</b>\n\nThere is nothing to undo."
+			break
+		}
+		trimmed, ok := dropLastExchange(messages)
+		if !ok {
+			reply = "💬 <b>Nothing to Undo</b>\n\nThe conversation has no exchanges yet."
+			break
+		}
+		a.setConversation(key, trimmed)
+		reply = "↩️ <b>Last Exchange Removed</b>\n\nYour last question and my answer have been removed from the conversation."
+	case "/retry":
+		return "", a.retryLastAnswer(key, args, message, userID, username)
+	}
+
+	err := a.SendMessage(message.Chat.ID, reply, message.MessageID)
+	return "", err
+}
+
+// retryLastAnswer regenerates the last answer of a conversation, optionally with a different
+// temperature and model given in args (in any order, e.g. "/retry 1.2 gpt-4o").
+func (a *App) retryLastAnswer(key, args string, message *types.TelegramMessage, userID int, username string) error {
+	chatID := message.Chat.ID
+	opts, model, err := a.parseRetryOptions(userID, args)
+	if err != nil {
+		errMsg := fmt.Sprintf("❌ <b>Invalid Retry Options</b>\n\n%s\n\nUsage: /retry [temperature 0-2] [model]\nAllowed models: %s",
+			EscapeHTML(err.Error()), EscapeHTML(strings.Join(a.allowedModelNames(), ", ")))
+		return a.SendMessage(chatID, errMsg, message.MessageID)
+	}
+
+	messages, exists := a.getConversation(key)
+	if !exists || len(messages) < 2 || messages[len(messages)-1].Role != "assistant" {
+		return a.SendMessage(chatID, "💬 <b>Nothing to Retry</b>\n\nThere is no answer in this conversation to regenerate.", message.MessageID)
+	}
+
+	if err := a.enforceRateLimit(chatID, userID, username, "/retry "+args, message.MessageID, model); err != nil {
+		return err
+	}
+	opts = a.meterRequests(userID, model, opts)
+
+	// Drop the previous answer and ask again with the current system prompt
+	messages = withSystemPrompt(messages[:len(messages)-1], a.systemPromptFor(chatID, key))
+	startTime := time.Now()
+	responseText, err := a.queryModel(userID, messages, opts, shouldBrowse(chatID, userID, lastUserMessage(messages)))
+	if err != nil {
+		log.Printf("OpenAI retry failed: %v", err)
+		a.SendMessage(chatID, "❌ <b>Retry Failed</b>\n\nThe answer could not be regenerated. Please try again later.", message.MessageID)
+		return err
+	}
+
+	messages = append(messages, types.OpenAIMessage{Role: "assistant", Content: responseText})
+	a.setConversation(key, messages)
+
+	if err := a.deliverAnswer(chatID, userID, key, responseText, message.MessageID); err != nil {
+		return err
+	}
+	a.logToS3(userID, username, "/retry "+args, fmt.Sprintf("%d ms", time.Since(startTime).Milliseconds()), a.isNoLimitUser(userID))
+	return nil
+}
+
+// parseRetryOptions parses an optional temperature and model from /retry arguments, overriding the
+// user's own settings for this query only. It returns the options and the model they use.
+func (a *App) parseRetryOptions(userID int, args string) (api.QueryOptions, AllowedModel, error) {
+	opts, model := a.queryOptionsFor(userID)
+	for _, field := range strings.Fields(args) {
+		if temperature, err := strconv.ParseFloat(field, 64); err == nil {
+			if !isFinite(temperature) || temperature < 0 || temperature > maxTemperature {
+				return opts, model, fmt.Errorf("temperature must be between 0 and %.0f", maxTemperature)
+			}
+			opts.Temperature = &temperature
+			continue
+		}
+		allowed, ok := a.findModel(field)
+		if !ok {
+			return opts, model, fmt.Errorf("model %q is not allowed", field)
+		}
+		model = allowed
+		opts.Model = allowed.Name
+	}
+	return opts, model, nil
+}
+
+// isFinite reports whether f is neither NaN nor infinite. ParseFloat accepts "NaN" and "Inf", and
+// NaN passes every range check.
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// dropLastExchange removes the last user message and everything after it.
+func dropLastExchange(messages []types.OpenAIMessage) ([]types.OpenAIMessage, bool) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[:i], true
+		}
+	}
+	return messages, false
+}
+
+// formatConversationHistory renders a condensed view of the most recent messages of a conversation.
+func formatConversationHistory(messages []types.OpenAIMessage) string {
+	var shown []types.OpenAIMessage
+	for _, msg := range messages {
+		if msg.Role != "system" {
+			shown = append(shown, msg)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("💬 <b>Conversation History</b> (%d messages)\n\n", len(shown)))
+	if len(shown) > historyMaxMessages {
+		sb.WriteString(fmt.Sprintf("<i>… %d earlier messages omitted</i>\n\n", len(shown)-historyMaxMessages))
+		shown = shown[len(shown)-historyMaxMessages:]
+	}
+	for _, msg := range shown {
+		label := "🧑 <b>You:</b>"
+		if msg.Role == "assistant" {
+			label = "🤖 <b>Bot:</b>"
+		}
+		sb.WriteString(fmt.Sprintf("%s %s\n\n", label, EscapeHTML(previewText(msg.Content, historyPreviewLength))))
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// previewText collapses whitespace and shortens text to at most limit characters.
+func previewText(text string, limit int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:limit]) + "…"
+}
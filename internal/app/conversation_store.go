@@ -0,0 +1,261 @@
+// internal/app/conversation_store.go
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"KernelSandersBot/internal/conversation"
+	"KernelSandersBot/internal/encryption"
+	"KernelSandersBot/internal/s3client"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// conversationPrefix is the S3 prefix under which conversation contexts are persisted.
+const conversationPrefix = "conversations/"
+
+// conversationLinkPrefix is the S3 prefix under which reply links are persisted, as empty objects named
+// conversation_links/<owner>/<conversation key>/<chatID>_<messageID>.
+const conversationLinkPrefix = "conversation_links/"
+
+// conversationOwnerPattern extracts the owning user ID from a conversation key, which always ends in "user_<id>".
+var conversationOwnerPattern = regexp.MustCompile(`(?:^|_)user_(\d+)$`)
+
+// conversationRecord is the S3 representation of a conversation context.
+type conversationRecord struct {
+	Key      string    `json:"key"`
+	LastSeen time.Time `json:"last_seen"`
+	Data     []byte    `json:"data"` // Encrypted with the owner's data key
+}
+
+// conversationStore persists conversation contexts in S3, encrypted with the owner's key.
+// It implements conversation.Persister.
+type conversationStore struct {
+	s3Client s3client.S3ClientInterface
+	bucket   string
+	keys     *encryption.KeyManager
+}
+
+// conversationOwner returns the user that owns a conversation key.
+func conversationOwner(key string) (int, error) {
+	match := conversationOwnerPattern.FindStringSubmatch(key)
+	if match == nil {
+		return 0, fmt.Errorf("conversation key %q has no owner", key)
+	}
+	return strconv.Atoi(match[1])
+}
+
+// objectKey returns the S3 object key of a conversation, grouped by owner so deletion can sweep a prefix.
+func (cs *conversationStore) objectKey(key string) (string, int, error) {
+	owner, err := conversationOwner(key)
+	if err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("%s%d/%s.json", conversationPrefix, owner, key), owner, nil
+}
+
+// Save writes a conversation context to S3.
+func (cs *conversationStore) Save(key, value string, lastSeen time.Time) error {
+	objectKey, owner, err := cs.objectKey(key)
+	if err != nil {
+		return err
+	}
+	data, err := cs.keys.Encrypt(owner, []byte(value))
+	if err != nil {
+		return err
+	}
+	recordJSON, err := json.Marshal(conversationRecord{Key: key, LastSeen: lastSeen, Data: data})
+	if err != nil {
+		return err
+	}
+	_, err = cs.s3Client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(cs.bucket),
+		Key:    aws.String(objectKey),
+		Body:   bytes.NewReader(recordJSON),
+	})
+	return err
+}
+
+// Load reads a conversation context from S3.
+func (cs *conversationStore) Load(key string) (string, time.Time, bool, error) {
+	objectKey, owner, err := cs.objectKey(key)
+	if err != nil {
+		return "", time.Time{}, false, err
+	}
+	resp, err := cs.s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(cs.bucket),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return "", time.Time{}, false, nil
+		}
+		return "", time.Time{}, false, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, false, err
+	}
+	var record conversationRecord
+	if err := json.Unmarshal(bodyBytes, &record); err != nil {
+		return "", time.Time{}, false, err
+	}
+	value, err := cs.keys.Decrypt(owner, record.Data)
+	if err != nil {
+		return "", time.Time{}, false, err
+	}
+	return string(value), record.LastSeen, true, nil
+}
+
+// Delete removes a persisted conversation context.
+func (cs *conversationStore) Delete(key string) error {
+	objectKey, _, err := cs.objectKey(key)
+	if err != nil {
+		return err
+	}
+	_, err = cs.s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(cs.bucket),
+		Key:    aws.String(objectKey),
+	})
+	return err
+}
+
+// DeleteExpired removes persisted conversations last written before cutoff. Every Save rewrites
+// the object, so its modification time is the conversation's last activity.
+func (cs *conversationStore) DeleteExpired(cutoff time.Time) (int, error) {
+	var expired []string
+	err := cs.s3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(cs.bucket),
+		Prefix: aws.String(conversationPrefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			if aws.TimeValue(obj.LastModified).Before(cutoff) {
+				expired = append(expired, aws.StringValue(obj.Key))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, objectKey := range expired {
+		_, err := cs.s3Client.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(cs.bucket),
+			Key:    aws.String(objectKey),
+		})
+		if err != nil {
+			log.Printf("Failed to delete expired conversation %s: %v", objectKey, err)
+			continue
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d expired conversations from S3", deleted)
+	}
+	return deleted, nil
+}
+
+// linkPrefix returns the S3 prefix of the reply links of a conversation.
+func linkPrefix(key string) (string, error) {
+	owner, err := conversationOwner(key)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%d/%s/", conversationLinkPrefix, owner, key), nil
+}
+
+// SaveLink persists a reply link. The link is encoded in the object key, so the object is empty.
+func (cs *conversationStore) SaveLink(link conversation.Link) error {
+	prefix, err := linkPrefix(link.Key)
+	if err != nil {
+		return err
+	}
+	_, err = cs.s3Client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(cs.bucket),
+		Key:    aws.String(fmt.Sprintf("%s%d_%d", prefix, link.ChatID, link.MessageID)),
+		Body:   bytes.NewReader(nil),
+	})
+	return err
+}
+
+// LoadLinks lists every persisted reply link.
+func (cs *conversationStore) LoadLinks() ([]conversation.Link, error) {
+	var links []conversation.Link
+	err := cs.s3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(cs.bucket),
+		Prefix: aws.String(conversationLinkPrefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			if link, ok := parseLinkObjectKey(aws.StringValue(obj.Key)); ok {
+				links = append(links, link)
+			}
+		}
+		return true
+	})
+	return links, err
+}
+
+// DeleteLinks removes the persisted reply links of a conversation.
+func (cs *conversationStore) DeleteLinks(key string) error {
+	prefix, err := linkPrefix(key)
+	if err != nil {
+		return err
+	}
+	var objectKeys []string
+	err = cs.s3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(cs.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			objectKeys = append(objectKeys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, objectKey := range objectKeys {
+		if _, err := cs.s3Client.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(cs.bucket),
+			Key:    aws.String(objectKey),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseLinkObjectKey parses a reply link from its object key.
+func parseLinkObjectKey(objectKey string) (conversation.Link, bool) {
+	parts := strings.Split(strings.TrimPrefix(objectKey, conversationLinkPrefix), "/")
+	if len(parts) != 3 {
+		return conversation.Link{}, false
+	}
+	sep := strings.LastIndexByte(parts[2], '_')
+	if sep < 0 {
+		return conversation.Link{}, false
+	}
+	chatID, err := strconv.ParseInt(parts[2][:sep], 10, 64)
+	if err != nil {
+		return conversation.Link{}, false
+	}
+	messageID, err := strconv.Atoi(parts[2][sep+1:])
+	if err != nil {
+		return conversation.Link{}, false
+	}
+	return conversation.Link{ChatID: chatID, MessageID: messageID, Key: parts[1]}, true
+}
@@ -0,0 +1,256 @@
+// internal/app/data_export.go
+
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"KernelSandersBot/internal/types"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// exportResponse describes a web response in the export index.
+type exportResponse struct {
+	ID        string    `json:"id"`
+	File      string    `json:"file"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// exportUsage describes the user's rate-limit usage in the export.
+type exportUsage struct {
+	MessagesInWindow []time.Time `json:"messages_in_window"`
+	Limit            int         `json:"limit"`
+	WindowSeconds    int         `json:"window_seconds"`
+}
+
+// ExportUserData bundles everything the bot stores about a user into a zip archive: settings,
+// source code of every project, active web responses, conversations, saved conversations, usage statistics
+// and the user's rows of the interaction log.
+func (a *App) ExportUserData(userID int) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	var manifest []string
+
+	addFile := func(name string, data []byte) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		manifest = append(manifest, name)
+		return err
+	}
+	addJSON := func(name string, value interface{}) error {
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+		return addFile(name, data)
+	}
+
+	if err := addJSON("settings.json", a.GetUserSettings(userID)); err != nil {
+		return nil, err
+	}
+
+	// Source code of every project
+	files, err := a.ListUserFiles(userID)
+	if err != nil {
+		return nil, fmt.Errorf("listing source code: %w", err)
+	}
+	for _, file := range files {
+		code, ok := a.getProjectSource(userID, file.Project)
+		if !ok {
+			continue
+		}
+		if err := addFile(fmt.Sprintf("source_code/%s/source_code.txt", file.Project), []byte(code)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Web responses
+	responses, err := a.ResponseStore.GetUserResponsesByUserID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("listing responses: %w", err)
+	}
+	var index []exportResponse
+	for _, resp := range responses {
+		content, ok := a.ResponseStore.GetResponse(resp.ID)
+		if !ok {
+			continue
+		}
+		name := fmt.Sprintf("responses/%s.md", resp.ID)
+		if err := addFile(name, []byte(content)); err != nil {
+			return nil, err
+		}
+		index = append(index, exportResponse{ID: resp.ID, File: name, CreatedAt: resp.CreatedAtUTC, ExpiresAt: resp.DeletionTimeUTC})
+	}
+	if err := addJSON("responses/index.json", index); err != nil {
+		return nil, err
+	}
+
+	// Conversations, one file per chat or thread
+	conversationKeys, err := a.userConversationKeys(userID)
+	if err != nil {
+		return nil, fmt.Errorf("listing conversations: %w", err)
+	}
+	for _, key := range conversationKeys {
+		history, ok := a.ConversationContexts.Get(key)
+		if !ok {
+			continue
+		}
+		var messages []types.OpenAIMessage
+		if err := json.Unmarshal([]byte(history), &messages); err != nil {
+			log.Printf("Failed to unmarshal conversation %s for export: %v", key, err)
+			continue
+		}
+		if err := addJSON(fmt.Sprintf("conversations/%s.json", key), messages); err != nil {
+			return nil, err
+		}
+	}
+
+	// Saved conversations
+	saved, err := a.ListSavedConversations(userID)
+	if err != nil {
+		return nil, fmt.Errorf("listing saved conversations: %w", err)
+	}
+	for _, s := range saved {
+		messages, err := a.LoadSavedConversation(userID, s.Name)
+		if err != nil {
+			log.Printf("Failed to load saved conversation %s for export: %v", s.Name, err)
+			continue
+		}
+		if err := addJSON(fmt.Sprintf("saved_conversations/%s.json", s.Name), messages); err != nil {
+			return nil, err
+		}
+	}
+
+	// Usage statistics
+	limit, window := a.UsageCache.Limits()
+	usage := exportUsage{MessagesInWindow: a.UsageCache.History(userID), Limit: limit, WindowSeconds: int(window.Seconds())}
+	if err := addJSON("usage.json", usage); err != nil {
+		return nil, err
+	}
+
+	// Interaction log rows
+	logRows, err := a.userLogRows(userID)
+	if err != nil {
+		return nil, fmt.Errorf("reading logs: %w", err)
+	}
+	var logBuf bytes.Buffer
+	if err := csv.NewWriter(&logBuf).WriteAll(logRows); err != nil {
+		return nil, err
+	}
+	if err := addFile("logs.csv", logBuf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	readme := fmt.Sprintf("KernelSanders data export for user %d\nGenerated: %s\n\nFiles:\n- %s\n",
+		userID, time.Now().UTC().Format(time.RFC3339), strings.Join(manifest, "\n- "))
+	if err := addFile("README.txt", []byte(readme)); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// userConversationKeys returns the keys of the user's conversations, both in memory and persisted.
+func (a *App) userConversationKeys(userID int) ([]string, error) {
+	seen := make(map[string]struct{})
+	var keys []string
+	add := func(key string) {
+		if _, dup := seen[key]; !dup {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range a.ConversationContexts.KeysMatching(ownsConversation(userID)) {
+		add(key)
+	}
+
+	prefix := fmt.Sprintf("%s%d/", conversationPrefix, userID)
+	err := a.S3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(a.S3BucketName),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			add(strings.TrimSuffix(strings.TrimPrefix(aws.StringValue(obj.Key), prefix), ".json"))
+		}
+		return true
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+// userLogRows returns the interaction log header followed by the user's rows.
+func (a *App) userLogRows(userID int) ([][]string, error) {
+	a.logMutex.Lock()
+	defer a.logMutex.Unlock()
+
+	records, err := a.readLogRecords()
+	if err != nil {
+		return nil, err
+	}
+	id := strconv.Itoa(userID)
+	var rows [][]string
+	for i, record := range records {
+		if i == 0 && len(record) > 0 && record[0] == "userID" {
+			rows = append(rows, record)
+			continue
+		}
+		if len(record) > 0 && record[0] == id {
+			for _, column := range []int{logPromptColumn, logKeywordsColumn} {
+				if column < len(record) {
+					record[column] = a.openLogCell(userID, record[column])
+				}
+			}
+			rows = append(rows, record)
+		}
+	}
+	return rows, nil
+}
+
+// handleExportCommand processes /export_my_data. In group chats the archive is sent to the user privately.
+func (a *App) handleExportCommand(message *types.TelegramMessage, userID int) (string, error) {
+	archive, err := a.ExportUserData(userID)
+	if err != nil {
+		log.Printf("Failed to export data for user %d: %v", userID, err)
+		errorMsg := "❌ <b>Error Exporting Data</b>\n\nUnable to export your data at this time. Please try again later."
+		a.SendMessage(message.Chat.ID, errorMsg, message.MessageID)
+		return "", err
+	}
+
+	fileName := fmt.Sprintf("kernelsanders_export_%d_%s.zip", userID, time.Now().UTC().Format("20060102"))
+	caption := "📦 <b>Your Data Export</b>\n\nEverything KernelSanders currently stores about you. See README.txt inside for the contents."
+
+	if message.Chat.Type == "private" {
+		err := a.SendDocument(message.Chat.ID, fileName, archive, caption, message.MessageID)
+		if err != nil {
+			log.Printf("Failed to send data export to user %d: %v", userID, err)
+		}
+		return "", err
+	}
+
+	// Exports contain private data, so they are never posted to a group
+	notice := "📦 <b>Data Export Sent</b>\n\nI've sent your export to you in a private chat."
+	if err := a.SendDocument(int64(userID), fileName, archive, caption, 0); err != nil {
+		log.Printf("Failed to send data export privately to user %d: %v", userID, err)
+		notice = "❌ <b>Data Export Not Sent</b>\n\nFor privacy, exports are only sent in a private chat. Please start a chat with @" + a.BotUsername + " and run /export_my_data there."
+	}
+	err = a.SendMessage(message.Chat.ID, notice, message.MessageID)
+	return "", err
+}
@@ -0,0 +1,129 @@
+// internal/app/go_symbols.go
+
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"KernelSandersBot/internal/goindex"
+	"KernelSandersBot/internal/types"
+)
+
+// Limits keep /symbols and /whereis replies within a single Telegram message.
+const (
+	maxListedSymbols  = 60
+	maxWhereisMatches = 10
+)
+
+// symbolKindOrder is the order in which /symbols counts and lists symbol kinds.
+var symbolKindOrder = []string{
+	goindex.KindInterface, goindex.KindStruct, goindex.KindType, goindex.KindFunc,
+	goindex.KindMethod, goindex.KindConst, goindex.KindVar,
+}
+
+// handleSymbolCommand processes /symbols [package] and /whereis <name> over the Go files of the user's active project.
+func (a *App) handleSymbolCommand(command, args string, message *types.TelegramMessage, userID int) (string, error) {
+	tree, project, ok := a.activeSourceTree(userID)
+	var reply string
+	switch {
+	case !ok:
+		reply = "❗ <b>No Source Code Found</b>\n\nUpload your Go project first; /symbols and /whereis work on your active project."
+	case command == "/whereis" && strings.TrimSpace(args) == "":
+		reply = "Usage: /whereis &lt;name&gt;, for example /whereis HandleCommand or /whereis App.HandleCommand"
+	default:
+		index := goindex.Build(tree)
+		if len(index.Packages) == 0 {
+			reply = fmt.Sprintf("❗ <b>No Go Files</b>\n\nProject <b>%s</b> has no .go files to index.", EscapeHTML(project))
+			break
+		}
+		if command == "/whereis" {
+			reply = formatWhereis(index, strings.TrimSpace(args))
+		} else {
+			reply = formatSymbols(index, project, strings.TrimSpace(args))
+		}
+	}
+
+	err := a.SendMessage(message.Chat.ID, reply, message.MessageID)
+	return "", err
+}
+
+// formatSymbols lists the packages of an index with their symbol counts, or the symbols of one package.
+func formatSymbols(index *goindex.Index, project, pkg string) string {
+	var sb strings.Builder
+	if pkg == "" {
+		sb.WriteString(fmt.Sprintf("🧭 <b>Go Symbols in %s</b>\n\n", EscapeHTML(project)))
+		for _, p := range index.Packages {
+			var counts []string
+			for _, kind := range symbolKindOrder {
+				if n := p.Kinds[kind]; n > 0 {
+					counts = append(counts, fmt.Sprintf("%d %s", n, kind))
+				}
+			}
+			sb.WriteString(fmt.Sprintf("• <b>%s</b> <code>%s</code> — %d files", EscapeHTML(p.Name), EscapeHTML(p.Dir), len(p.Files)))
+			if len(counts) > 0 {
+				sb.WriteString(": " + strings.Join(counts, ", "))
+			}
+			sb.WriteString("\n")
+		}
+		if len(index.Errors) > 0 {
+			sb.WriteString(fmt.Sprintf("\n⚠️ %d file(s) had syntax errors and were indexed partially or skipped.\n", len(index.Errors)))
+		}
+		sb.WriteString("\nUse /symbols &lt;package&gt; to list a package's symbols or /whereis &lt;name&gt; to find a definition.")
+		return sb.String()
+	}
+
+	symbols := index.SymbolsIn(pkg)
+	if len(symbols) == 0 {
+		return fmt.Sprintf("❗ <b>Unknown Package</b>\n\nNo package named or located at <code>%s</code>. Use /symbols to list the packages.", EscapeHTML(pkg))
+	}
+	sb.WriteString(fmt.Sprintf("🧭 <b>Symbols in %s</b> (%d)\n\n", EscapeHTML(pkg), len(symbols)))
+	listed := 0
+	for _, kind := range symbolKindOrder {
+		for _, sym := range symbols {
+			if sym.Kind != kind {
+				continue
+			}
+			listed++
+			if listed <= maxListedSymbols {
+				sb.WriteString(fmt.Sprintf("• %s <code>%s</code> — %s:%d\n", kind, EscapeHTML(sym.QualifiedName()), EscapeHTML(sym.File), sym.Line))
+			}
+		}
+	}
+	if listed > maxListedSymbols {
+		sb.WriteString(fmt.Sprintf("\n… %d more. Use /whereis &lt;name&gt; for details.", listed-maxListedSymbols))
+	}
+	return sb.String()
+}
+
+// formatWhereis shows where a symbol is defined, with its signature and interface relationships.
+func formatWhereis(index *goindex.Index, name string) string {
+	symbols := index.Lookup(name)
+	if len(symbols) == 0 {
+		return fmt.Sprintf("❗ <b>Not Found</b>\n\nNo Go declaration named <code>%s</code> in your active project.", EscapeHTML(name))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📍 <b>%s</b>\n\n", EscapeHTML(name)))
+	for i, sym := range symbols {
+		if i == maxWhereisMatches {
+			sb.WriteString(fmt.Sprintf("… %d more definitions\n", len(symbols)-maxWhereisMatches))
+			break
+		}
+		sb.WriteString(fmt.Sprintf("• %s in package <b>%s</b> — <code>%s:%d</code>\n<pre>%s</pre>\n",
+			sym.Kind, EscapeHTML(sym.Package), EscapeHTML(sym.File), sym.Line, EscapeHTML(sym.Signature)))
+		switch sym.Kind {
+		case goindex.KindInterface:
+			if impl := index.Implementers(sym.Name); len(impl) > 0 {
+				sb.WriteString(fmt.Sprintf("Implemented by: %s\n", EscapeHTML(strings.Join(impl, ", "))))
+			}
+		case goindex.KindStruct, goindex.KindType:
+			if ifaces := index.Implements(sym.Name); len(ifaces) > 0 {
+				sb.WriteString(fmt.Sprintf("Implements: %s\n", EscapeHTML(strings.Join(ifaces, ", "))))
+			}
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("<i>Implementations are matched by method names.</i>")
+	return sb.String()
+}
@@ -0,0 +1,325 @@
+// internal/app/legacy_encryption.go
+
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"KernelSandersBot/internal/conversation"
+	"KernelSandersBot/internal/encryption"
+	"KernelSandersBot/internal/types"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// sealedCellPrefix marks a log cell encrypted with its row owner's data key.
+const sealedCellPrefix = "kse1:"
+
+// Columns of the interaction log holding user content, which are sealed with the user's key.
+const (
+	logPromptColumn   = 2
+	logKeywordsColumn = 3
+)
+
+// sealLogCell encrypts a log cell with the user's data key so crypto-shredding covers the log.
+// If encryption is disabled the text is returned unchanged; if it fails the cell is left empty.
+func (a *App) sealLogCell(userID int, text string) string {
+	if !a.Keys.Enabled() || text == "" {
+		return text
+	}
+	sealed, err := a.Keys.Encrypt(userID, []byte(text))
+	if err != nil {
+		log.Printf("Failed to encrypt log entry for user %d: %v", userID, err)
+		return ""
+	}
+	return sealedCellPrefix + base64.StdEncoding.EncodeToString(sealed)
+}
+
+// openLogCell decrypts a cell written by sealLogCell. Cells written before the log was encrypted are returned as is.
+func (a *App) openLogCell(userID int, cell string) string {
+	if !strings.HasPrefix(cell, sealedCellPrefix) {
+		return cell
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(cell, sealedCellPrefix))
+	if err != nil {
+		return "[unreadable]"
+	}
+	plaintext, err := a.Keys.Decrypt(userID, sealed)
+	if err != nil {
+		return "[unreadable]"
+	}
+	return string(plaintext)
+}
+
+// legacyMigrationMarker is written once MigrateLegacyPlaintext has encrypted all legacy data, so later starts skip it.
+const legacyMigrationMarker = "migrations/legacy_plaintext_v1"
+
+// MigrateLegacyPlaintext encrypts user data written before encryption was enabled, so destroying a
+// user's key covers it too: source code, conversation contexts, saved conversations and the user
+// columns of the interaction log. Web responses are sealed by LoadResponsesFromS3. It runs before
+// the bot serves requests, so it does not race with uploads. Once every step succeeds a marker object
+// is written and later starts skip the scan.
+func (a *App) MigrateLegacyPlaintext() {
+	if !a.Keys.Enabled() {
+		return
+	}
+	if _, _, err := a.getObject(legacyMigrationMarker); err == nil {
+		return
+	} else if !isNotFound(err) {
+		log.Printf("Failed to check the legacy encryption marker, migrating again: %v", err)
+	}
+
+	migrated, failed := 0, false
+	for _, migrate := range []func() (int, error){
+		a.sealLegacySourceCode,
+		a.sealLegacyConversations,
+		a.sealLegacySavedConversations,
+		a.sealLegacyLogRows,
+	} {
+		n, err := migrate()
+		if err != nil {
+			log.Printf("Failed to encrypt legacy data: %v", err)
+			failed = true
+		}
+		migrated += n
+	}
+	if migrated > 0 {
+		log.Printf("Encrypted %d legacy plaintext objects and log rows", migrated)
+	}
+	if failed {
+		return
+	}
+	completed := []byte(time.Now().UTC().Format(time.RFC3339))
+	if err := a.putObject(legacyMigrationMarker, completed, nil); err != nil {
+		log.Printf("Failed to write the legacy encryption marker: %v", err)
+	}
+}
+
+// listObjectKeys returns the keys of every object whose key starts with prefix.
+func (a *App) listObjectKeys(prefix string) ([]string, error) {
+	var keys []string
+	err := a.S3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(a.S3BucketName),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	return keys, err
+}
+
+// getObject reads an object's body and metadata.
+func (a *App) getObject(objectKey string) ([]byte, map[string]*string, error) {
+	resp, err := a.S3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(a.S3BucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return body, resp.Metadata, err
+}
+
+// putObject writes an object with the given metadata.
+func (a *App) putObject(objectKey string, body []byte, metadata map[string]*string) error {
+	_, err := a.S3Client.PutObject(&s3.PutObjectInput{
+		Bucket:   aws.String(a.S3BucketName),
+		Key:      aws.String(objectKey),
+		Body:     bytes.NewReader(body),
+		Metadata: metadata,
+	})
+	return err
+}
+
+// ownerFromKey parses the user ID that follows prefix in an object key such as "prefix/<userID>/...".
+func ownerFromKey(objectKey, prefix string) (int, bool) {
+	rest := strings.TrimPrefix(objectKey, prefix)
+	if i := strings.IndexByte(rest, '/'); i > 0 {
+		rest = rest[:i]
+	}
+	userID, err := strconv.Atoi(rest)
+	return userID, err == nil
+}
+
+// sealLegacySourceCode encrypts plaintext source code objects in place, keeping their upload time.
+func (a *App) sealLegacySourceCode() (int, error) {
+	const prefix = "user_source_code/"
+	keys, err := a.listObjectKeys(prefix)
+	if err != nil {
+		return 0, err
+	}
+	sealed := 0
+	for _, objectKey := range keys {
+		userID, ok := ownerFromKey(objectKey, prefix)
+		if !ok {
+			continue
+		}
+		body, metadata, err := a.getObject(objectKey)
+		if err != nil || encryption.IsEncrypted(body) {
+			continue
+		}
+		if sourceRetentionExpired(metadata) {
+			a.deleteObject(objectKey)
+			continue
+		}
+		ciphertext, err := a.Keys.Encrypt(userID, body)
+		if err != nil {
+			log.Printf("Failed to encrypt legacy source code %s: %v", objectKey, err)
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]*string)
+		}
+		if _, ok := metadataValue(metadata, "source_bytes"); !ok {
+			metadata["source_bytes"] = aws.String(strconv.Itoa(len(body)))
+		}
+		if err := a.putObject(objectKey, ciphertext, metadata); err != nil {
+			log.Printf("Failed to store encrypted source code %s: %v", objectKey, err)
+			continue
+		}
+		sealed++
+	}
+	return sealed, nil
+}
+
+// sealLegacyConversations encrypts the data of plaintext conversation records. Rewriting a record
+// refreshes its modification time, which marks conversation activity, so expired records are deleted instead.
+func (a *App) sealLegacyConversations() (int, error) {
+	keys, err := a.listObjectKeys(conversationPrefix)
+	if err != nil {
+		return 0, err
+	}
+	sealed := 0
+	for _, objectKey := range keys {
+		body, _, err := a.getObject(objectKey)
+		if err != nil {
+			continue
+		}
+		var record conversationRecord
+		if err := json.Unmarshal(body, &record); err != nil || encryption.IsEncrypted(record.Data) {
+			continue
+		}
+		if time.Since(record.LastSeen) > conversation.Expiry {
+			a.deleteObject(objectKey)
+			continue
+		}
+		userID, err := conversationOwner(record.Key)
+		if err != nil {
+			continue
+		}
+		if record.Data, err = a.Keys.Encrypt(userID, record.Data); err != nil {
+			log.Printf("Failed to encrypt legacy conversation %s: %v", objectKey, err)
+			continue
+		}
+		recordJSON, err := json.Marshal(record)
+		if err != nil {
+			continue
+		}
+		if err := a.putObject(objectKey, recordJSON, nil); err != nil {
+			log.Printf("Failed to store encrypted conversation %s: %v", objectKey, err)
+			continue
+		}
+		sealed++
+	}
+	return sealed, nil
+}
+
+// sealLegacySavedConversations encrypts the messages of plaintext saved conversations.
+func (a *App) sealLegacySavedConversations() (int, error) {
+	keys, err := a.listObjectKeys(savedConversationPrefix)
+	if err != nil {
+		return 0, err
+	}
+	sealed := 0
+	for _, objectKey := range keys {
+		userID, ok := ownerFromKey(objectKey, savedConversationPrefix)
+		if !ok {
+			continue
+		}
+		record, found, err := a.readSavedConversation(objectKey)
+		if err != nil || !found || encryption.IsEncrypted(record.Data) {
+			continue
+		}
+		if record.Data, err = a.Keys.Encrypt(userID, record.Data); err != nil {
+			log.Printf("Failed to encrypt legacy saved conversation %s: %v", objectKey, err)
+			continue
+		}
+		recordJSON, err := json.Marshal(record)
+		if err != nil {
+			continue
+		}
+		if err := a.putObject(objectKey, recordJSON, nil); err != nil {
+			log.Printf("Failed to store encrypted saved conversation %s: %v", objectKey, err)
+			continue
+		}
+		sealed++
+	}
+	return sealed, nil
+}
+
+// sealLegacyLogRows seals the user columns of log rows written before the log was encrypted.
+func (a *App) sealLegacyLogRows() (int, error) {
+	a.logMutex.Lock()
+	defer a.logMutex.Unlock()
+
+	records, err := a.readLogRecords()
+	if err != nil {
+		return 0, err
+	}
+	sealed := 0
+	for _, record := range records {
+		if len(record) == 0 {
+			continue
+		}
+		userID, err := strconv.Atoi(record[0])
+		if err != nil {
+			continue // Header row
+		}
+		changed := false
+		for _, column := range []int{logPromptColumn, logKeywordsColumn} {
+			if column < len(record) && record[column] != "" && !strings.HasPrefix(record[column], sealedCellPrefix) {
+				record[column] = a.sealLogCell(userID, record[column])
+				changed = true
+			}
+		}
+		if changed {
+			sealed++
+		}
+	}
+	if sealed == 0 {
+		return 0, nil
+	}
+	return sealed, a.writeLogRecords(records)
+}
+
+// writeLogRecords replaces the interaction log with records. The caller must hold logMutex.
+func (a *App) writeLogRecords(records [][]string) error {
+	var buf bytes.Buffer
+	if err := csv.NewWriter(&buf).WriteAll(records); err != nil {
+		return err
+	}
+	return a.putObject(logObjectKey, buf.Bytes(), nil)
+}
+
+// sourceRetentionExpired reports whether an object's uploaded_at metadata is past the retention time.
+func sourceRetentionExpired(metadata map[string]*string) bool {
+	uploadedAtStr, ok := metadataValue(metadata, "uploaded_at")
+	if !ok {
+		return false
+	}
+	uploadedAt, err := time.Parse(time.RFC3339, uploadedAtStr)
+	return err == nil && time.Since(uploadedAt) > types.FileRetentionTime
+}
@@ -0,0 +1,272 @@
+// internal/app/model_settings.go
+
+package app
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"KernelSandersBot/internal/api"
+	"KernelSandersBot/internal/types"
+)
+
+// defaultMaxCompletionTokens is the highest max_tokens a user may choose when MAX_COMPLETION_TOKENS is not set.
+const defaultMaxCompletionTokens = 4096
+
+// minCompletionTokens is the lowest max_tokens a user may choose.
+const minCompletionTokens = 64
+
+// AllowedModel is a model users may select and its cost tier: the number of rate-limit units one query uses.
+type AllowedModel struct {
+	Name string
+	Cost int
+}
+
+// parseAllowedModels parses the comma-separated ALLOWED_MODELS variable, where each entry is a model
+// optionally followed by its cost, e.g. "gpt-4o-mini:1,gpt-4o:3". Entries without a cost cost one unit.
+// The first model is the default. An empty variable yields the built-in models.
+func parseAllowedModels(raw string) []AllowedModel {
+	var models []AllowedModel
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		model := AllowedModel{Name: entry, Cost: 1}
+		if i := strings.LastIndex(entry, ":"); i >= 0 {
+			model.Name = strings.TrimSpace(entry[:i])
+			cost, err := strconv.Atoi(strings.TrimSpace(entry[i+1:]))
+			if err != nil || cost < 1 {
+				log.Printf("Invalid cost in ALLOWED_MODELS entry %q, using 1", entry)
+				cost = 1
+			}
+			model.Cost = cost
+		}
+		if model.Name != "" {
+			models = append(models, model)
+		}
+	}
+	if len(models) == 0 {
+		models = []AllowedModel{{Name: api.DefaultModel, Cost: 1}, {Name: "gpt-4o", Cost: 3}}
+	}
+	return models
+}
+
+// parseMaxCompletionTokens parses the MAX_COMPLETION_TOKENS variable, defaulting to defaultMaxCompletionTokens.
+func parseMaxCompletionTokens(raw string) int {
+	if raw == "" {
+		return defaultMaxCompletionTokens
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < minCompletionTokens {
+		log.Printf("Invalid MAX_COMPLETION_TOKENS %q, using %d", raw, defaultMaxCompletionTokens)
+		return defaultMaxCompletionTokens
+	}
+	return limit
+}
+
+// findModel returns the allowlisted model with the given name.
+func (a *App) findModel(name string) (AllowedModel, bool) {
+	for _, model := range a.AllowedModels {
+		if strings.EqualFold(model.Name, name) {
+			return model, true
+		}
+	}
+	return AllowedModel{}, false
+}
+
+// isAllowedModel reports whether the model is on the allowlist.
+func (a *App) isAllowedModel(name string) bool {
+	_, ok := a.findModel(name)
+	return ok
+}
+
+// allowedModelNames returns the names of the allowlisted models.
+func (a *App) allowedModelNames() []string {
+	names := make([]string, len(a.AllowedModels))
+	for i, model := range a.AllowedModels {
+		names[i] = model.Name
+	}
+	return names
+}
+
+// queryOptionsFor returns the model parameters a user has chosen, falling back to the default model when
+// the chosen one is no longer allowlisted and clamping max tokens to the current limit.
+func (a *App) queryOptionsFor(userID int) (api.QueryOptions, AllowedModel) {
+	settings := a.GetUserSettings(userID)
+	model, ok := a.findModel(settings.Model)
+	if !ok {
+		model = a.AllowedModels[0]
+	}
+	opts := api.QueryOptions{Model: model.Name, Temperature: settings.Temperature, MaxTokens: settings.MaxTokens}
+	if opts.MaxTokens > a.MaxCompletionTokens {
+		opts.MaxTokens = a.MaxCompletionTokens
+	}
+	return opts, model
+}
+
+// handleModelCommand processes /model: without arguments it lists the allowlisted models and their
+// cost tiers, "/model <name>" selects a model and "/model reset" goes back to the default.
+func (a *App) handleModelCommand(args string, message *types.TelegramMessage, userID int) (string, error) {
+	var reply string
+	switch name := strings.TrimSpace(args); {
+	case name == "":
+		reply = a.formatModels(userID)
+	case strings.EqualFold(name, "reset"):
+		if err := a.UpdateUserSettings(userID, func(s *UserSettings) { s.Model = "" }); err != nil {
+			reply = "❌ <b>Error Saving Model</b>\n\nPlease try again later."
+			break
+		}
+		reply = fmt.Sprintf("🤖 <b>Model Reset</b>\n\nYou are back on the default model <b>%s</b>.", EscapeHTML(a.AllowedModels[0].Name))
+	default:
+		model, ok := a.findModel(name)
+		if !ok {
+			reply = fmt.Sprintf("❌ <b>Model Not Allowed</b>\n\n%q is not on the allowlist.\n\n%s", EscapeHTML(name), a.formatModels(userID))
+			break
+		}
+		if err := a.UpdateUserSettings(userID, func(s *UserSettings) { s.Model = model.Name }); err != nil {
+			reply = "❌ <b>Error Saving Model</b>\n\nPlease try again later."
+			break
+		}
+		reply = fmt.Sprintf("🤖 <b>Model Selected</b>\n\nYour questions now use <b>%s</b>, which costs %s.",
+			EscapeHTML(model.Name), formatCost(model.Cost))
+	}
+
+	err := a.SendMessage(message.Chat.ID, reply, message.MessageID)
+	return "", err
+}
+
+// handleSettingsCommand processes /settings: without arguments it shows the user's generation settings,
+// "/settings temperature <0-2|default>" and "/settings max_tokens <n|default>" change them and
+// "/settings reset" restores every default, including the model.
+func (a *App) handleSettingsCommand(args string, message *types.TelegramMessage, userID int) (string, error) {
+	fields := strings.Fields(strings.ToLower(args))
+
+	var reply string
+	switch {
+	case len(fields) == 0:
+		reply = a.formatSettings(userID)
+	case len(fields) == 1 && fields[0] == "reset":
+		err := a.UpdateUserSettings(userID, func(s *UserSettings) {
+			s.Model = ""
+			s.Temperature = nil
+			s.MaxTokens = 0
+			s.NoCache = false
+		})
+		if err != nil {
+			reply = "❌ <b>Error Saving Settings</b>\n\nPlease try again later."
+			break
+		}
+		reply = "⚙️ <b>Settings Reset</b>\n\n" + a.formatSettings(userID)
+	case len(fields) == 2:
+		update, err := a.parseSetting(fields[0], fields[1])
+		if err != nil {
+			reply = fmt.Sprintf("❌ <b>Setting Not Changed</b>\n\n%s\n\n%s", EscapeHTML(err.Error()), a.settingsUsage())
+			break
+		}
+		if err := a.UpdateUserSettings(userID, update); err != nil {
+			reply = "❌ <b>Error Saving Settings</b>\n\nPlease try again later."
+			break
+		}
+		reply = "⚙️ <b>Settings Updated</b>\n\n" + a.formatSettings(userID)
+	default:
+		reply = a.settingsUsage()
+	}
+
+	err := a.SendMessage(message.Chat.ID, reply, message.MessageID)
+	return "", err
+}
+
+// parseSetting validates a /settings name and value and returns the change to apply.
+func (a *App) parseSetting(name, value string) (func(*UserSettings), error) {
+	switch name {
+	case "temperature":
+		if value == "default" {
+			return func(s *UserSettings) { s.Temperature = nil }, nil
+		}
+		temperature, err := strconv.ParseFloat(value, 64)
+		if err != nil || !isFinite(temperature) || temperature < 0 || temperature > maxTemperature {
+			return nil, fmt.Errorf("temperature must be a number between 0 and %.0f", maxTemperature)
+		}
+		return func(s *UserSettings) { s.Temperature = &temperature }, nil
+	case "max_tokens":
+		if value == "default" {
+			return func(s *UserSettings) { s.MaxTokens = 0 }, nil
+		}
+		maxTokens, err := strconv.Atoi(value)
+		if err != nil || maxTokens < minCompletionTokens || maxTokens > a.MaxCompletionTokens {
+			return nil, fmt.Errorf("max_tokens must be a whole number between %d and %d", minCompletionTokens, a.MaxCompletionTokens)
+		}
+		return func(s *UserSettings) { s.MaxTokens = maxTokens }, nil
+	default:
+		return nil, fmt.Errorf("unknown setting %q", name)
+	}
+}
+
+// formatModels lists the allowlisted models with their cost tiers, marking the user's current model.
+func (a *App) formatModels(userID int) string {
+	_, current := a.queryOptionsFor(userID)
+	var sb strings.Builder
+	sb.WriteString("🤖 <b>Available Models:</b>\n\n")
+	for i, model := range a.AllowedModels {
+		sb.WriteString(fmt.Sprintf("• <b>%s</b> — %s", EscapeHTML(model.Name), formatCost(model.Cost)))
+		if i == 0 {
+			sb.WriteString(" (default)")
+		}
+		if model.Name == current.Name {
+			sb.WriteString(" ✅")
+		}
+		sb.WriteString("\n")
+	}
+	limit, window := a.UsageCache.Limits()
+	sb.WriteString(fmt.Sprintf("\nYou can spend %d units every %d minutes. Use /model &lt;name&gt; to switch or /model reset for the default.",
+		limit, int(window.Minutes())))
+	return sb.String()
+}
+
+// formatSettings shows the user's model, generation parameters and remaining usage.
+func (a *App) formatSettings(userID int) string {
+	opts, model := a.queryOptionsFor(userID)
+	temperature := fmt.Sprintf("%.2g (default)", api.DefaultTemperature)
+	if opts.Temperature != nil {
+		temperature = fmt.Sprintf("%.2g", *opts.Temperature)
+	}
+	maxTokens := fmt.Sprintf("%d (default)", api.DefaultMaxTokens)
+	if opts.MaxTokens > 0 {
+		maxTokens = strconv.Itoa(opts.MaxTokens)
+	}
+	limit, window := a.UsageCache.Limits()
+	used := len(a.UsageCache.History(userID))
+	caching := "on"
+	if a.GetUserSettings(userID).NoCache {
+		caching = "off (/nocache)"
+	}
+
+	return fmt.Sprintf("⚙️ <b>Your Settings:</b>\n\n"+
+		"<b>Model:</b> %s (%s)\n"+
+		"<b>Temperature:</b> %s\n"+
+		"<b>Max tokens:</b> %s\n"+
+		"<b>Result cache:</b> %s\n"+
+		"<b>Usage:</b> %d of %d units in the last %d minutes\n\n%s",
+		EscapeHTML(model.Name), formatCost(model.Cost), temperature, maxTokens, caching, used, limit, int(window.Minutes()), a.settingsUsage())
+}
+
+// settingsUsage describes the /settings forms and their limits.
+func (a *App) settingsUsage() string {
+	return fmt.Sprintf("Usage:\n"+
+		"/settings temperature &lt;0-%.0f | default&gt;\n"+
+		"/settings max_tokens &lt;%d-%d | default&gt;\n"+
+		"/settings reset - Restore all defaults\n"+
+		"/model - Choose a model\n"+
+		"/nocache - Turn the result cache off or on", maxTemperature, minCompletionTokens, a.MaxCompletionTokens)
+}
+
+// formatCost describes a model's cost tier.
+func formatCost(cost int) string {
+	if cost == 1 {
+		return "1 unit per question"
+	}
+	return fmt.Sprintf("%d units per question", cost)
+}
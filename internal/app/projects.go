@@ -0,0 +1,240 @@
+// internal/app/projects.go
+
+package app
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"KernelSandersBot/internal/types"
+	"KernelSandersBot/internal/utils"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// namePattern restricts user-chosen names, such as project names, to short, S3-key-safe identifiers.
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// sourceCodeKey returns the S3 object key holding a project's source code.
+func sourceCodeKey(userID int, project string) string {
+	return fmt.Sprintf("user_source_code/%d/%s/source_code.txt", userID, project)
+}
+
+// legacySourceCodeKey returns the S3 object key that held a user's source code before projects existed.
+func legacySourceCodeKey(userID int) string {
+	return fmt.Sprintf("user_source_code/%d/source_code.txt", userID)
+}
+
+// migrateLegacySource moves source code stored under the pre-project key into the default project,
+// encrypting it and keeping its original upload time so its retention period is unchanged. The legacy
+// object is deleted once migrated, or right away if it expired or the default project already exists.
+// It reports whether source code was migrated.
+func (a *App) migrateLegacySource(userID int) (bool, error) {
+	legacyKey := legacySourceCodeKey(userID)
+	resp, err := a.S3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(a.S3BucketName),
+		Key:    aws.String(legacyKey),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return false, err
+	}
+
+	uploadedAt := time.Now()
+	if uploadedAtStr, ok := metadataValue(resp.Metadata, "uploaded_at"); ok {
+		if t, err := time.Parse(time.RFC3339, uploadedAtStr); err == nil {
+			uploadedAt = t
+		}
+	}
+	if time.Since(uploadedAt) > types.FileRetentionTime {
+		return false, a.deleteObject(legacyKey)
+	}
+	if _, err := a.S3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(a.S3BucketName),
+		Key:    aws.String(sourceCodeKey(userID, types.DefaultProjectName)),
+	}); err == nil {
+		// A newer upload already replaced the legacy source code
+		return false, a.deleteObject(legacyKey)
+	}
+
+	code, err := a.Keys.Decrypt(userID, body)
+	if err != nil {
+		return false, err
+	}
+	if err := a.putSourceCodeUploadedAt(userID, types.DefaultProjectName, string(code), uploadedAt); err != nil {
+		return false, err
+	}
+	if err := a.deleteObject(legacyKey); err != nil {
+		return false, err
+	}
+	log.Printf("Migrated legacy source code of user %d into the %s project", userID, types.DefaultProjectName)
+	return true, nil
+}
+
+// projectFromKey extracts the project name from a source code object key.
+func projectFromKey(key string) string {
+	// Key format is "user_source_code/{userID}/{project}/source_code.txt"
+	parts := strings.Split(key, "/")
+	if len(parts) != 4 {
+		return types.DefaultProjectName
+	}
+	return parts[2]
+}
+
+// normalizeProjectName lowercases a project name and validates it.
+func normalizeProjectName(name string) (string, error) {
+	return normalizeName("project", name)
+}
+
+// normalizeName lowercases a user-chosen name and validates it against namePattern.
+func normalizeName(kind, name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !namePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid %s name %q", kind, name)
+	}
+	return name, nil
+}
+
+// GetActiveProject returns the name of the project used for uploads and #source_code.
+func (a *App) GetActiveProject(userID int) string {
+	if project := a.GetUserSettings(userID).ActiveProject; project != "" {
+		return project
+	}
+	return types.DefaultProjectName
+}
+
+// findUserProject returns the stored project with the given name, if it exists and has not expired.
+func (a *App) findUserProject(userID int, project string) (types.UserFile, bool, error) {
+	files, err := a.ListUserFiles(userID)
+	if err != nil {
+		return types.UserFile{}, false, err
+	}
+	for _, file := range files {
+		if file.Project == project {
+			return file, true, nil
+		}
+	}
+	return types.UserFile{}, false, nil
+}
+
+// checkProjectCapacity returns an error if the user cannot add another project.
+func (a *App) checkProjectCapacity(userID int, project string) error {
+	files, err := a.ListUserFiles(userID)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.Project == project {
+			return nil
+		}
+	}
+	if len(files) >= types.MaxProjectsPerUser {
+		return fmt.Errorf("%w: you already have %d projects", types.ErrProjectQuotaExceeded, types.MaxProjectsPerUser)
+	}
+	return nil
+}
+
+// CreateProject creates an empty project and makes it the user's active project.
+func (a *App) CreateProject(userID int, name string) error {
+	project, err := normalizeProjectName(name)
+	if err != nil {
+		return err
+	}
+	if _, exists, err := a.findUserProject(userID, project); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("project %q already exists", project)
+	}
+	if err := a.checkProjectCapacity(userID, project); err != nil {
+		return err
+	}
+	if err := a.putSourceCode(userID, project, ""); err != nil {
+		return err
+	}
+	return a.UpdateUserSettings(userID, func(settings *UserSettings) {
+		settings.ActiveProject = project
+	})
+}
+
+// UseProject switches the user's active project to an existing project.
+func (a *App) UseProject(userID int, name string) error {
+	project, err := normalizeProjectName(name)
+	if err != nil {
+		return err
+	}
+	if _, exists, err := a.findUserProject(userID, project); err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("project %q does not exist or has expired", project)
+	}
+	return a.UpdateUserSettings(userID, func(settings *UserSettings) {
+		settings.ActiveProject = project
+	})
+}
+
+// handleProjectCommand processes /project_new, /project_use and /projects.
+func (a *App) handleProjectCommand(command, args string, message *types.TelegramMessage, userID int) (string, error) {
+	var reply string
+	switch command {
+	case "/project_new":
+		if err := a.CreateProject(userID, args); err != nil {
+			reply = fmt.Sprintf("❌ <b>Project Not Created</b>\n\n%s\n\nUsage: /project_new &lt;name&gt; (lowercase letters, digits, - and _)", EscapeHTML(err.Error()))
+			break
+		}
+		reply = fmt.Sprintf("✅ <b>Project Created</b>\n\n<b>%s</b> is now your active project. Upload source files to fill it.", EscapeHTML(a.GetActiveProject(userID)))
+	case "/project_use":
+		if err := a.UseProject(userID, args); err != nil {
+			reply = fmt.Sprintf("❌ <b>Project Not Switched</b>\n\n%s\n\nUse /projects to list your projects.", EscapeHTML(err.Error()))
+			break
+		}
+		reply = fmt.Sprintf("✅ <b>Project Switched</b>\n\n<b>%s</b> is now your active project and will be used for <code>#source_code</code>.", EscapeHTML(a.GetActiveProject(userID)))
+	case "/projects":
+		listing, err := a.formatUserProjects(userID)
+		if err != nil {
+			log.Printf("Failed to list projects for user %d: %v", userID, err)
+			reply = "✅ <b>Error Retrieving Projects</b>\n\nUnable to fetch your projects at this time. Please try again later."
+			break
+		}
+		reply = listing
+	}
+	err := a.SendMessage(message.Chat.ID, reply, message.MessageID)
+	return "", err
+}
+
+// formatUserProjects builds the /projects listing.
+func (a *App) formatUserProjects(userID int) (string, error) {
+	files, err := a.ListUserFiles(userID)
+	if err != nil {
+		return "", err
+	}
+	active := a.GetActiveProject(userID)
+
+	var sb strings.Builder
+	sb.WriteString("📁 <b>Your Projects:</b>\n\n")
+	if len(files) == 0 {
+		sb.WriteString("<b>No projects found.</b> Upload source code to create the <b>" + types.DefaultProjectName + "</b> project, or use /project_new &lt;name&gt;.\n")
+		return sb.String(), nil
+	}
+	for _, file := range files {
+		marker := ""
+		if file.Project == active {
+			marker = " (active)"
+		}
+		sb.WriteString(fmt.Sprintf("• <b>%s</b>%s — %d / %d KB, deleted at %s\n",
+			EscapeHTML(file.Project), marker, file.SizeBytes>>10, types.MaxProjectBytes>>10, utils.FormatTimeUTC(file.DeletionTimeUTC)))
+	}
+	sb.WriteString(fmt.Sprintf("\nYou can keep up to %d projects. Use /project_use &lt;name&gt; to switch and /project_new &lt;name&gt; to create one.", types.MaxProjectsPerUser))
+	return sb.String(), nil
+}
@@ -9,9 +9,11 @@ import (
 	"io"
 	"log"
 	"strings"
-	"sync"
 	"time"
 
+	"KernelSandersBot/internal/cache"
+	"KernelSandersBot/internal/encryption"
+	"KernelSandersBot/internal/expiring"
 	"KernelSandersBot/internal/s3client"
 	"KernelSandersBot/internal/types"
 
@@ -20,39 +22,72 @@ import (
 	"github.com/google/uuid"
 )
 
+// responseCleanupInterval is how often expired responses are removed from memory and S3.
+const responseCleanupInterval = 10 * time.Minute
+
+// Bounds of the cache of response IDs found in neither memory nor S3, which keeps repeated requests
+// for a missing response from each reaching S3.
+const (
+	responseMissTTL     = time.Minute
+	responseMissEntries = 10000
+)
+
 // ResponseStore manages stored responses with expiration tracking and user associations.
 type ResponseStore struct {
-	responses map[string]responseEntry
-	mutex     sync.RWMutex
-	s3Client  s3client.S3ClientInterface
-	bucket    string
+	store     *expiring.Store
+	persister *responsePersister
+	missing   *cache.Cache // IDs recently looked up and not found
+}
+
+// StoredResponse is a stored response as shown on its web page.
+type StoredResponse struct {
+	Content   string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Badges    []Badge
 }
 
 // responseEntry represents a response's content, creation time, and expiration time.
+// In S3 the content is held encrypted in SealedContent; in memory it is kept in Content.
 type responseEntry struct {
-	Content     string    `json:"content"`
-	CreatedAt   time.Time `json:"created_at"`
-	ExpiresAt   time.Time `json:"expires_at"`
-	OwnerUserID int       `json:"owner_user_id"`
+	Content       string    `json:"content,omitempty"`
+	SealedContent []byte    `json:"sealed_content,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	OwnerUserID   int       `json:"owner_user_id"`
+	Badges        []Badge   `json:"badges,omitempty"`
+}
+
+// Badge annotates a stored response on its web page, such as the result of a syntax check.
+type Badge struct {
+	Label   string `json:"label"`
+	Warning bool   `json:"warning,omitempty"`
 }
 
 // NewResponseStore initializes the ResponseStore with S3Client and begins the cleanup routine.
-func NewResponseStore(s3Client s3client.S3ClientInterface, bucket string) *ResponseStore {
-	rs := &ResponseStore{
-		responses: make(map[string]responseEntry),
-		s3Client:  s3Client,
-		bucket:    bucket,
+func NewResponseStore(s3Client s3client.S3ClientInterface, bucket string, keys *encryption.KeyManager) *ResponseStore {
+	persister := &responsePersister{s3Client: s3Client, bucket: bucket, keys: keys}
+	missing := cache.New(cache.Options{TTL: responseMissTTL, MaxEntries: responseMissEntries})
+	missing.StartEviction(responseCleanupInterval)
+	return &ResponseStore{
+		store: expiring.New(expiring.Options{
+			TTL:             types.FileRetentionTime,
+			CleanupInterval: responseCleanupInterval,
+			Persister:       persister,
+		}),
+		persister: persister,
+		missing:   missing,
 	}
-	go rs.cleanupExpiredResponses()
-	return rs
 }
 
 // StoreResponseForUser stores the response content associated with a user in both memory and S3.
 // Returns a unique ID for the response.
 func (rs *ResponseStore) StoreResponseForUser(content string, userID int) string {
-	rs.mutex.Lock()
-	defer rs.mutex.Unlock()
+	return rs.StoreResponseWithBadges(content, userID, nil)
+}
 
+// StoreResponseWithBadges stores a response like StoreResponseForUser, annotated with badges shown on its web page.
+func (rs *ResponseStore) StoreResponseWithBadges(content string, userID int, badges []Badge) string {
 	id := uuid.New().String()
 	now := time.Now()
 	entry := responseEntry{
@@ -60,79 +95,47 @@ func (rs *ResponseStore) StoreResponseForUser(content string, userID int) string
 		CreatedAt:   now,
 		ExpiresAt:   now.Add(types.FileRetentionTime), // Set expiration to 4 hours
 		OwnerUserID: userID,
+		Badges:      badges,
 	}
-	rs.responses[id] = entry
-
-	// Serialize the entry to JSON
-	entryJSON, err := json.Marshal(entry)
-	if err != nil {
-		log.Printf("Failed to marshal response entry: %v", err)
-		return ""
-	}
-
-	// Upload to S3
-	objectKey := fmt.Sprintf("web_responses/%s.json", id)
-	_, err = rs.s3Client.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(rs.bucket),
-		Key:    aws.String(objectKey),
-		Body:   bytes.NewReader(entryJSON),
-	})
-	if err != nil {
-		log.Printf("Failed to upload response to S3: %v", err)
+	rs.missing.Delete(id)
+	if err := rs.store.SetUntil(id, entry, entry.ExpiresAt); err != nil {
+		log.Printf("Failed to store response in S3: %v", err)
 		return ""
 	}
-
 	return id
 }
 
 // LoadResponsesFromS3 loads all existing responses from the S3 bucket into the in-memory store.
+// Responses that expired while the bot was stopped are deleted, and plaintext responses are encrypted.
 func (rs *ResponseStore) LoadResponsesFromS3() error {
-	rs.mutex.Lock()
-	defer rs.mutex.Unlock()
-
-	prefix := "web_responses/"
 	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(rs.bucket),
-		Prefix: aws.String(prefix),
+		Bucket: aws.String(rs.persister.bucket),
+		Prefix: aws.String("web_responses/"),
 	}
 
-	err := rs.s3Client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+	err := rs.persister.s3Client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
 		for _, obj := range page.Contents {
-			// Retrieve each response JSON from S3
-			getInput := &s3.GetObjectInput{
-				Bucket: aws.String(rs.bucket),
-				Key:    obj.Key,
-			}
-			resp, err := rs.s3Client.GetObject(getInput)
-			if err != nil {
-				log.Printf("Failed to get object %s from S3: %v", *obj.Key, err)
+			id := getResponseIDFromKey(*obj.Key)
+			if id == "" {
 				continue
 			}
-			bodyBytes, err := io.ReadAll(resp.Body)
-			resp.Body.Close()
+			entry, plaintext, err := rs.persister.fetchEntry(id)
 			if err != nil {
-				log.Printf("Failed to read object %s from S3: %v", *obj.Key, err)
+				log.Printf("Failed to load object %s from S3: %v", *obj.Key, err)
 				continue
 			}
 
-			var entry responseEntry
-			if err := json.Unmarshal(bodyBytes, &entry); err != nil {
-				log.Printf("Failed to unmarshal JSON for object %s: %v", *obj.Key, err)
-				continue
-			}
-
-			// Check if the response has already expired
 			if time.Now().After(entry.ExpiresAt) {
-				// Optionally delete expired responses immediately
-				rs.DeleteResponse(getResponseIDFromKey(*obj.Key))
+				rs.persister.Delete(id)
 				continue
 			}
-
-			// Extract the response ID from the object key
-			id := getResponseIDFromKey(*obj.Key)
-
-			// Add to in-memory store
-			rs.responses[id] = entry
+			if plaintext && rs.persister.keys.Enabled() {
+				// Seal responses stored before encryption was enabled so crypto-shredding covers them
+				if err := rs.persister.Save(id, entry, entry.ExpiresAt); err != nil {
+					log.Printf("Failed to encrypt legacy response %s: %v", id, err)
+				}
+			}
+			rs.store.Restore(id, entry, entry.ExpiresAt)
 		}
 		return true // Continue to next page
 	})
@@ -158,212 +161,235 @@ func getResponseIDFromKey(key string) string {
 	return id
 }
 
+// isResponseID reports whether id has the canonical UUID form of the IDs given to responses.
+func isResponseID(id string) bool {
+	_, err := uuid.Parse(id)
+	return err == nil && len(id) == 36
+}
+
+// entry returns a response that has not expired, loading it from S3 if it is not in memory. IDs that
+// are malformed or were recently not found are rejected without reaching S3.
+func (rs *ResponseStore) entry(id string) (responseEntry, bool) {
+	if !isResponseID(id) {
+		return responseEntry{}, false
+	}
+	if _, missing := rs.missing.Get(id); missing {
+		return responseEntry{}, false
+	}
+	value, ok := rs.store.Get(id)
+	if !ok {
+		rs.missing.Set(id, true)
+		return responseEntry{}, false
+	}
+	return value.(responseEntry), true
+}
+
+// Lookup retrieves a response that has not expired by ID, with everything its web page shows.
+// It first checks the in-memory store, then attempts to retrieve it from S3 if not found.
+func (rs *ResponseStore) Lookup(id string) (StoredResponse, bool) {
+	entry, ok := rs.entry(id)
+	if !ok {
+		return StoredResponse{}, false
+	}
+	return StoredResponse{Content: entry.Content, CreatedAt: entry.CreatedAt, ExpiresAt: entry.ExpiresAt, Badges: entry.Badges}, true
+}
+
 // GetResponse retrieves the response content by ID if it hasn't expired.
-// It first checks the in-memory store, then attempts to retrieve from S3 if not found.
 func (rs *ResponseStore) GetResponse(id string) (string, bool) {
-	rs.mutex.RLock()
-	entry, exists := rs.responses[id]
-	rs.mutex.RUnlock()
+	entry, ok := rs.entry(id)
+	return entry.Content, ok
+}
 
-	if exists {
-		if time.Now().After(entry.ExpiresAt) {
-			return "", false
+// GetUserResponsesByUserID retrieves all unexpired responses in memory that belong to a user.
+// Responses stored in S3 are loaded into memory at startup by LoadResponsesFromS3.
+func (rs *ResponseStore) GetUserResponsesByUserID(userID int) ([]types.UserResponse, error) {
+	var responses []types.UserResponse
+	rs.store.Range(func(id string, value interface{}, _ time.Time) bool {
+		entry := value.(responseEntry)
+		if entry.OwnerUserID == userID {
+			responses = append(responses, types.UserResponse{
+				ID:              id,
+				CreatedAtUTC:    entry.CreatedAt.UTC(),
+				CreatedAtEDT:    entry.CreatedAt.In(time.FixedZone("EDT", -4*3600)),
+				DeletionTimeUTC: entry.ExpiresAt.UTC(),
+				DeletionTimeEDT: entry.ExpiresAt.In(time.FixedZone("EDT", -4*3600)),
+			})
 		}
-		return entry.Content, true
-	}
+		return true
+	})
+	return responses, nil
+}
 
-	// Attempt to retrieve from S3
-	objectKey := fmt.Sprintf("web_responses/%s.json", id)
-	resp, err := rs.s3Client.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(rs.bucket),
-		Key:    aws.String(objectKey),
+// DeleteResponse removes a response from both memory and S3.
+func (rs *ResponseStore) DeleteResponse(id string) {
+	rs.store.Delete(id)
+}
+
+// DeleteUserResponses removes every response owned by the user from memory and S3, including
+// responses that exist only in S3. It returns the number of responses deleted.
+func (rs *ResponseStore) DeleteUserResponses(userID int) (int, error) {
+	ids := make(map[string]struct{})
+	rs.store.Range(func(id string, value interface{}, _ time.Time) bool {
+		if value.(responseEntry).OwnerUserID == userID {
+			ids[id] = struct{}{}
+		}
+		return true
 	})
-	if err != nil {
-		log.Printf("Failed to retrieve response from S3 for ID %s: %v", id, err)
-		return "", false
-	}
-	defer resp.Body.Close()
 
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Failed to read response body from S3 for ID %s: %v", id, err)
-		return "", false
-	}
+	// The owner is stored unencrypted, so S3 objects can be matched without decrypting them
+	s3Client, bucket := rs.persister.s3Client, rs.persister.bucket
+	listErr := s3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String("web_responses/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			id := getResponseIDFromKey(aws.StringValue(obj.Key))
+			if _, known := ids[id]; known || id == "" {
+				continue
+			}
+			resp, err := s3Client.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: obj.Key})
+			if err != nil {
+				log.Printf("Failed to get object %s from S3: %v", aws.StringValue(obj.Key), err)
+				continue
+			}
+			var owner struct {
+				OwnerUserID int `json:"owner_user_id"`
+			}
+			err = json.NewDecoder(resp.Body).Decode(&owner)
+			resp.Body.Close()
+			if err == nil && owner.OwnerUserID == userID {
+				ids[id] = struct{}{}
+			}
+		}
+		return true
+	})
 
-	var s3Entry responseEntry
-	if err := json.Unmarshal(bodyBytes, &s3Entry); err != nil {
-		log.Printf("Failed to unmarshal response JSON from S3 for ID %s: %v", id, err)
-		return "", false
+	deleted := 0
+	var deleteErr error
+	for id := range ids {
+		if _, err := rs.store.Delete(id); err != nil {
+			deleteErr = err
+			continue
+		}
+		deleted++
 	}
-
-	// Check expiration
-	if time.Now().After(s3Entry.ExpiresAt) {
-		// Optionally, delete the expired response from S3
-		rs.DeleteResponse(id)
-		return "", false
+	if listErr != nil {
+		return deleted, listErr
 	}
+	return deleted, deleteErr
+}
 
-	// Update in-memory store
-	rs.mutex.Lock()
-	rs.responses[id] = s3Entry
-	rs.mutex.Unlock()
-
-	return s3Entry.Content, true
+// Close stops the cleanup routines.
+func (rs *ResponseStore) Close() {
+	rs.store.Close()
+	rs.missing.Stop()
 }
 
-// GetCreationTime returns the creation time of a stored response by ID.
-func (rs *ResponseStore) GetCreationTime(id string) (time.Time, bool) {
-	rs.mutex.RLock()
-	entry, exists := rs.responses[id]
-	rs.mutex.RUnlock()
+// responsePersister stores response entries in S3 under web_responses/, encrypted with the owner's key.
+type responsePersister struct {
+	s3Client s3client.S3ClientInterface
+	bucket   string
+	keys     *encryption.KeyManager
+}
 
-	if exists {
-		return entry.CreatedAt, true
-	}
+// objectKey returns the S3 key of a response.
+func (p *responsePersister) objectKey(id string) string {
+	return fmt.Sprintf("web_responses/%s.json", id)
+}
 
-	// Attempt to retrieve from S3
-	objectKey := fmt.Sprintf("web_responses/%s.json", id)
-	resp, err := rs.s3Client.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(rs.bucket),
-		Key:    aws.String(objectKey),
-	})
+func (p *responsePersister) Save(id string, value interface{}, _ time.Time) error {
+	// Encrypt the content with the owner's key before it leaves memory
+	sealed, err := p.sealEntry(value.(responseEntry))
 	if err != nil {
-		log.Printf("Failed to retrieve creation time from S3 for ID %s: %v", id, err)
-		return time.Time{}, false
+		return fmt.Errorf("encrypt response entry: %w", err)
 	}
-	defer resp.Body.Close()
-
-	bodyBytes, err := io.ReadAll(resp.Body)
+	entryJSON, err := json.Marshal(sealed)
 	if err != nil {
-		log.Printf("Failed to read creation time body from S3 for ID %s: %v", id, err)
-		return time.Time{}, false
+		return fmt.Errorf("marshal response entry: %w", err)
 	}
+	_, err = p.s3Client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(p.objectKey(id)),
+		Body:   bytes.NewReader(entryJSON),
+	})
+	return err
+}
 
-	var s3Entry responseEntry
-	if err := json.Unmarshal(bodyBytes, &s3Entry); err != nil {
-		log.Printf("Failed to unmarshal creation time JSON from S3 for ID %s: %v", id, err)
-		return time.Time{}, false
+func (p *responsePersister) Load(id string) (interface{}, time.Time, bool, error) {
+	entry, _, err := p.fetchEntry(id)
+	if isNotFound(err) {
+		return nil, time.Time{}, false, nil
 	}
-
-	// Update in-memory store
-	rs.mutex.Lock()
-	rs.responses[id] = s3Entry
-	rs.mutex.Unlock()
-
-	return s3Entry.CreatedAt, true
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	return entry, entry.ExpiresAt, true, nil
 }
 
-// GetExpirationTime returns the expiration time of a stored response by ID.
-func (rs *ResponseStore) GetExpirationTime(id string) (time.Time, bool) {
-	rs.mutex.RLock()
-	entry, exists := rs.responses[id]
-	rs.mutex.RUnlock()
-
-	if exists {
-		return entry.ExpiresAt, true
+func (p *responsePersister) Delete(id string) error {
+	_, err := p.s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(p.objectKey(id)),
+	})
+	if err != nil {
+		log.Printf("Failed to delete response from S3 for ID %s: %v", id, err)
+		return err
 	}
+	log.Printf("Successfully deleted response ID %s from S3.", id)
+	return nil
+}
 
-	// Attempt to retrieve from S3
-	objectKey := fmt.Sprintf("web_responses/%s.json", id)
-	resp, err := rs.s3Client.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(rs.bucket),
-		Key:    aws.String(objectKey),
+// fetchEntry reads a response entry from S3 and decrypts its content. It also reports whether the
+// content was stored in plaintext, as it was before encryption was enabled.
+func (p *responsePersister) fetchEntry(id string) (responseEntry, bool, error) {
+	var entry responseEntry
+	resp, err := p.s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(p.objectKey(id)),
 	})
 	if err != nil {
-		log.Printf("Failed to retrieve expiration time from S3 for ID %s: %v", id, err)
-		return time.Time{}, false
+		return entry, false, err
 	}
 	defer resp.Body.Close()
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("Failed to read expiration time body from S3 for ID %s: %v", id, err)
-		return time.Time{}, false
+		return entry, false, err
 	}
-
-	var s3Entry responseEntry
-	if err := json.Unmarshal(bodyBytes, &s3Entry); err != nil {
-		log.Printf("Failed to unmarshal expiration time JSON from S3 for ID %s: %v", id, err)
-		return time.Time{}, false
+	if err := json.Unmarshal(bodyBytes, &entry); err != nil {
+		return entry, false, err
 	}
-
-	// Update in-memory store
-	rs.mutex.Lock()
-	rs.responses[id] = s3Entry
-	rs.mutex.Unlock()
-
-	return s3Entry.ExpiresAt, true
-}
-
-// GetUserResponsesByUserID retrieves all responses associated with a user from both memory and S3.
-func (rs *ResponseStore) GetUserResponsesByUserID(userID int) ([]types.UserResponse, error) {
-	rs.mutex.RLock()
-	defer rs.mutex.RUnlock()
-
-	var responses []types.UserResponse
-	for id, entry := range rs.responses {
-		if entry.OwnerUserID == userID && time.Now().Before(entry.ExpiresAt) {
-			responses = append(responses, types.UserResponse{
-				ID:              id,
-				CreatedAtUTC:    entry.CreatedAt.UTC(),
-				CreatedAtEDT:    entry.CreatedAt.In(time.FixedZone("EDT", -4*3600)),
-				DeletionTimeUTC: entry.ExpiresAt.UTC(),
-				DeletionTimeEDT: entry.ExpiresAt.In(time.FixedZone("EDT", -4*3600)),
-			})
-		}
+	plaintext := entry.SealedContent == nil && entry.Content != ""
+	if err := p.openEntry(&entry); err != nil {
+		return entry, false, err
 	}
-
-	// Optionally, retrieve from S3 if not present in memory
-	// Implementation depends on how responses are indexed and stored in S3
-	// For simplicity, this example assumes all relevant responses are loaded into memory
-
-	return responses, nil
+	return entry, plaintext, nil
 }
 
-// DeleteResponse removes a response from both memory and S3.
-func (rs *ResponseStore) DeleteResponse(id string) {
-	rs.mutex.Lock()
-	defer rs.mutex.Unlock()
-
-	delete(rs.responses, id)
-
-	// Delete from S3
-	objectKey := fmt.Sprintf("web_responses/%s.json", id)
-	_, err := rs.s3Client.DeleteObject(&s3.DeleteObjectInput{
-		Bucket: aws.String(rs.bucket),
-		Key:    aws.String(objectKey),
-	})
-
+// sealEntry returns a copy of the entry with its content encrypted under the owner's key.
+func (p *responsePersister) sealEntry(entry responseEntry) (responseEntry, error) {
+	if !p.keys.Enabled() {
+		return entry, nil
+	}
+	sealed, err := p.keys.Encrypt(entry.OwnerUserID, []byte(entry.Content))
 	if err != nil {
-		log.Printf("Failed to delete response from S3 for ID %s: %v", id, err)
-	} else {
-		log.Printf("Successfully deleted response ID %s from S3.", id)
+		return entry, err
 	}
+	entry.SealedContent = sealed
+	entry.Content = ""
+	return entry, nil
 }
 
-// cleanupExpiredResponses periodically removes expired responses from both memory and S3.
-func (rs *ResponseStore) cleanupExpiredResponses() {
-	ticker := time.NewTicker(10 * time.Minute)
-	defer ticker.Stop()
-	for range ticker.C {
-		rs.mutex.Lock()
-		for id, entry := range rs.responses {
-			if time.Now().After(entry.ExpiresAt) {
-				delete(rs.responses, id)
-
-				// Delete from S3
-				objectKey := fmt.Sprintf("web_responses/%s.json", id)
-				_, err := rs.s3Client.DeleteObject(&s3.DeleteObjectInput{
-					Bucket: aws.String(rs.bucket),
-					Key:    aws.String(objectKey),
-				})
-				if err != nil {
-					log.Printf("Failed to delete expired response from S3 for ID %s: %v", id, err)
-				} else {
-					log.Printf("Successfully deleted expired response ID %s from S3.", id)
-				}
-			}
-		}
-		rs.mutex.Unlock()
+// openEntry decrypts an entry's sealed content in place. Entries stored before encryption was enabled are left as is.
+func (p *responsePersister) openEntry(entry *responseEntry) error {
+	if entry.SealedContent == nil {
+		return nil
 	}
+	content, err := p.keys.Decrypt(entry.OwnerUserID, entry.SealedContent)
+	if err != nil {
+		return err
+	}
+	entry.Content = string(content)
+	entry.SealedContent = nil
+	return nil
 }
@@ -0,0 +1,294 @@
+// internal/app/saved_conversations.go
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"KernelSandersBot/internal/types"
+	"KernelSandersBot/internal/utils"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// savedConversationPrefix is the S3 prefix under which named conversations are saved.
+const savedConversationPrefix = "saved_conversations/"
+
+// savedConversationSweepInterval is how often expired saved conversations are deleted.
+const savedConversationSweepInterval = time.Hour
+
+// SavedConversation is the S3 representation of a named conversation.
+type SavedConversation struct {
+	Name         string    `json:"name"`
+	SavedAt      time.Time `json:"saved_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	MessageCount int       `json:"message_count"`
+	Data         []byte    `json:"data"` // Messages as JSON, encrypted with the owner's data key
+}
+
+// savedConversationKey returns the S3 object key of a saved conversation.
+func savedConversationKey(userID int, name string) string {
+	return fmt.Sprintf("%s%d/%s.json", savedConversationPrefix, userID, name)
+}
+
+// SaveConversation stores the messages of a conversation under a name, replacing any earlier save with that name.
+func (a *App) SaveConversation(userID int, name string, messages []types.OpenAIMessage) error {
+	name, err := normalizeName("conversation", name)
+	if err != nil {
+		return err
+	}
+
+	saved, err := a.ListSavedConversations(userID)
+	if err != nil {
+		return err
+	}
+	exists := false
+	for _, s := range saved {
+		if s.Name == name {
+			exists = true
+		}
+	}
+	if !exists && len(saved) >= types.MaxSavedConversations {
+		return fmt.Errorf("you already have %d saved conversations; overwrite one or wait for one to expire", types.MaxSavedConversations)
+	}
+
+	messagesJSON, err := json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+	data, err := a.Keys.Encrypt(userID, messagesJSON)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	record, err := json.Marshal(SavedConversation{
+		Name:         name,
+		SavedAt:      now,
+		ExpiresAt:    now.Add(types.SavedConversationRetention),
+		MessageCount: len(messages),
+		Data:         data,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = a.S3Client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(a.S3BucketName),
+		Key:    aws.String(savedConversationKey(userID, name)),
+		Body:   bytes.NewReader(record),
+	})
+	return err
+}
+
+// LoadSavedConversation returns the messages of a saved conversation. Expired saves are deleted and reported as missing.
+func (a *App) LoadSavedConversation(userID int, name string) ([]types.OpenAIMessage, error) {
+	name, err := normalizeName("conversation", name)
+	if err != nil {
+		return nil, err
+	}
+	record, found, err := a.readSavedConversation(savedConversationKey(userID, name))
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("no saved conversation named %q", name)
+	}
+
+	plaintext, err := a.Keys.Decrypt(userID, record.Data)
+	if err != nil {
+		return nil, err
+	}
+	var messages []types.OpenAIMessage
+	if err := json.Unmarshal(plaintext, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ListSavedConversations returns the user's unexpired saved conversations, newest first. Expired saves are deleted.
+func (a *App) ListSavedConversations(userID int) ([]SavedConversation, error) {
+	var objectKeys []string
+	err := a.S3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(a.S3BucketName),
+		Prefix: aws.String(fmt.Sprintf("%s%d/", savedConversationPrefix, userID)),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			objectKeys = append(objectKeys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var saved []SavedConversation
+	for _, objectKey := range objectKeys {
+		record, found, err := a.readSavedConversation(objectKey)
+		if err != nil {
+			log.Printf("Failed to read saved conversation %s: %v", objectKey, err)
+			continue
+		}
+		if found {
+			record.Data = nil
+			saved = append(saved, record)
+		}
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].SavedAt.After(saved[j].SavedAt) })
+	return saved, nil
+}
+
+// DeleteExpiredSavedConversations deletes every saved conversation past its retention. Saving
+// rewrites the object, so its modification time is when it was saved.
+func (a *App) DeleteExpiredSavedConversations() (int, error) {
+	cutoff := time.Now().Add(-types.SavedConversationRetention)
+	var expired []string
+	err := a.S3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(a.S3BucketName),
+		Prefix: aws.String(savedConversationPrefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			if aws.TimeValue(obj.LastModified).Before(cutoff) {
+				expired = append(expired, aws.StringValue(obj.Key))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, objectKey := range expired {
+		if err := a.deleteObject(objectKey); err != nil {
+			log.Printf("Failed to delete expired saved conversation %s: %v", objectKey, err)
+			continue
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d expired saved conversations from S3", deleted)
+	}
+	return deleted, nil
+}
+
+// startSavedConversationSweep deletes expired saved conversations every interval until the app shuts
+// down, so the retention holds for saves that are never read again.
+func (a *App) startSavedConversationSweep(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := a.DeleteExpiredSavedConversations(); err != nil {
+				log.Printf("Failed to sweep expired saved conversations: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-a.ShutdownChan:
+				return
+			}
+		}
+	}()
+}
+
+// readSavedConversation reads a saved conversation record, deleting it if it has expired.
+func (a *App) readSavedConversation(objectKey string) (SavedConversation, bool, error) {
+	var record SavedConversation
+	resp, err := a.S3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(a.S3BucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return record, false, nil
+		}
+		return record, false, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return record, false, err
+	}
+	if err := json.Unmarshal(bodyBytes, &record); err != nil {
+		return record, false, err
+	}
+	if time.Now().After(record.ExpiresAt) {
+		a.deleteObject(objectKey)
+		return record, false, nil
+	}
+	return record, true, nil
+}
+
+// handleSavedConversationCommand processes /save, /load and /threads. /save only reads a conversation
+// the user owns: their conversation in the chat, or their own thread they reply to.
+func (a *App) handleSavedConversationCommand(command, args string, message *types.TelegramMessage, userID int) (string, error) {
+	key, parent := a.conversationKey(message.Chat.ID, userID, a.replyToBotMessageID(message))
+	name := strings.ToLower(strings.TrimSpace(args))
+
+	var reply string
+	switch command {
+	case "/save":
+		if owner, err := conversationOwner(key); parent != "" || err != nil || owner != userID {
+			reply = "🔒 <b>Not Your Conversation</b>\n\nYou can only save your own conversations. Reply without quoting another user's answer to save yours."
+			break
+		}
+		messages, exists := a.getConversation(key)
+		if !exists {
+			reply = "💬 <b>No Conversation</b>\n\nThere is no active conversation in this chat to save."
+			break
+		}
+		if err := a.SaveConversation(userID, args, messages); err != nil {
+			reply = fmt.Sprintf("❌ <b>Conversation Not Saved</b>\n\n%s\n\nUsage: /save &lt;name&gt; (lowercase letters, digits, - and _)", EscapeHTML(err.Error()))
+			break
+		}
+		reply = fmt.Sprintf("💾 <b>Conversation Saved</b>\n\nSaved as <b>%s</b> until %s. Use /load %s to continue it later.",
+			EscapeHTML(name), utils.FormatTimeUTC(time.Now().Add(types.SavedConversationRetention)), EscapeHTML(name))
+	case "/load":
+		messages, err := a.LoadSavedConversation(userID, args)
+		if err != nil {
+			reply = fmt.Sprintf("❌ <b>Conversation Not Loaded</b>\n\n%s\n\nUse /threads to list your saved conversations.", EscapeHTML(err.Error()))
+			break
+		}
+		// Saved conversations belong to the user, so they are loaded into the user's own conversation in this chat
+		key, _ = a.conversationKey(message.Chat.ID, userID, 0)
+		a.setConversation(key, messages)
+		reply = fmt.Sprintf("📂 <b>Conversation Loaded</b>\n\n<b>%s</b> (%d messages) is now the active conversation. Continue where you left off.",
+			EscapeHTML(name), len(messages))
+	case "/threads":
+		saved, err := a.ListSavedConversations(userID)
+		if err != nil {
+			log.Printf("Failed to list saved conversations for user %d: %v", userID, err)
+			reply = "❌ <b>Error Retrieving Saved Conversations</b>\n\nPlease try again later."
+			break
+		}
+		reply = formatSavedConversations(saved)
+	}
+
+	err := a.SendMessage(message.Chat.ID, reply, message.MessageID)
+	return "", err
+}
+
+// formatSavedConversations builds the /threads listing.
+func formatSavedConversations(saved []SavedConversation) string {
+	var sb strings.Builder
+	sb.WriteString("🗂️ <b>Saved Conversations:</b>\n\n")
+	if len(saved) == 0 {
+		sb.WriteString("<b>No saved conversations.</b> Use /save &lt;name&gt; to save the current conversation.")
+		return sb.String()
+	}
+	for _, s := range saved {
+		sb.WriteString(fmt.Sprintf("• <b>%s</b> — %d messages, saved %s, deleted at %s\n",
+			EscapeHTML(s.Name), s.MessageCount, utils.FormatTimeUTC(s.SavedAt), utils.FormatTimeUTC(s.ExpiresAt)))
+	}
+	sb.WriteString(fmt.Sprintf("\nSaved conversations are kept for %d days; you can keep up to %d. Use /load &lt;name&gt; to continue one.",
+		int(types.SavedConversationRetention.Hours()/24), types.MaxSavedConversations))
+	return sb.String()
+}
@@ -0,0 +1,107 @@
+// internal/app/source_browsing.go
+
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"KernelSandersBot/internal/api"
+	"KernelSandersBot/internal/goindex"
+	"KernelSandersBot/internal/sourcetree"
+	"KernelSandersBot/internal/tools"
+	"KernelSandersBot/internal/types"
+)
+
+// sourceToolsHint tells the model that the user's upload can be browsed with tools.
+const sourceToolsHint = "The user has uploaded the source code of their project %q (%s). " +
+	"It is not included in this conversation; use the list_files, read_file, grep and find_symbol tools " +
+	"to look up the code you need instead of guessing, and cite file paths and line numbers in your answer."
+
+// browseTag asks for the user's upload to be browsed when answering in a group chat.
+const browseTag = "#browse"
+
+// Limits for the definitions of mentioned Go symbols added to a question.
+const (
+	maxMentionedSymbols = 3
+	maxDefinitionLines  = 80
+)
+
+// activeSourceTree returns the parsed source tree of the user's active project and the name of the project.
+func (a *App) activeSourceTree(userID int) (*sourcetree.Tree, string, bool) {
+	code, ok := a.GetUserSourceCode(userID)
+	if !ok {
+		return nil, "", false
+	}
+	tree := sourcetree.Parse(code, "source_code.txt")
+	if len(tree.Files) == 0 {
+		return nil, "", false
+	}
+	return tree, a.GetActiveProject(userID), true
+}
+
+// shouldBrowse reports whether a question may browse the user's upload. Browsing is on in private
+// chats; in group chats, where other members see the answer, only questions tagged #browse use it.
+func shouldBrowse(chatID int64, userID int, question string) bool {
+	return chatID == int64(userID) || strings.Contains(strings.ToLower(question), browseTag)
+}
+
+// queryModel asks the model to answer a conversation. When browse is set and the user has uploaded
+// source code, the model can pull the parts it needs through tool calls rather than having the whole
+// upload pasted into the prompt, and the definitions of Go symbols named in the question are included.
+// The Go code in the answer is syntax-checked, and fixed by the model if needed, before it is returned.
+func (a *App) queryModel(userID int, messages []types.OpenAIMessage, opts api.QueryOptions, browse bool) (string, error) {
+	answer, err := a.answer(userID, messages, opts, browse)
+	if err != nil {
+		return "", err
+	}
+	return a.verifyGoCode(messages, opts, answer), nil
+}
+
+// answer queries the model for queryModel, with tools over the active project when browse is set.
+func (a *App) answer(userID int, messages []types.OpenAIMessage, opts api.QueryOptions, browse bool) (string, error) {
+	if !browse {
+		return a.APIHandler.QueryOpenAIWithOptions(messages, opts)
+	}
+	tree, project, ok := a.activeSourceTree(userID)
+	if !ok {
+		return a.APIHandler.QueryOpenAIWithOptions(messages, opts)
+	}
+
+	index := goindex.Build(tree)
+	prompt := append(append([]types.OpenAIMessage(nil), messages...), types.OpenAIMessage{
+		Role:    "system",
+		Content: fmt.Sprintf(sourceToolsHint, project, tree.Summary()),
+	})
+	if question := lastUserMessage(messages); question != "" {
+		if definitions := mentionedDefinitions(tree, index, question); definitions != "" {
+			prompt = append(prompt, types.OpenAIMessage{Role: "system", Content: definitions})
+		}
+	}
+	return a.APIHandler.QueryOpenAIWithTools(prompt, opts, tools.NewSourceTools(tree, index), api.DefaultMaxToolSteps)
+}
+
+// mentionedDefinitions returns the source of the Go symbols a question refers to, formatted for the model.
+func mentionedDefinitions(tree *sourcetree.Tree, index *goindex.Index, question string) string {
+	symbols := index.Mentioned(question, maxMentionedSymbols)
+	if len(symbols) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("Definitions of the symbols mentioned in the question, from the user's upload:\n")
+	for _, sym := range symbols {
+		sb.WriteString(fmt.Sprintf("\n%s (%s:%d-%d):\n```go\n%s\n```\n",
+			sym.QualifiedName(), sym.File, sym.Line, sym.EndLine, goindex.Source(tree, sym, maxDefinitionLines)))
+	}
+	return sb.String()
+}
+
+// lastUserMessage returns the content of the most recent user message.
+func lastUserMessage(messages []types.OpenAIMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
@@ -0,0 +1,287 @@
+// internal/app/system_prompts.go
+
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"KernelSandersBot/internal/types"
+)
+
+// defaultSystemPrompt is used when neither the user nor the chat has configured a system prompt.
+const defaultSystemPrompt = "You are a helpful assistant."
+
+// maxSystemPromptLength is the longest custom system prompt accepted, in characters.
+const maxSystemPromptLength = 2000
+
+// personas are the built-in system prompts selectable with /system persona.
+var personas = map[string]string{
+	"code_reviewer": "You are a meticulous senior code reviewer. Point out bugs, unclear naming, missing error handling, " +
+		"concurrency issues and maintainability problems. Be specific: reference the code you are discussing, explain why " +
+		"it matters and suggest a concrete fix. Acknowledge what is done well, but keep the focus on actionable feedback.",
+	"go_expert": "You are an expert Go engineer. Answer with idiomatic Go that follows Effective Go and the standard " +
+		"library's conventions: explicit error handling, small interfaces, clear package boundaries and careful use of " +
+		"goroutines and channels. Prefer the standard library over third-party packages and explain trade-offs briefly.",
+	"security_auditor": "You are an application security auditor. Look for injection, authentication and authorization " +
+		"flaws, secrets in code, unsafe deserialization, insecure cryptography, path traversal and unvalidated input. " +
+		"Rate each finding by severity, explain how it could be exploited and recommend a fix.",
+}
+
+// ChatSettings holds per-group defaults set by chat admins, persisted in S3.
+type ChatSettings struct {
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	Persona      string `json:"persona,omitempty"`
+}
+
+// chatSettingsKey returns the S3 object key of a chat's settings.
+func chatSettingsKey(chatID int64) string {
+	return fmt.Sprintf("chat_settings/%d.json", chatID)
+}
+
+// GetChatSettings returns a group chat's settings, loading them from S3 outside settingsMutex on first
+// use. Settings that fail to load yield the zero value without being cached, so the next call retries.
+func (a *App) GetChatSettings(chatID int64) ChatSettings {
+	a.settingsMutex.Lock()
+	settings, ok := a.chatSettings[chatID]
+	a.settingsMutex.Unlock()
+	if ok {
+		return settings
+	}
+
+	var loaded ChatSettings
+	if err := a.loadSettingsObject(chatSettingsKey(chatID), &loaded); err != nil {
+		log.Printf("Failed to load settings for chat %d: %v", chatID, err)
+		return ChatSettings{}
+	}
+
+	a.settingsMutex.Lock()
+	defer a.settingsMutex.Unlock()
+	// Keep settings saved while the read was in flight
+	if settings, ok := a.chatSettings[chatID]; ok {
+		return settings
+	}
+	a.chatSettings[chatID] = loaded
+	return loaded
+}
+
+// SaveChatSettings stores a group chat's settings in memory and in S3.
+func (a *App) SaveChatSettings(chatID int64, settings ChatSettings) error {
+	a.settingsMutex.Lock()
+	defer a.settingsMutex.Unlock()
+
+	if err := a.putSettingsObject(chatSettingsKey(chatID), settings); err != nil {
+		log.Printf("Failed to upload settings to S3 for chat %d: %v", chatID, err)
+		return err
+	}
+
+	a.chatSettings[chatID] = settings
+	return nil
+}
+
+// resolveSystemPrompt returns the system prompt for a user in a chat and a description of where it
+// came from. The user's own prompt or persona wins over the group default, which wins over the built-in default.
+func (a *App) resolveSystemPrompt(chatID int64, userID int) (string, string) {
+	user := a.GetUserSettings(userID)
+	if user.SystemPrompt != "" {
+		return user.SystemPrompt, "your custom prompt"
+	}
+	if prompt, ok := personas[user.Persona]; ok {
+		return prompt, fmt.Sprintf("your persona <b>%s</b>", user.Persona)
+	}
+
+	if chatID != int64(userID) {
+		chat := a.GetChatSettings(chatID)
+		if chat.SystemPrompt != "" {
+			return chat.SystemPrompt, "this group's custom prompt"
+		}
+		if prompt, ok := personas[chat.Persona]; ok {
+			return prompt, fmt.Sprintf("this group's persona <b>%s</b>", chat.Persona)
+		}
+	}
+	return defaultSystemPrompt, "the default prompt"
+}
+
+// systemPromptFor returns the system prompt of a conversation. Threads in groups keep the prompt of
+// the user who started them, whoever replies.
+func (a *App) systemPromptFor(chatID int64, conversationKey string) string {
+	owner, err := conversationOwner(conversationKey)
+	if err != nil {
+		return defaultSystemPrompt
+	}
+	prompt, _ := a.resolveSystemPrompt(chatID, owner)
+	return prompt
+}
+
+// withSystemPrompt sets the leading system message of a conversation, so prompt changes apply to
+// ongoing conversations from the next message on.
+func withSystemPrompt(messages []types.OpenAIMessage, prompt string) []types.OpenAIMessage {
+	if len(messages) > 0 && messages[0].Role == "system" {
+		messages[0].Content = prompt
+		return messages
+	}
+	return append([]types.OpenAIMessage{{Role: "system", Content: prompt}}, messages...)
+}
+
+// isChatAdmin reports whether a user is an administrator or the creator of a chat, using the getChatMember API.
+func (a *App) isChatAdmin(chatID int64, userID int) (bool, error) {
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/getChatMember?chat_id=%d&user_id=%d", a.TelegramToken, chatID, userID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := a.HTTPClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("unexpected status: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	var member struct {
+		Result struct {
+			Status string `json:"status"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&member); err != nil {
+		return false, err
+	}
+	return member.Result.Status == "administrator" || member.Result.Status == "creator", nil
+}
+
+// parsePromptArgs interprets the arguments of /system after any "group" scope: "reset", "persona <name>"
+// or a custom prompt. It returns the new prompt and persona, or an error for invalid input.
+func parsePromptArgs(args string) (prompt, persona string, err error) {
+	fields := strings.Fields(args)
+	switch {
+	case strings.EqualFold(args, "reset"):
+		return "", "", nil
+	case len(fields) > 0 && strings.EqualFold(fields[0], "persona"):
+		if len(fields) != 2 {
+			return "", "", fmt.Errorf("choose one persona: %s", strings.Join(personaNames(), ", "))
+		}
+		name := strings.ToLower(fields[1])
+		if _, ok := personas[name]; !ok {
+			return "", "", fmt.Errorf("unknown persona %q; choose one of: %s", name, strings.Join(personaNames(), ", "))
+		}
+		return "", name, nil
+	default:
+		if utf8.RuneCountInString(args) > maxSystemPromptLength {
+			return "", "", fmt.Errorf("system prompts are limited to %d characters", maxSystemPromptLength)
+		}
+		return args, "", nil
+	}
+}
+
+// personaNames returns the names of the built-in personas in alphabetical order.
+func personaNames() []string {
+	names := make([]string, 0, len(personas))
+	for name := range personas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handleSystemCommand processes /system:
+//
+//	/system                       show the system prompt in effect and where it comes from
+//	/system <prompt>              set your own system prompt
+//	/system persona <name>        use a built-in persona
+//	/system reset                 go back to the group default or the built-in default
+//	/system group <...>           set this group's default (chat admins only), with the same forms
+func (a *App) handleSystemCommand(args string, message *types.TelegramMessage, userID int) (string, error) {
+	chatID := message.Chat.ID
+	fields := strings.Fields(args)
+
+	var reply string
+	switch {
+	case args == "":
+		prompt, source := a.resolveSystemPrompt(chatID, userID)
+		reply = fmt.Sprintf("🧠 <b>System Prompt</b>\n\nUsing %s:\n<pre>%s</pre>\n\n%s", source, EscapeHTML(prompt), systemUsage())
+	case len(fields) > 0 && strings.EqualFold(fields[0], "group"):
+		reply = a.setGroupSystemPrompt(message, userID, strings.TrimSpace(args[len(fields[0]):]))
+	default:
+		prompt, persona, err := parsePromptArgs(args)
+		if err != nil {
+			reply = fmt.Sprintf("❌ <b>System Prompt Not Changed</b>\n\n%s\n\n%s", EscapeHTML(err.Error()), systemUsage())
+			break
+		}
+		err = a.UpdateUserSettings(userID, func(s *UserSettings) {
+			s.SystemPrompt = prompt
+			s.Persona = persona
+		})
+		if err != nil {
+			reply = "❌ <b>Error Saving System Prompt</b>\n\nPlease try again later."
+			break
+		}
+		_, source := a.resolveSystemPrompt(chatID, userID)
+		reply = fmt.Sprintf("🧠 <b>System Prompt Updated</b>\n\nNow using %s. It applies to your conversations from your next message.", source)
+	}
+
+	err := a.SendMessage(chatID, reply, message.MessageID)
+	return "", err
+}
+
+// setGroupSystemPrompt changes a group's default system prompt on behalf of a chat admin and returns the reply.
+func (a *App) setGroupSystemPrompt(message *types.TelegramMessage, userID int, args string) string {
+	chatID := message.Chat.ID
+	if message.Chat.Type == "private" {
+		return "❌ <b>Not a Group</b>\n\nGroup defaults can only be set in a group chat. Use /system &lt;prompt&gt; to set your own."
+	}
+	if args == "" {
+		chat := a.GetChatSettings(chatID)
+		switch {
+		case chat.SystemPrompt != "":
+			return fmt.Sprintf("🧠 <b>Group System Prompt</b>\n\n<pre>%s</pre>", EscapeHTML(chat.SystemPrompt))
+		case chat.Persona != "":
+			return fmt.Sprintf("🧠 <b>Group System Prompt</b>\n\nThis group uses the persona <b>%s</b>.", chat.Persona)
+		default:
+			return "🧠 <b>Group System Prompt</b>\n\nThis group uses the default prompt."
+		}
+	}
+
+	admin, err := a.isChatAdmin(chatID, userID)
+	if err != nil {
+		log.Printf("Failed to check admin status of user %d in chat %d: %v", userID, chatID, err)
+		return "❌ <b>Error Checking Permissions</b>\n\nPlease try again later."
+	}
+	if !admin {
+		return "⛔ <b>Admins Only</b>\n\nOnly chat admins can change this group's default system prompt."
+	}
+
+	prompt, persona, err := parsePromptArgs(args)
+	if err != nil {
+		return fmt.Sprintf("❌ <b>Group System Prompt Not Changed</b>\n\n%s\n\n%s", EscapeHTML(err.Error()), systemUsage())
+	}
+	if err := a.SaveChatSettings(chatID, ChatSettings{SystemPrompt: prompt, Persona: persona}); err != nil {
+		return "❌ <b>Error Saving Group System Prompt</b>\n\nPlease try again later."
+	}
+	return "🧠 <b>Group System Prompt Updated</b>\n\nMembers without their own system prompt or persona now use the new default from their next message."
+}
+
+// systemUsage describes the /system forms and the available personas.
+func systemUsage() string {
+	return "Usage:\n" +
+		"/system &lt;prompt&gt; - Set your own system prompt\n" +
+		"/system persona &lt;name&gt; - Use a built-in persona\n" +
+		"/system reset - Go back to the default\n" +
+		"/system group &lt;prompt | persona name | reset&gt; - Set this group's default (admins only)\n\n" +
+		"Personas: " + strings.Join(personaNames(), ", ")
+}
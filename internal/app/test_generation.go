@@ -0,0 +1,121 @@
+// internal/app/test_generation.go
+
+package app
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"KernelSandersBot/internal/markdown"
+	"KernelSandersBot/internal/testgen"
+	"KernelSandersBot/internal/types"
+)
+
+// Test generation limits.
+const (
+	testsMaxTokens   = 4000 // Room for a complete test file, unless the user asked for more
+	testsTemperature = 0.2
+)
+
+// handleTestsCommand processes /tests <file or function>: it generates table-driven tests for the selected
+// code of the user's active project, checks that they parse, and sends them as a test file with the full
+// result on the web response page.
+func (a *App) handleTestsCommand(args string, message *types.TelegramMessage, userID int, username string) (string, error) {
+	chatID := message.Chat.ID
+	tree, project, ok := a.activeSourceTree(userID)
+	if !ok {
+		errMsg := "❗ <b>No Source Code Found</b>\n\nUpload your project first; /tests works on your active project."
+		return "", a.SendMessage(chatID, errMsg, message.MessageID)
+	}
+	if args == "" {
+		return "", a.SendMessage(chatID, "Usage: /tests &lt;file or function&gt;, for example /tests internal/config/config.go or /tests Server.Start", message.MessageID)
+	}
+	target, err := testgen.Select(tree, args)
+	if err != nil {
+		errMsg := fmt.Sprintf("❗ <b>Nothing to Test</b>\n\n%s in project <b>%s</b>.", EscapeHTML(err.Error()), EscapeHTML(project))
+		return "", a.SendMessage(chatID, errMsg, message.MessageID)
+	}
+
+	opts, model := a.queryOptionsFor(userID)
+	if opts.MaxTokens < testsMaxTokens {
+		opts.MaxTokens = testsMaxTokens
+		if opts.MaxTokens > a.MaxCompletionTokens {
+			opts.MaxTokens = a.MaxCompletionTokens
+		}
+	}
+	if opts.Temperature == nil {
+		temperature := testsTemperature
+		opts.Temperature = &temperature
+	}
+	system, user := testgen.Messages(target)
+	prompt := []types.OpenAIMessage{
+		{Role: "system", Content: system},
+		{Role: "user", Content: user},
+	}
+
+	// Tests for unchanged code are answered from the cache without counting against the limit
+	startTime := time.Now()
+	reply, cached := a.cachedCompletion(userID, prompt, opts)
+	if !cached {
+		if err := a.enforceRateLimit(chatID, userID, username, "/tests "+args, message.MessageID, model); err != nil {
+			return "", err
+		}
+		opts = a.meterRequests(userID, model, opts)
+		a.SendMessage(chatID, fmt.Sprintf("🧪 <b>Writing tests</b> for <code>%s</code>…", EscapeHTML(target.Name)), message.MessageID)
+
+		if reply, err = a.APIHandler.QueryOpenAIWithOptions(prompt, opts); err != nil {
+			log.Printf("Test generation query failed: %v", err)
+			a.SendMessage(chatID, "❌ <b>Test Generation Failed</b>\n\nThe tests could not be generated. Please try again later.", message.MessageID)
+			return "", err
+		}
+	}
+	code := testgen.Extract(reply)
+	if err := testgen.Validate(target, code); err != nil {
+		// Ask once for a corrected file before giving up
+		log.Printf("Generated tests are invalid, retrying: %v", err)
+		messages := append(append([]types.OpenAIMessage(nil), prompt...),
+			types.OpenAIMessage{Role: "assistant", Content: reply},
+			types.OpenAIMessage{Role: "user", Content: fmt.Sprintf("The test file does not parse: %v. Reply again with the complete, corrected file in a single code block.", err)},
+		)
+		if reply, err = a.APIHandler.QueryOpenAIWithOptions(messages, opts); err == nil {
+			code = testgen.Extract(reply)
+			err = testgen.Validate(target, code)
+		}
+		if err != nil {
+			log.Printf("Generated tests invalid after retry: %v", err)
+			errMsg := fmt.Sprintf("❌ <b>Test Generation Failed</b>\n\nThe generated tests did not parse: <code>%s</code>\nPlease try again.", EscapeHTML(err.Error()))
+			a.SendMessage(chatID, errMsg, message.MessageID)
+			return "", err
+		}
+	}
+	// Only replies that passed validation are reused
+	a.cacheCompletion(userID, prompt, opts, reply)
+
+	fileName := target.TestFileName()
+	language := ""
+	if target.IsGo() {
+		language = "go"
+	}
+	document := fmt.Sprintf("# Tests for %s\n\nGenerated for `%s` of project %s, to be saved as `%s`.\n\n%s", target.Name, target.File, project, fileName, markdown.FenceCode(language, code))
+	responseID := a.ResponseStore.StoreResponseForUser(document, userID)
+
+	check := "⚠️ Not syntax-checked; only Go tests are verified."
+	if target.IsGo() {
+		check = "✅ Parses with go/parser."
+	}
+	caption := fmt.Sprintf("🧪 <b>Tests for</b> <code>%s</code> (%d lines)\n%s\n<a href=\"%s\">View on the web</a>",
+		EscapeHTML(target.Name), strings.Count(code, "\n"), check, a.GenerateResponseURL(responseID))
+	if cached {
+		caption += "\n♻️ <i>Cached result; use /nocache for fresh tests.</i>"
+	}
+	if err = a.SendDocument(chatID, fileName, []byte(code), caption, message.MessageID); err != nil {
+		// The caption still links to the tests on the web, so the fallback only fails if it cannot be sent either
+		log.Printf("Failed to send test file to Telegram: %v", err)
+		err = a.SendMessage(chatID, caption, message.MessageID)
+	}
+
+	a.logToS3(userID, username, "/tests "+args, fmt.Sprintf("%d ms", time.Since(startTime).Milliseconds()), a.isNoLimitUser(userID))
+	return "", err
+}
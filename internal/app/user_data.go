@@ -0,0 +1,211 @@
+// internal/app/user_data.go
+
+package app
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"KernelSandersBot/internal/utils"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// userDataStore is an S3 location holding data that belongs to a single user.
+type userDataStore struct {
+	Category string
+	Prefix   string
+}
+
+// userDataStores lists every S3 prefix holding per-user data. Any new per-user store must be
+// added here so that /delete_my_data covers it, and to ExportUserData.
+func userDataStores(userID int) []userDataStore {
+	return []userDataStore{
+		{Category: "Source code objects", Prefix: fmt.Sprintf("user_source_code/%d/", userID)},
+		{Category: "Settings files", Prefix: userSettingsKey(userID)},
+		{Category: "Stored conversations", Prefix: fmt.Sprintf("%s%d/", conversationPrefix, userID)},
+		{Category: "Stored reply links", Prefix: fmt.Sprintf("%s%d/", conversationLinkPrefix, userID)},
+		{Category: "Saved conversations", Prefix: fmt.Sprintf("%s%d/", savedConversationPrefix, userID)},
+	}
+}
+
+// DeletionItem is one line of a deletion receipt.
+type DeletionItem struct {
+	Category string
+	Detail   string
+}
+
+// DeletionReceipt itemizes what /delete_my_data removed and what could not be removed.
+type DeletionReceipt struct {
+	Items     []DeletionItem
+	Failures  []string
+	Completed time.Time
+}
+
+// addCount records the number of items removed from a category.
+func (r *DeletionReceipt) addCount(category string, count int) {
+	r.Items = append(r.Items, DeletionItem{Category: category, Detail: fmt.Sprintf("%d removed", count)})
+}
+
+// addFailure records a category that could not be fully deleted.
+func (r *DeletionReceipt) addFailure(category string, err error) {
+	log.Printf("Deletion of %s failed: %v", category, err)
+	r.Failures = append(r.Failures, category)
+}
+
+// Format renders the receipt as a Telegram HTML message.
+func (r *DeletionReceipt) Format() string {
+	var sb strings.Builder
+	if len(r.Failures) == 0 {
+		sb.WriteString("✅ <b>Data Deleted Successfully</b>\n\n")
+	} else {
+		sb.WriteString("⚠️ <b>Data Partially Deleted</b>\n\n")
+	}
+	sb.WriteString("<b>Deletion Receipt:</b>\n")
+	for _, item := range r.Items {
+		sb.WriteString(fmt.Sprintf("• %s: %s\n", EscapeHTML(item.Category), EscapeHTML(item.Detail)))
+	}
+	if len(r.Failures) > 0 {
+		sb.WriteString("\n<b>Not Deleted:</b>\n")
+		for _, category := range r.Failures {
+			sb.WriteString(fmt.Sprintf("• %s\n", EscapeHTML(category)))
+		}
+		sb.WriteString("\nYour encryption key was still destroyed where possible. Run /delete_my_data again to retry.\n")
+	}
+	sb.WriteString(fmt.Sprintf("\n<b>Completed:</b> %s", utils.FormatTimeUTC(r.Completed)))
+	return sb.String()
+}
+
+// DeleteUserData removes everything stored about a user: S3 objects under every per-user prefix,
+// web responses, the conversation context and reply links, cached completions and file summaries,
+// usage history, log rows and settings. The user's data
+// key is destroyed last so any copy that survives is unreadable. It returns an itemized receipt.
+func (a *App) DeleteUserData(userID int) (string, error) {
+	receipt := &DeletionReceipt{}
+
+	for _, store := range userDataStores(userID) {
+		count, err := a.deletePrefix(store.Prefix)
+		if err != nil {
+			receipt.addFailure(store.Category, err)
+			continue
+		}
+		receipt.addCount(store.Category, count)
+	}
+
+	a.settingsMutex.Lock()
+	delete(a.userSettings, userID)
+	a.settingsMutex.Unlock()
+
+	if count, err := a.ResponseStore.DeleteUserResponses(userID); err != nil {
+		receipt.addFailure("Web responses", err)
+	} else {
+		receipt.addCount("Web responses", count)
+	}
+
+	receipt.addCount("Reply thread links", a.ConversationContexts.UnlinkMatching(ownsConversation(userID)))
+	receipt.addCount("Conversation contexts", a.ConversationContexts.DeleteMatching(ownsConversation(userID)))
+	receipt.addCount("Cached completions", a.Cache.DeletePrefix(completionPrefix(userID)))
+	receipt.addCount("Cached file summaries", a.SummaryCache.DeletePrefix(summaryPrefix(userID)))
+
+	receipt.addCount("Rate-limit usage records", a.UsageCache.DeleteUser(userID))
+	receipt.addCount("Analysis usage records", a.AnalysisUsage.DeleteUser(userID))
+
+	if count, err := a.purgeUserLogs(userID); err != nil {
+		receipt.addFailure("Log rows", err)
+	} else {
+		receipt.addCount("Log rows", count)
+	}
+
+	if a.Keys.Enabled() {
+		if err := a.Keys.DestroyUserKey(userID); err != nil {
+			receipt.addFailure("Encryption key", err)
+		} else {
+			receipt.Items = append(receipt.Items, DeletionItem{Category: "Encryption key", Detail: "destroyed"})
+		}
+	}
+
+	receipt.Completed = time.Now()
+	log.Printf("Deleted data for user %d: %d categories, %d failures", userID, len(receipt.Items), len(receipt.Failures))
+	return receipt.Format(), nil
+}
+
+// deletePrefix deletes every object whose key starts with prefix and returns how many were deleted.
+func (a *App) deletePrefix(prefix string) (int, error) {
+	keys, err := a.listObjectKeys(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		if err := a.deleteObject(key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// readLogRecords reads all rows of the interaction log, including the header. A missing log yields no rows.
+// The caller must hold logMutex.
+func (a *App) readLogRecords() ([][]string, error) {
+	resp, err := a.S3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(a.S3BucketName),
+		Key:    aws.String(logObjectKey),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	bodyBytes, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(bytes.NewReader(bodyBytes))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parsing log CSV: %w", err)
+	}
+	return records, nil
+}
+
+// purgeUserLogs rewrites the interaction log without the user's rows and returns how many rows were removed.
+func (a *App) purgeUserLogs(userID int) (int, error) {
+	a.logMutex.Lock()
+	defer a.logMutex.Unlock()
+
+	records, err := a.readLogRecords()
+	if err != nil {
+		return 0, err
+	}
+
+	id := strconv.Itoa(userID)
+	kept := records[:0]
+	for _, record := range records {
+		if len(record) > 0 && record[0] == id {
+			continue
+		}
+		kept = append(kept, record)
+	}
+	removed := len(records) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+
+	if err := a.writeLogRecords(kept); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
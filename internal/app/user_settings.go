@@ -0,0 +1,136 @@
+// internal/app/user_settings.go
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// UserSettings holds per-user preferences persisted in S3.
+type UserSettings struct {
+	ActiveProject string   `json:"active_project,omitempty"`
+	SystemPrompt  string   `json:"system_prompt,omitempty"` // Custom system prompt set with /system
+	Persona       string   `json:"persona,omitempty"`       // Built-in persona set with /system persona
+	Model         string   `json:"model,omitempty"`         // Model chosen with /model
+	Temperature   *float64 `json:"temperature,omitempty"`   // Sampling temperature set with /settings
+	MaxTokens     int      `json:"max_tokens,omitempty"`    // Completion length limit set with /settings
+	NoCache       bool     `json:"no_cache,omitempty"`      // Completion cache turned off with /nocache
+}
+
+// userSettingsKey returns the S3 object key of a user's settings.
+func userSettingsKey(userID int) string {
+	return fmt.Sprintf("user_settings/%d.json", userID)
+}
+
+// GetUserSettings returns a user's settings, loading them from S3 on first use. Missing settings
+// yield the zero value; settings that fail to load yield it without being cached, so the next call retries.
+func (a *App) GetUserSettings(userID int) UserSettings {
+	settings, err := a.loadUserSettings(userID)
+	if err != nil {
+		log.Printf("Failed to load settings for user %d: %v", userID, err)
+	}
+	return settings
+}
+
+// SaveUserSettings stores a user's settings in memory and in S3.
+func (a *App) SaveUserSettings(userID int, settings UserSettings) error {
+	a.settingsMutex.Lock()
+	defer a.settingsMutex.Unlock()
+	return a.saveUserSettingsLocked(userID, settings)
+}
+
+// UpdateUserSettings applies a modification to a user's settings and persists the result. The settings
+// are loaded first, so a failed load never overwrites the stored ones; the modification and write then
+// happen under one lock so concurrent updates do not overwrite each other.
+func (a *App) UpdateUserSettings(userID int, update func(*UserSettings)) error {
+	if _, err := a.loadUserSettings(userID); err != nil {
+		log.Printf("Failed to load settings for user %d: %v", userID, err)
+		return err
+	}
+
+	a.settingsMutex.Lock()
+	defer a.settingsMutex.Unlock()
+
+	// Settings deleted since they were loaded start again from the zero value
+	settings := a.userSettings[userID]
+	update(&settings)
+	return a.saveUserSettingsLocked(userID, settings)
+}
+
+// loadUserSettings returns a user's settings, reading them from S3 outside settingsMutex on first use.
+// Settings are cached when the read succeeds or finds no object; other errors are returned uncached.
+func (a *App) loadUserSettings(userID int) (UserSettings, error) {
+	a.settingsMutex.Lock()
+	settings, ok := a.userSettings[userID]
+	a.settingsMutex.Unlock()
+	if ok {
+		return settings, nil
+	}
+
+	var loaded UserSettings
+	if err := a.loadSettingsObject(userSettingsKey(userID), &loaded); err != nil {
+		return UserSettings{}, err
+	}
+
+	a.settingsMutex.Lock()
+	defer a.settingsMutex.Unlock()
+	// Keep settings saved while the read was in flight
+	if settings, ok := a.userSettings[userID]; ok {
+		return settings, nil
+	}
+	a.userSettings[userID] = loaded
+	return loaded, nil
+}
+
+// saveUserSettingsLocked stores a user's settings in memory and in S3. The caller holds settingsMutex.
+func (a *App) saveUserSettingsLocked(userID int, settings UserSettings) error {
+	if err := a.putSettingsObject(userSettingsKey(userID), settings); err != nil {
+		log.Printf("Failed to upload settings to S3 for user %d: %v", userID, err)
+		return err
+	}
+
+	a.userSettings[userID] = settings
+	return nil
+}
+
+// loadSettingsObject reads a JSON settings object from S3 into v. A missing object leaves v unchanged.
+func (a *App) loadSettingsObject(objectKey string, v interface{}) error {
+	resp, err := a.S3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(a.S3BucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bodyBytes, v)
+}
+
+// putSettingsObject writes v to S3 as a JSON settings object.
+func (a *App) putSettingsObject(objectKey string, v interface{}) error {
+	settingsJSON, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = a.S3Client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(a.S3BucketName),
+		Key:    aws.String(objectKey),
+		Body:   bytes.NewReader(settingsJSON),
+	})
+	return err
+}
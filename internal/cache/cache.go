@@ -3,54 +3,362 @@
 package cache
 
 import (
+	"container/list"
+	"strings"
 	"sync"
 	"time"
 )
 
-// Cache represents a thread-safe in-memory cache.
+// Options bound a Cache. Zero values disable the corresponding limit.
+type Options struct {
+	TTL        time.Duration // Default time to live of an entry
+	MaxEntries int
+	MaxBytes   int
+	// SizeOf returns the size of a value counted against MaxBytes. By default strings and byte
+	// slices count their length and other values count nothing.
+	SizeOf func(value interface{}) int
+}
+
+// Stats are counters describing a cache's effectiveness.
+type Stats struct {
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64 // Entries removed to stay within MaxEntries or MaxBytes
+	Expirations uint64 // Entries removed because their TTL passed
+	Entries     int
+	Bytes       int
+}
+
+// Item is a key and value held by a Cache, with its expiry time.
+type Item struct {
+	Key       string
+	Value     interface{}
+	ExpiresAt time.Time // Zero for items that do not expire
+}
+
+// entry is a cached value with its size and expiry time.
+type entry struct {
+	key       string
+	value     interface{}
+	size      int
+	expiresAt time.Time // Zero for entries that do not expire
+}
+
+// expired reports whether the entry has expired at now.
+func (e *entry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// item returns the entry as an Item.
+func (e *entry) item() Item {
+	return Item{Key: e.key, Value: e.value, ExpiresAt: e.expiresAt}
+}
+
+// Cache represents a thread-safe in-memory cache with per-entry TTLs and least-recently-used eviction
+// by entry count and size.
 type Cache struct {
-	data  map[string]string
-	mutex sync.RWMutex
+	opts  Options
+	items map[string]*list.Element
+	lru   *list.List // Most recently used at the front
+	bytes int
+	stats Stats
+	mutex sync.Mutex
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	running  bool
 }
 
-// NewCache initializes and returns a new Cache instance.
+// NewCache initializes and returns a new unbounded Cache instance.
 func NewCache() *Cache {
+	return New(Options{})
+}
+
+// New returns a Cache bounded by opts.
+func New(opts Options) *Cache {
+	if opts.SizeOf == nil {
+		opts.SizeOf = defaultSizeOf
+	}
 	return &Cache{
-		data: make(map[string]string),
+		opts:  opts,
+		items: make(map[string]*list.Element),
+		lru:   list.New(),
+		stop:  make(chan struct{}),
+	}
+}
+
+// defaultSizeOf counts the length of strings and byte slices.
+func defaultSizeOf(value interface{}) int {
+	switch v := value.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	}
+	return 0
+}
+
+// Get retrieves the value associated with the given key and marks it as recently used.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if e.expired(time.Now()) {
+		c.remove(el)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	c.stats.Hits++
+	return e.value, true
+}
+
+// Set assigns a value to the given key with the cache's default TTL.
+func (c *Cache) Set(key string, value interface{}) {
+	c.SetWithTTL(key, value, c.opts.TTL)
+}
+
+// SetWithTTL assigns a value to the given key that expires after ttl, or never if ttl is zero. Least
+// recently used entries are evicted to stay within the cache's bounds; a value larger than MaxBytes
+// on its own is not stored.
+func (c *Cache) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+	c.SetUntil(key, value, expiresAt)
+}
+
+// SetUntil assigns a value to the given key that expires at expiresAt, or never if expiresAt is zero.
+// It is bounded like SetWithTTL.
+func (c *Cache) SetUntil(key string, value interface{}, expiresAt time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.set(key, value, expiresAt)
+}
+
+// set stores an entry and evicts entries to stay within the bounds. The caller holds the lock.
+func (c *Cache) set(key string, value interface{}, expiresAt time.Time) {
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	e := &entry{key: key, value: value, size: c.opts.SizeOf(value), expiresAt: expiresAt}
+	if c.opts.MaxBytes > 0 && e.size > c.opts.MaxBytes {
+		return
+	}
+	c.items[key] = c.lru.PushFront(e)
+	c.bytes += e.size
+
+	for c.overLimit() {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// overLimit reports whether the cache exceeds its bounds. The caller holds the lock.
+func (c *Cache) overLimit() bool {
+	return (c.opts.MaxEntries > 0 && c.lru.Len() > c.opts.MaxEntries) ||
+		(c.opts.MaxBytes > 0 && c.bytes > c.opts.MaxBytes)
+}
+
+// remove deletes an element. The caller holds the lock.
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+}
+
+// Add stores a value like SetUntil unless the key holds a value that has not expired, and reports
+// whether it stored the value.
+func (c *Cache) Add(key string, value interface{}, expiresAt time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if el, ok := c.items[key]; ok && !el.Value.(*entry).expired(time.Now()) {
+		return false
+	}
+	c.set(key, value, expiresAt)
+	return true
+}
+
+// Peek returns the item stored under key, even if it has expired, without marking it as recently used
+// or counting a hit or miss.
+func (c *Cache) Peek(key string) (Item, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return Item{}, false
 	}
+	return el.Value.(*entry).item(), true
 }
 
-// Get retrieves the value associated with the given key.
-func (c *Cache) Get(key string) (string, bool) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
-	val, exists := c.data[key]
-	return val, exists
+// Update atomically replaces the value of a key with the result of fn, which receives the current value
+// if it has not expired. The key is deleted if fn returns keep false; otherwise the new value expires
+// after ttl, or never if ttl is zero.
+func (c *Cache) Update(key string, ttl time.Duration, fn func(value interface{}, found bool) (newValue interface{}, keep bool)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	var current interface{}
+	el, found := c.items[key]
+	if found {
+		if e := el.Value.(*entry); e.expired(now) {
+			found = false
+		} else {
+			current = e.value
+		}
+	}
+	value, keep := fn(current, found)
+	if !keep {
+		if el, ok := c.items[key]; ok {
+			c.remove(el)
+		}
+		return
+	}
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = now.Add(ttl)
+	}
+	c.set(key, value, expiresAt)
 }
 
-// Set assigns a value to the given key in the cache.
-func (c *Cache) Set(key, value string) {
+// Range calls fn for each unexpired item until fn returns false. fn runs on a snapshot, outside the
+// cache's lock, so it may call other methods of the cache.
+func (c *Cache) Range(fn func(item Item) bool) {
+	now := time.Now()
+	c.mutex.Lock()
+	items := make([]Item, 0, c.lru.Len())
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		if e := el.Value.(*entry); !e.expired(now) {
+			items = append(items, e.item())
+		}
+	}
+	c.mutex.Unlock()
+
+	for _, item := range items {
+		if !fn(item) {
+			return
+		}
+	}
+}
+
+// Delete removes a key from the cache and reports whether it was present.
+func (c *Cache) Delete(key string) bool {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	c.data[key] = value
+	el, ok := c.items[key]
+	if ok {
+		c.remove(el)
+	}
+	return ok
 }
 
-// StartEviction periodically removes expired entries from the cache.
-// Currently, it deletes all entries at each interval.
-// Implement TTL checks or other eviction policies as needed.
+// DeletePrefix removes every key that starts with prefix and returns how many were removed.
+func (c *Cache) DeletePrefix(prefix string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	removed := 0
+	for key, el := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(el)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Len returns the number of entries, including expired entries not yet removed.
+func (c *Cache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+// Stats returns the cache's counters and current size.
+func (c *Cache) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.Bytes = c.bytes
+	return stats
+}
+
+// RemoveExpired deletes every expired entry and returns how many were removed.
+func (c *Cache) RemoveExpired() int {
+	return len(c.PopExpired())
+}
+
+// PopExpired deletes every expired entry and returns the removed items.
+func (c *Cache) PopExpired() []Item {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	var removed []Item
+	for el := c.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if e := el.Value.(*entry); e.expired(now) {
+			removed = append(removed, e.item())
+			c.remove(el)
+		}
+		el = prev
+	}
+	c.stats.Expirations += uint64(len(removed))
+	return removed
+}
+
+// PopIfExpired deletes the entry stored under key if it has expired and returns it. An entry set again
+// since it was read has not expired and is left in place, so the check and removal cannot race a Set.
+func (c *Cache) PopIfExpired(key string) (Item, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return Item{}, false
+	}
+	e := el.Value.(*entry)
+	if !e.expired(time.Now()) {
+		return Item{}, false
+	}
+	c.remove(el)
+	c.stats.Expirations++
+	return e.item(), true
+}
+
+// StartEviction removes expired entries every interval until Stop is called. Calling it again
+// while eviction is running has no effect.
 func (c *Cache) StartEviction(interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.running {
+		return
+	}
+	c.running = true
 
 	go func() {
-		for range ticker.C {
-			c.mutex.Lock()
-			for key := range c.data {
-				// TODO: Implement TTL checks or other eviction policies
-				// Example: delete all entries (replace with actual logic)
-				delete(c.data, key)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.RemoveExpired()
+			case <-c.stop:
+				return
 			}
-			c.mutex.Unlock()
 		}
 	}()
 }
+
+// Stop ends the eviction started by StartEviction. It is safe to call more than once.
+func (c *Cache) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
@@ -0,0 +1,189 @@
+// internal/cache/cache_test.go
+
+package cache
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keys returns the cache's unexpired keys, most recently used first.
+func keys(c *Cache) []string {
+	var got []string
+	c.Range(func(item Item) bool {
+		got = append(got, item.Key)
+		return true
+	})
+	return got
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	c := New(Options{MaxEntries: 3})
+	c.Set("a", "1")
+	c.Set("b", "2")
+	c.Set("c", "3")
+	c.Get("a") // "b" is now the least recently used
+	c.Set("d", "4")
+
+	if want := []string{"d", "a", "c"}; !reflect.DeepEqual(keys(c), want) {
+		t.Errorf("keys = %v, want %v", keys(c), want)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("Get(b) found an evicted entry")
+	}
+
+	// Peek does not mark an entry as used
+	c.Peek("c")
+	c.Set("e", "5")
+	if _, ok := c.Peek("c"); ok {
+		t.Errorf("Peek(c) found an entry that should have been evicted")
+	}
+}
+
+func TestByteBound(t *testing.T) {
+	c := New(Options{MaxBytes: 10})
+	c.Set("a", "aaaa")
+	c.Set("b", "bbbb")
+	if got := c.Stats().Bytes; got != 8 {
+		t.Errorf("Bytes = %d, want 8", got)
+	}
+
+	c.Set("c", "cccc")
+	if want := []string{"c", "b"}; !reflect.DeepEqual(keys(c), want) {
+		t.Errorf("keys = %v, want %v", keys(c), want)
+	}
+
+	// Replacing an entry counts only its new size
+	c.Set("b", "bb")
+	if got := c.Stats().Bytes; got != 6 {
+		t.Errorf("Bytes after replacing = %d, want 6", got)
+	}
+}
+
+func TestValueLargerThanMaxBytes(t *testing.T) {
+	c := New(Options{MaxBytes: 10})
+	c.Set("small", "abc")
+	c.Set("big", strings.Repeat("x", 11))
+
+	if _, ok := c.Get("big"); ok {
+		t.Errorf("Get(big) found a value larger than MaxBytes")
+	}
+	if _, ok := c.Get("small"); !ok {
+		t.Errorf("Get(small) = not found, want the entry kept")
+	}
+
+	// A too-large value replacing an entry removes the old value rather than keeping it stale
+	c.Set("small", strings.Repeat("y", 11))
+	if _, ok := c.Get("small"); ok {
+		t.Errorf("Get(small) found a value after it was replaced by one larger than MaxBytes")
+	}
+	if stats := c.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("Entries, Bytes = %d, %d, want 0, 0", stats.Entries, stats.Bytes)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	past := time.Now().Add(-time.Second)
+	future := time.Now().Add(time.Hour)
+
+	t.Run("get", func(t *testing.T) {
+		c := NewCache()
+		c.SetUntil("old", "v", past)
+		c.SetUntil("new", "v", future)
+		c.Set("forever", "v")
+
+		if _, ok := c.Get("old"); ok {
+			t.Errorf("Get(old) found an expired entry")
+		}
+		if _, ok := c.Get("new"); !ok {
+			t.Errorf("Get(new) = not found, want found")
+		}
+		if _, ok := c.Get("forever"); !ok {
+			t.Errorf("Get(forever) = not found, want found")
+		}
+		if got := c.Len(); got != 2 {
+			t.Errorf("Len = %d, want 2 after Get removed the expired entry", got)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		c := NewCache()
+		c.SetUntil("k", 1, past)
+		c.Update("k", time.Hour, func(value interface{}, found bool) (interface{}, bool) {
+			if found {
+				t.Errorf("Update passed an expired value %v", value)
+			}
+			return 2, true
+		})
+		item, ok := c.Peek("k")
+		if !ok || item.Value != 2 || !item.ExpiresAt.After(time.Now()) {
+			t.Errorf("Peek = %+v, %v, want value 2 expiring in the future", item, ok)
+		}
+
+		c.Update("k", time.Hour, func(value interface{}, found bool) (interface{}, bool) {
+			return nil, false
+		})
+		if _, ok := c.Peek("k"); ok {
+			t.Errorf("Peek found an entry Update asked to delete")
+		}
+	})
+
+	t.Run("add", func(t *testing.T) {
+		c := NewCache()
+		c.SetUntil("k", "old", past)
+		if !c.Add("k", "new", future) {
+			t.Errorf("Add over an expired entry = false, want true")
+		}
+		if c.Add("k", "newer", future) {
+			t.Errorf("Add over an unexpired entry = true, want false")
+		}
+		if got, _ := c.Get("k"); got != "new" {
+			t.Errorf("Get = %v, want new", got)
+		}
+	})
+
+	t.Run("pop", func(t *testing.T) {
+		c := NewCache()
+		c.SetUntil("a", "v", past)
+		c.SetUntil("b", "v", past)
+		c.SetUntil("c", "v", future)
+
+		if _, ok := c.PopIfExpired("c"); ok {
+			t.Errorf("PopIfExpired(c) removed an unexpired entry")
+		}
+		if item, ok := c.PopIfExpired("a"); !ok || item.Key != "a" {
+			t.Errorf("PopIfExpired(a) = %+v, %v, want the expired entry", item, ok)
+		}
+
+		var popped []string
+		for _, item := range c.PopExpired() {
+			popped = append(popped, item.Key)
+		}
+		sort.Strings(popped)
+		if want := []string{"b"}; !reflect.DeepEqual(popped, want) {
+			t.Errorf("PopExpired = %v, want %v", popped, want)
+		}
+		if want := []string{"c"}; !reflect.DeepEqual(keys(c), want) {
+			t.Errorf("keys = %v, want %v", keys(c), want)
+		}
+	})
+}
+
+func TestStats(t *testing.T) {
+	c := New(Options{MaxEntries: 2})
+	c.Set("a", "1")
+	c.Set("b", "22")
+	c.Get("a")
+	c.Get("missing")
+	c.Set("c", "333") // Evicts "b"
+	c.SetUntil("d", "4", time.Now().Add(-time.Second))
+	c.Get("d")
+
+	want := Stats{Hits: 1, Misses: 2, Evictions: 2, Expirations: 1, Entries: 1, Bytes: 3}
+	if got := c.Stats(); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+}
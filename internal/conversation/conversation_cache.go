@@ -3,80 +3,276 @@
 package conversation
 
 import (
+	"log"
 	"sync"
 	"time"
+
+	"KernelSandersBot/internal/expiring"
 )
 
+// Expiry is how long a context is kept after its last update.
+const Expiry = 30 * time.Minute
+
+// Persister stores conversation contexts and the bot messages linked to them outside of memory so
+// they survive restarts.
+type Persister interface {
+	Save(key, value string, lastSeen time.Time) error
+	Load(key string) (value string, lastSeen time.Time, found bool, err error)
+	Delete(key string) error
+	DeleteExpired(cutoff time.Time) (int, error)
+
+	SaveLink(link Link) error
+	LoadLinks() ([]Link, error)
+	DeleteLinks(key string) error
+}
+
+// Link records that replies to a bot message continue a conversation.
+type Link struct {
+	ChatID    int64
+	MessageID int
+	Key       string
+}
+
 // ConversationCache manages conversation contexts with expiration.
 type ConversationCache struct {
-	data      map[string]conversationEntry
-	mutex     sync.RWMutex
-	expiry    time.Duration
-	cleanupCh chan struct{}
+	store     *expiring.Store
+	persister *persisterAdapter
+	mutex     sync.RWMutex // Guards threads
+	threads   map[messageRef]string
 }
 
-// conversationEntry stores conversation data along with the last updated timestamp.
-type conversationEntry struct {
-	data     string
-	lastSeen time.Time
+// messageRef identifies a bot message whose replies continue a conversation.
+type messageRef struct {
+	chatID    int64
+	messageID int
 }
 
 // NewConversationCache initializes a new ConversationCache.
 func NewConversationCache() *ConversationCache {
 	cc := &ConversationCache{
-		data:      make(map[string]conversationEntry),
-		expiry:    30 * time.Minute, // Context expires after 30 minutes of inactivity
-		cleanupCh: make(chan struct{}),
+		persister: &persisterAdapter{expiry: Expiry},
+		threads:   make(map[messageRef]string),
 	}
-	go cc.cleanupExpiredContexts()
+	cc.store = expiring.New(expiring.Options{
+		TTL:             Expiry, // Context expires after 30 minutes of inactivity
+		CleanupInterval: Expiry,
+		Persister:       cc.persister,
+		OnEvict:         func(key string, _ interface{}) { cc.unlinkMessages(key) },
+	})
 	return cc
 }
 
-// Set stores a conversation context with the current timestamp.
+// SetPersister enables write-through persistence. Contexts missing from memory are loaded from the persister on first use.
+func (cc *ConversationCache) SetPersister(p Persister) {
+	cc.persister.set(p)
+}
+
+// SweepExpired deletes the persisted contexts last seen before the expiry window, such as those that
+// expired while the bot was stopped, and returns how many were deleted. Contexts that expire in memory
+// are deleted individually, so this only needs to run once at startup.
+func (cc *ConversationCache) SweepExpired() (int, error) {
+	p := cc.persister.get()
+	if p == nil {
+		return 0, nil
+	}
+	return p.DeleteExpired(time.Now().Add(-Expiry))
+}
+
+// Set stores a conversation context with the current timestamp and writes it through to the persister.
 func (cc *ConversationCache) Set(key, value string) {
-	cc.mutex.Lock()
-	defer cc.mutex.Unlock()
-	cc.data[key] = conversationEntry{
-		data:     value,
-		lastSeen: time.Now(),
+	if err := cc.store.Set(key, value); err != nil {
+		log.Printf("Failed to persist conversation %s: %v", key, err)
 	}
 }
 
-// Get retrieves a conversation context if it's not expired.
+// Get retrieves a conversation context if it's not expired, loading it from the persister if it is not in memory.
+// Expired contexts are deleted.
 func (cc *ConversationCache) Get(key string) (string, bool) {
+	value, ok := cc.store.Get(key)
+	if !ok {
+		return "", false
+	}
+	return value.(string), true
+}
+
+// Delete removes a conversation context from memory and the persister, reporting whether one was in memory.
+func (cc *ConversationCache) Delete(key string) bool {
+	exists, err := cc.store.Delete(key)
+	if err != nil {
+		log.Printf("Failed to delete persisted conversation %s: %v", key, err)
+	}
+	cc.unlinkMessages(key)
+	return exists
+}
+
+// DeleteMatching removes every conversation whose key matches from memory and the persister,
+// returning the number removed from memory.
+func (cc *ConversationCache) DeleteMatching(match func(key string) bool) int {
+	deleted := 0
+	for _, key := range cc.KeysMatching(match) {
+		if cc.Delete(key) {
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// KeysMatching returns the keys of in-memory conversations that match.
+func (cc *ConversationCache) KeysMatching(match func(key string) bool) []string {
+	var keys []string
+	cc.store.Range(func(key string, _ interface{}, _ time.Time) bool {
+		if match(key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+// LinkMessage records that a bot message belongs to a conversation, so replies to it continue that
+// conversation, and writes the link through to the persister.
+func (cc *ConversationCache) LinkMessage(chatID int64, messageID int, key string) {
+	cc.mutex.Lock()
+	cc.threads[messageRef{chatID: chatID, messageID: messageID}] = key
+	cc.mutex.Unlock()
+
+	if p := cc.persister.get(); p != nil {
+		if err := p.SaveLink(Link{ChatID: chatID, MessageID: messageID, Key: key}); err != nil {
+			log.Printf("Failed to persist reply link for conversation %s: %v", key, err)
+		}
+	}
+}
+
+// RestoreLinks loads the persisted links of conversations that have not expired, so replies to bot
+// messages sent before a restart continue their conversations. Links of expired conversations are
+// deleted. It returns the number of links restored.
+func (cc *ConversationCache) RestoreLinks() (int, error) {
+	p := cc.persister.get()
+	if p == nil {
+		return 0, nil
+	}
+	links, err := p.LoadLinks()
+	if err != nil {
+		return 0, err
+	}
+
+	alive := make(map[string]bool)
+	restored := 0
+	for _, link := range links {
+		ok, checked := alive[link.Key]
+		if !checked {
+			_, ok = cc.Get(link.Key)
+			alive[link.Key] = ok
+			if !ok {
+				if err := p.DeleteLinks(link.Key); err != nil {
+					log.Printf("Failed to delete reply links of expired conversation %s: %v", link.Key, err)
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		cc.mutex.Lock()
+		cc.threads[messageRef{chatID: link.ChatID, messageID: link.MessageID}] = link.Key
+		cc.mutex.Unlock()
+		restored++
+	}
+	return restored, nil
+}
+
+// ThreadKey returns the conversation a bot message belongs to, if that conversation has not expired.
+func (cc *ConversationCache) ThreadKey(chatID int64, messageID int) (string, bool) {
 	cc.mutex.RLock()
-	defer cc.mutex.RUnlock()
-	entry, exists := cc.data[key]
+	key, exists := cc.threads[messageRef{chatID: chatID, messageID: messageID}]
+	cc.mutex.RUnlock()
 	if !exists {
 		return "", false
 	}
-	if time.Since(entry.lastSeen) > cc.expiry {
+	if _, ok := cc.Get(key); !ok {
 		return "", false
 	}
-	return entry.data, true
+	return key, true
 }
 
-// cleanupExpiredContexts periodically removes expired contexts.
-func (cc *ConversationCache) cleanupExpiredContexts() {
-	ticker := time.NewTicker(cc.expiry)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			cc.mutex.Lock()
-			for key, entry := range cc.data {
-				if time.Since(entry.lastSeen) > cc.expiry {
-					delete(cc.data, key)
-				}
-			}
-			cc.mutex.Unlock()
-		case <-cc.cleanupCh:
-			return
+// UnlinkMatching forgets the bot messages of every conversation whose key matches and returns how many were forgotten.
+func (cc *ConversationCache) UnlinkMatching(match func(key string) bool) int {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	removed := 0
+	for ref, key := range cc.threads {
+		if match(key) {
+			delete(cc.threads, ref)
+			removed++
+		}
+	}
+	return removed
+}
+
+// unlinkMessages forgets the bot messages of a conversation that expired or was deleted, in memory and in the persister.
+func (cc *ConversationCache) unlinkMessages(key string) {
+	cc.mutex.Lock()
+	linked := false
+	for ref, k := range cc.threads {
+		if k == key {
+			delete(cc.threads, ref)
+			linked = true
+		}
+	}
+	cc.mutex.Unlock()
+
+	if p := cc.persister.get(); linked && p != nil {
+		if err := p.DeleteLinks(key); err != nil {
+			log.Printf("Failed to delete reply links of conversation %s: %v", key, err)
 		}
 	}
 }
 
 // Close stops the cleanup goroutine.
 func (cc *ConversationCache) Close() {
-	close(cc.cleanupCh)
+	cc.store.Close()
+}
+
+// persisterAdapter stores conversation entries through a Persister, which tracks when a conversation was
+// last seen rather than when it expires. Until a Persister is set, nothing is persisted.
+type persisterAdapter struct {
+	mutex     sync.RWMutex
+	persister Persister
+	expiry    time.Duration
+}
+
+// set replaces the underlying Persister.
+func (pa *persisterAdapter) set(p Persister) {
+	pa.mutex.Lock()
+	defer pa.mutex.Unlock()
+	pa.persister = p
+}
+
+// get returns the underlying Persister, or nil.
+func (pa *persisterAdapter) get() Persister {
+	pa.mutex.RLock()
+	defer pa.mutex.RUnlock()
+	return pa.persister
+}
+
+func (pa *persisterAdapter) Save(key string, value interface{}, expiresAt time.Time) error {
+	if p := pa.get(); p != nil {
+		return p.Save(key, value.(string), expiresAt.Add(-pa.expiry))
+	}
+	return nil
+}
+
+func (pa *persisterAdapter) Load(key string) (interface{}, time.Time, bool, error) {
+	p := pa.get()
+	if p == nil {
+		return nil, time.Time{}, false, nil
+	}
+	value, lastSeen, found, err := p.Load(key)
+	return value, lastSeen.Add(pa.expiry), found, err
+}
+
+func (pa *persisterAdapter) Delete(key string) error {
+	if p := pa.get(); p != nil {
+		return p.Delete(key)
+	}
+	return nil
 }
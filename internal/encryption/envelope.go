@@ -0,0 +1,268 @@
+// internal/encryption/envelope.go
+
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+
+	"KernelSandersBot/internal/s3client"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// envelopeMagic prefixes every encrypted payload so plaintext written before encryption was enabled can still be read.
+var envelopeMagic = []byte("KSE1")
+
+// headerSize is the magic followed by the owner's user ID as a big-endian int64.
+const headerSize = 4 + 8
+
+// dataKeySize is the length of per-user AES-256 data keys.
+const dataKeySize = 32
+
+var (
+	// ErrKeyDestroyed is returned when decrypting data whose owner's key has been deleted.
+	ErrKeyDestroyed = errors.New("user data key has been destroyed")
+	// ErrOwnerMismatch is returned when a payload was encrypted for a different user.
+	ErrOwnerMismatch = errors.New("encrypted payload belongs to a different user")
+	// ErrMalformedEnvelope is returned when an encrypted payload is truncated or corrupt.
+	ErrMalformedEnvelope = errors.New("malformed encrypted payload")
+)
+
+// KeyManager encrypts user data with per-user data keys. Each data key is wrapped with the
+// master key and stored in S3, so deleting it makes every copy of that user's data unreadable.
+type KeyManager struct {
+	master   cipher.AEAD // nil when no master key is configured
+	s3Client s3client.S3ClientInterface
+	bucket   string
+	mutex    sync.Mutex
+	keys     map[int]cipher.AEAD // Unwrapped data keys by user ID
+}
+
+// NewKeyManager creates a KeyManager from a base64-encoded 32-byte master key.
+// An empty master key disables encryption and data is stored as plaintext.
+func NewKeyManager(masterKeyBase64 string, s3Client s3client.S3ClientInterface, bucket string) (*KeyManager, error) {
+	km := &KeyManager{
+		s3Client: s3Client,
+		bucket:   bucket,
+		keys:     make(map[int]cipher.AEAD),
+	}
+	if masterKeyBase64 == "" {
+		return km, nil
+	}
+
+	masterKey, err := base64.StdEncoding.DecodeString(masterKeyBase64)
+	if err != nil {
+		return nil, fmt.Errorf("decoding master key: %w", err)
+	}
+	if len(masterKey) != dataKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", dataKeySize, len(masterKey))
+	}
+	km.master, err = newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return km, nil
+}
+
+// Enabled reports whether a master key is configured.
+func (km *KeyManager) Enabled() bool {
+	return km.master != nil
+}
+
+// userKeyObject returns the S3 object key holding a user's wrapped data key.
+func userKeyObject(userID int) string {
+	return fmt.Sprintf("user_keys/%d.key", userID)
+}
+
+// Encrypt seals plaintext with the user's data key, creating the key on first use.
+// Plaintext is returned unchanged when encryption is disabled.
+func (km *KeyManager) Encrypt(userID int, plaintext []byte) ([]byte, error) {
+	if !km.Enabled() {
+		return plaintext, nil
+	}
+	aead, err := km.dataKey(userID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize, headerSize+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	copy(header, envelopeMagic)
+	binary.BigEndian.PutUint64(header[4:], uint64(int64(userID)))
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(header, nonce...)
+	// The header is authenticated so a payload cannot be relabeled as another user's.
+	return aead.Seal(out, nonce, plaintext, header), nil
+}
+
+// Decrypt opens a payload produced by Encrypt for the given user.
+// Data without the envelope header is treated as legacy plaintext and returned unchanged.
+func (km *KeyManager) Decrypt(userID int, data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	if len(data) < headerSize {
+		return nil, ErrMalformedEnvelope
+	}
+	if owner := int(int64(binary.BigEndian.Uint64(data[4:headerSize]))); owner != userID {
+		return nil, ErrOwnerMismatch
+	}
+	if !km.Enabled() {
+		return nil, errors.New("encrypted data found but no master key is configured")
+	}
+
+	aead, err := km.dataKey(userID, false)
+	if err != nil {
+		return nil, err
+	}
+	body := data[headerSize:]
+	if len(body) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrMalformedEnvelope
+	}
+	plaintext, err := aead.Open(nil, body[:aead.NonceSize()], body[aead.NonceSize():], data[:headerSize])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedEnvelope, err)
+	}
+	return plaintext, nil
+}
+
+// IsEncrypted reports whether data carries the envelope header.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, envelopeMagic)
+}
+
+// DestroyUserKey deletes a user's data key, making everything encrypted under it unrecoverable.
+func (km *KeyManager) DestroyUserKey(userID int) error {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	delete(km.keys, userID)
+	_, err := km.s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(km.bucket),
+		Key:    aws.String(userKeyObject(userID)),
+	})
+	if err != nil {
+		log.Printf("Failed to destroy data key for user %d: %v", userID, err)
+		return err
+	}
+	return nil
+}
+
+// dataKey returns the user's data key, loading it from S3 or, if create is set, generating a new one.
+func (km *KeyManager) dataKey(userID int, create bool) (cipher.AEAD, error) {
+	km.mutex.Lock()
+	defer km.mutex.Unlock()
+
+	if aead, ok := km.keys[userID]; ok {
+		return aead, nil
+	}
+
+	wrapped, err := km.loadWrappedKey(userID)
+	if err != nil {
+		return nil, err
+	}
+	var rawKey []byte
+	switch {
+	case wrapped != nil:
+		if rawKey, err = km.unwrap(userID, wrapped); err != nil {
+			return nil, err
+		}
+	case !create:
+		return nil, ErrKeyDestroyed
+	default:
+		if rawKey, err = km.createKey(userID); err != nil {
+			return nil, err
+		}
+	}
+
+	aead, err := newGCM(rawKey)
+	if err != nil {
+		return nil, err
+	}
+	km.keys[userID] = aead
+	return aead, nil
+}
+
+// loadWrappedKey reads a user's wrapped data key from S3, returning nil if none exists.
+func (km *KeyManager) loadWrappedKey(userID int) ([]byte, error) {
+	resp, err := km.s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(km.bucket),
+		Key:    aws.String(userKeyObject(userID)),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("loading data key for user %d: %w", userID, err)
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// createKey generates a data key, wraps it with the master key and stores it in S3.
+func (km *KeyManager) createKey(userID int) ([]byte, error) {
+	rawKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(rawKey); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, km.master.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	wrapped := km.master.Seal(nonce, nonce, rawKey, keyAAD(userID))
+
+	_, err := km.s3Client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(km.bucket),
+		Key:    aws.String(userKeyObject(userID)),
+		Body:   bytes.NewReader(wrapped),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("storing data key for user %d: %w", userID, err)
+	}
+	log.Printf("Created data key for user %d", userID)
+	return rawKey, nil
+}
+
+// unwrap decrypts a wrapped data key with the master key.
+func (km *KeyManager) unwrap(userID int, wrapped []byte) ([]byte, error) {
+	nonceSize := km.master.NonceSize()
+	if len(wrapped) < nonceSize+km.master.Overhead() {
+		return nil, ErrMalformedEnvelope
+	}
+	rawKey, err := km.master.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], keyAAD(userID))
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key for user %d: %w", userID, err)
+	}
+	return rawKey, nil
+}
+
+// keyAAD binds a wrapped key to its owner so it cannot be swapped between users.
+func keyAAD(userID int) []byte {
+	return []byte("user:" + strconv.Itoa(userID))
+}
+
+// newGCM creates an AES-GCM AEAD from a 32-byte key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,220 @@
+// internal/encryption/envelope_test.go
+
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// memS3 is an in-memory S3 client holding object bodies by key.
+type memS3 struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemS3() *memS3 {
+	return &memS3{objects: make(map[string][]byte)}
+}
+
+func (m *memS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	body, ok := m.objects[aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(body))}, nil
+}
+
+func (m *memS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	body, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.objects[aws.StringValue(input.Key)] = body
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *memS3) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	return errors.New("not implemented")
+}
+
+func (m *memS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.objects, aws.StringValue(input.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *memS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	return nil, errors.New("not implemented")
+}
+
+// testMasterKey returns a base64-encoded master key filled with b.
+func testMasterKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, dataKeySize))
+}
+
+func newTestKeyManager(t *testing.T, master string, store *memS3) *KeyManager {
+	t.Helper()
+	km, err := NewKeyManager(master, store, "bucket")
+	if err != nil {
+		t.Fatalf("NewKeyManager: %v", err)
+	}
+	return km
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	store := newMemS3()
+	km := newTestKeyManager(t, testMasterKey(1), store)
+	plaintext := []byte("package main\n\nfunc main() {}\n")
+
+	sealed, err := km.Encrypt(42, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if !IsEncrypted(sealed) {
+		t.Errorf("IsEncrypted(sealed) = false, want true")
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Errorf("sealed payload contains the plaintext")
+	}
+	if _, ok := store.objects[userKeyObject(42)]; !ok {
+		t.Errorf("no wrapped data key stored for user 42")
+	}
+
+	// A fresh manager has to unwrap the stored data key
+	opened, err := newTestKeyManager(t, testMasterKey(1), store).Decrypt(42, sealed)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("Decrypt = %q, want %q", opened, plaintext)
+	}
+}
+
+func TestEncryptionDisabled(t *testing.T) {
+	km := newTestKeyManager(t, "", newMemS3())
+	plaintext := []byte("hello")
+	sealed, err := km.Encrypt(1, plaintext)
+	if err != nil || !bytes.Equal(sealed, plaintext) {
+		t.Errorf("Encrypt = %q, %v, want the plaintext unchanged", sealed, err)
+	}
+}
+
+func TestDecryptLegacyPlaintext(t *testing.T) {
+	km := newTestKeyManager(t, testMasterKey(1), newMemS3())
+	plaintext := []byte("written before encryption was enabled")
+	opened, err := km.Decrypt(1, plaintext)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("Decrypt = %q, %v, want the plaintext unchanged", opened, err)
+	}
+}
+
+func TestDecryptWithWrongMasterKey(t *testing.T) {
+	store := newMemS3()
+	sealed, err := newTestKeyManager(t, testMasterKey(1), store).Encrypt(7, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if _, err := newTestKeyManager(t, testMasterKey(2), store).Decrypt(7, sealed); err == nil {
+		t.Errorf("Decrypt with a different master key succeeded, want an error")
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID int
+		modify func(sealed []byte) []byte
+		want   error
+	}{
+		{
+			name:   "other user",
+			userID: 8,
+			modify: func(sealed []byte) []byte { return sealed },
+			want:   ErrOwnerMismatch,
+		},
+		{
+			name:   "flipped ciphertext byte",
+			userID: 7,
+			modify: func(sealed []byte) []byte {
+				sealed[len(sealed)-1] ^= 1
+				return sealed
+			},
+			want: ErrMalformedEnvelope,
+		},
+		{
+			name:   "relabeled owner",
+			userID: 8,
+			modify: func(sealed []byte) []byte {
+				binary.BigEndian.PutUint64(sealed[4:headerSize], 8)
+				return sealed
+			},
+			want: ErrMalformedEnvelope,
+		},
+		{
+			name:   "truncated",
+			userID: 7,
+			modify: func(sealed []byte) []byte { return sealed[:headerSize+2] },
+			want:   ErrMalformedEnvelope,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemS3()
+			km := newTestKeyManager(t, testMasterKey(1), store)
+			sealed, err := km.Encrypt(7, []byte("secret"))
+			if err != nil {
+				t.Fatalf("Encrypt: %v", err)
+			}
+			if _, err := km.Encrypt(8, []byte("other")); err != nil {
+				t.Fatalf("Encrypt: %v", err)
+			}
+			if _, err := km.Decrypt(tt.userID, tt.modify(sealed)); !errors.Is(err, tt.want) {
+				t.Errorf("Decrypt error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrappedKeyBoundToOwner(t *testing.T) {
+	store := newMemS3()
+	if _, err := newTestKeyManager(t, testMasterKey(1), store).Encrypt(7, []byte("secret")); err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	// A wrapped key copied to another user must not unwrap as that user's key
+	store.objects[userKeyObject(8)] = store.objects[userKeyObject(7)]
+	if _, err := newTestKeyManager(t, testMasterKey(1), store).dataKey(8, false); err == nil {
+		t.Errorf("dataKey(8) unwrapped a key wrapped for user 7, want an error")
+	}
+}
+
+func TestDestroyUserKey(t *testing.T) {
+	store := newMemS3()
+	km := newTestKeyManager(t, testMasterKey(1), store)
+	sealed, err := km.Encrypt(7, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if err := km.DestroyUserKey(7); err != nil {
+		t.Fatalf("DestroyUserKey: %v", err)
+	}
+	if _, err := km.Decrypt(7, sealed); !errors.Is(err, ErrKeyDestroyed) {
+		t.Errorf("Decrypt error = %v, want %v", err, ErrKeyDestroyed)
+	}
+}
@@ -0,0 +1,197 @@
+// internal/expiring/store.go
+
+package expiring
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"KernelSandersBot/internal/cache"
+)
+
+// Persister stores entries outside of memory so they survive restarts. A Store writes through to it,
+// loads entries missing from memory on first use and deletes entries once they expire. Entries that
+// expired while the process was stopped are never loaded again; the persister's owner sweeps them once
+// at startup.
+type Persister interface {
+	Save(key string, value interface{}, expiresAt time.Time) error
+	Load(key string) (value interface{}, expiresAt time.Time, found bool, err error)
+	Delete(key string) error
+}
+
+// Options configure a Store.
+type Options struct {
+	TTL             time.Duration // Lifetime of an entry from when it is last set
+	CleanupInterval time.Duration // How often expired entries are removed; zero disables the cleanup loop
+	Persister       Persister     // Optional write-through backend
+	// OnEvict is called, outside the store's lock, for each in-memory entry removed because it expired.
+	OnEvict func(key string, value interface{})
+}
+
+// Store is a thread-safe key-value store whose entries expire, with optional persistence and a cleanup
+// loop that runs until Close. Entries are held in memory by an unbounded cache.Cache.
+type Store struct {
+	opts  Options
+	items *cache.Cache
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// New creates a Store and starts its cleanup loop if opts.CleanupInterval is set.
+func New(opts Options) *Store {
+	s := &Store{
+		opts:  opts,
+		items: cache.NewCache(),
+		done:  make(chan struct{}),
+	}
+	if opts.CleanupInterval > 0 {
+		s.wg.Add(1)
+		go s.cleanupLoop()
+	}
+	return s
+}
+
+// Set stores a value that expires after the store's TTL and writes it through to the persister.
+func (s *Store) Set(key string, value interface{}) error {
+	return s.SetUntil(key, value, time.Now().Add(s.opts.TTL))
+}
+
+// SetUntil stores a value that expires at expiresAt and writes it through to the persister.
+func (s *Store) SetUntil(key string, value interface{}, expiresAt time.Time) error {
+	s.Restore(key, value, expiresAt)
+	if s.opts.Persister == nil {
+		return nil
+	}
+	return s.opts.Persister.Save(key, value, expiresAt)
+}
+
+// Restore stores a value in memory only, for entries read from the persister by the caller.
+func (s *Store) Restore(key string, value interface{}, expiresAt time.Time) {
+	s.items.SetUntil(key, value, expiresAt)
+}
+
+// Get returns a value that has not expired, loading it from the persister if it is not in memory.
+// Expired entries are deleted.
+func (s *Store) Get(key string) (interface{}, bool) {
+	value, _, ok := s.GetWithExpiry(key)
+	return value, ok
+}
+
+// GetWithExpiry is Get that also returns when the value expires.
+func (s *Store) GetWithExpiry(key string) (interface{}, time.Time, bool) {
+	if item, exists := s.items.Peek(key); exists {
+		if time.Now().Before(item.ExpiresAt) {
+			return item.Value, item.ExpiresAt, true
+		}
+		// Only the expired entry is removed; one set since the Peek is kept
+		if expired, ok := s.items.PopIfExpired(key); ok {
+			s.deletePersisted(key)
+			if s.opts.OnEvict != nil {
+				s.opts.OnEvict(key, expired.Value)
+			}
+		}
+		return nil, time.Time{}, false
+	}
+
+	if s.opts.Persister == nil {
+		return nil, time.Time{}, false
+	}
+	value, expiresAt, found, err := s.opts.Persister.Load(key)
+	if err != nil {
+		log.Printf("Failed to load %s: %v", key, err)
+		return nil, time.Time{}, false
+	}
+	if !found {
+		return nil, time.Time{}, false
+	}
+	if !time.Now().Before(expiresAt) {
+		s.deletePersisted(key)
+		return nil, time.Time{}, false
+	}
+	// A value set while loading is newer than the persisted one
+	s.items.Add(key, value, expiresAt)
+	return value, expiresAt, true
+}
+
+// Update atomically replaces the in-memory value of a key with the result of fn, which receives the
+// current value if it has not expired. The entry is deleted from memory if fn returns keep false;
+// otherwise it expires after the store's TTL. Update does not write through to the persister.
+func (s *Store) Update(key string, fn func(value interface{}, found bool) (newValue interface{}, keep bool)) {
+	s.items.Update(key, s.opts.TTL, fn)
+}
+
+// Delete removes a key from memory and the persister. It reports whether the key was in memory and
+// returns the persister's error, if any.
+func (s *Store) Delete(key string) (bool, error) {
+	exists := s.items.Delete(key)
+	if s.opts.Persister == nil {
+		return exists, nil
+	}
+	return exists, s.opts.Persister.Delete(key)
+}
+
+// Range calls fn for each in-memory entry that has not expired until fn returns false. fn runs on a
+// snapshot, outside the store's lock, so it may call other methods of the store.
+func (s *Store) Range(fn func(key string, value interface{}, expiresAt time.Time) bool) {
+	s.items.Range(func(item cache.Item) bool {
+		return fn(item.Key, item.Value, item.ExpiresAt)
+	})
+}
+
+// Len returns the number of entries in memory, including expired entries not yet removed.
+func (s *Store) Len() int {
+	return s.items.Len()
+}
+
+// RemoveExpired deletes expired entries from memory and the persister, calls OnEvict for each, and
+// returns the number removed from memory. Only the keys that expired in memory are deleted from the persister.
+func (s *Store) RemoveExpired() int {
+	expired := s.items.PopExpired()
+	for _, item := range expired {
+		s.deletePersisted(item.Key)
+		if s.opts.OnEvict != nil {
+			s.opts.OnEvict(item.Key, item.Value)
+		}
+	}
+	return len(expired)
+}
+
+// deletePersisted deletes an expired key from the persister. If the key was set again while it was
+// being deleted, the new value is saved again so the delete cannot remove it.
+func (s *Store) deletePersisted(key string) {
+	if s.opts.Persister == nil {
+		return
+	}
+	if err := s.opts.Persister.Delete(key); err != nil {
+		log.Printf("Failed to delete expired %s: %v", key, err)
+	}
+	if item, ok := s.items.Peek(key); ok && time.Now().Before(item.ExpiresAt) {
+		if err := s.opts.Persister.Save(key, item.Value, item.ExpiresAt); err != nil {
+			log.Printf("Failed to persist %s: %v", key, err)
+		}
+	}
+}
+
+// cleanupLoop removes expired entries every CleanupInterval until Close.
+func (s *Store) cleanupLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.opts.CleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.RemoveExpired()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Close stops the cleanup loop and waits for a running cleanup to finish. It is safe to call more than once.
+func (s *Store) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.wg.Wait()
+}
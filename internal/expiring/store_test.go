@@ -0,0 +1,195 @@
+// internal/expiring/store_test.go
+
+package expiring
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memPersister is an in-memory Persister recording the keys it deletes.
+type memPersister struct {
+	mutex   sync.Mutex
+	values  map[string]interface{}
+	expiry  map[string]time.Time
+	loads   int
+	deleted []string
+	// beforeDelete, if set, runs at the start of each Delete, outside the lock
+	beforeDelete func(key string)
+}
+
+func newMemPersister() *memPersister {
+	return &memPersister{values: make(map[string]interface{}), expiry: make(map[string]time.Time)}
+}
+
+func (p *memPersister) Save(key string, value interface{}, expiresAt time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.values[key] = value
+	p.expiry[key] = expiresAt
+	return nil
+}
+
+func (p *memPersister) Load(key string) (interface{}, time.Time, bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.loads++
+	value, ok := p.values[key]
+	return value, p.expiry[key], ok, nil
+}
+
+func (p *memPersister) Delete(key string) error {
+	if p.beforeDelete != nil {
+		p.beforeDelete(key)
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.values, key)
+	delete(p.expiry, key)
+	p.deleted = append(p.deleted, key)
+	return nil
+}
+
+func (p *memPersister) has(key string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	_, ok := p.values[key]
+	return ok
+}
+
+func TestLoadOnMiss(t *testing.T) {
+	persister := newMemPersister()
+	expiresAt := time.Now().Add(time.Hour).Round(0)
+	persister.Save("k", "persisted", expiresAt)
+	s := New(Options{TTL: time.Hour, Persister: persister})
+	defer s.Close()
+
+	value, gotExpiry, ok := s.GetWithExpiry("k")
+	if !ok || value != "persisted" || !gotExpiry.Equal(expiresAt) {
+		t.Fatalf("GetWithExpiry = %v, %v, %v, want persisted, %v, true", value, gotExpiry, ok, expiresAt)
+	}
+	if got := s.Len(); got != 1 {
+		t.Errorf("Len = %d, want the loaded entry kept in memory", got)
+	}
+	s.Get("k")
+	if persister.loads != 1 {
+		t.Errorf("loads = %d, want 1", persister.loads)
+	}
+
+	if _, ok := s.Get("missing"); ok {
+		t.Errorf("Get(missing) = found, want not found")
+	}
+}
+
+func TestLoadExpired(t *testing.T) {
+	persister := newMemPersister()
+	persister.Save("k", "stale", time.Now().Add(-time.Second))
+	var evicted []string
+	s := New(Options{TTL: time.Hour, Persister: persister, OnEvict: func(key string, _ interface{}) { evicted = append(evicted, key) }})
+	defer s.Close()
+
+	if _, ok := s.Get("k"); ok {
+		t.Errorf("Get = found, want an expired persisted entry ignored")
+	}
+	if persister.has("k") {
+		t.Errorf("expired persisted entry was not deleted")
+	}
+	if s.Len() != 0 || len(evicted) != 0 {
+		t.Errorf("Len, evicted = %d, %v, want an entry never in memory neither stored nor evicted", s.Len(), evicted)
+	}
+}
+
+func TestGetRemovesExpired(t *testing.T) {
+	persister := newMemPersister()
+	var evicted []string
+	s := New(Options{TTL: time.Hour, Persister: persister, OnEvict: func(key string, _ interface{}) { evicted = append(evicted, key) }})
+	defer s.Close()
+
+	s.SetUntil("k", "v", time.Now().Add(-time.Second))
+	if _, ok := s.Get("k"); ok {
+		t.Errorf("Get = found, want expired")
+	}
+	if persister.has("k") || s.Len() != 0 {
+		t.Errorf("expired entry left in memory or the persister")
+	}
+	if want := []string{"k"}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("evicted = %v, want %v", evicted, want)
+	}
+}
+
+func TestSetDuringExpiredDelete(t *testing.T) {
+	persister := newMemPersister()
+	s := New(Options{TTL: time.Hour, Persister: persister})
+	defer s.Close()
+
+	// A Set landing between removing the expired entry and deleting it from the persister survives
+	s.SetUntil("k", "old", time.Now().Add(-time.Second))
+	persister.beforeDelete = func(key string) {
+		persister.beforeDelete = nil
+		s.Set(key, "new")
+	}
+	if _, ok := s.Get("k"); ok {
+		t.Errorf("Get = found, want the expired entry removed")
+	}
+	if value, ok := s.Get("k"); !ok || value != "new" {
+		t.Errorf("Get = %v, %v, want new, true", value, ok)
+	}
+	if !persister.has("k") {
+		t.Errorf("persisted entry deleted after it was set again")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	s := New(Options{TTL: time.Hour})
+	defer s.Close()
+
+	add := func(value interface{}, found bool) (interface{}, bool) {
+		if !found {
+			return 1, true
+		}
+		return value.(int) + 1, true
+	}
+	s.Update("n", add)
+	s.Update("n", add)
+	if value, ok := s.Get("n"); !ok || value != 2 {
+		t.Errorf("Get = %v, %v, want 2, true", value, ok)
+	}
+
+	s.Update("n", func(interface{}, bool) (interface{}, bool) { return nil, false })
+	if _, ok := s.Get("n"); ok {
+		t.Errorf("Get = found after Update deleted the entry")
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	persister := newMemPersister()
+	var evicted []string
+	s := New(Options{TTL: time.Hour, Persister: persister, OnEvict: func(key string, _ interface{}) { evicted = append(evicted, key) }})
+	defer s.Close()
+
+	s.SetUntil("a", "v", time.Now().Add(-time.Second))
+	s.SetUntil("b", "v", time.Now().Add(-time.Second))
+	s.Set("c", "v")
+	// Persisted but never loaded: left for the owner's startup sweep
+	persister.Save("d", "v", time.Now().Add(-time.Second))
+
+	if got := s.RemoveExpired(); got != 2 {
+		t.Errorf("RemoveExpired = %d, want 2", got)
+	}
+	sort.Strings(persister.deleted)
+	sort.Strings(evicted)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(persister.deleted, want) || !reflect.DeepEqual(evicted, want) {
+		t.Errorf("deleted, evicted = %v, %v, want %v", persister.deleted, evicted, want)
+	}
+	if !persister.has("c") || !persister.has("d") {
+		t.Errorf("RemoveExpired deleted persisted entries that did not expire in memory")
+	}
+
+	persister.deleted = nil
+	if got := s.RemoveExpired(); got != 0 || len(persister.deleted) != 0 {
+		t.Errorf("RemoveExpired = %d, deleted %v, want nothing removed", got, persister.deleted)
+	}
+}
@@ -0,0 +1,66 @@
+// internal/gocheck/gocheck.go
+
+package gocheck
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+
+	"KernelSandersBot/internal/markdown"
+)
+
+// BlockError is a syntax error in one Go code block of an answer.
+type BlockError struct {
+	Block int // 1-based position among the answer's Go blocks
+	Err   error
+}
+
+func (e BlockError) Error() string {
+	return fmt.Sprintf("Go block %d: %v", e.Block, e.Err)
+}
+
+// Result is the outcome of checking the Go code blocks of an answer.
+type Result struct {
+	Blocks int
+	Errors []BlockError
+}
+
+// Verified reports whether the answer has Go blocks and all of them parse.
+func (r Result) Verified() bool {
+	return r.Blocks > 0 && len(r.Errors) == 0
+}
+
+// Summary lists the errors, one per line, for a request to fix them.
+func (r Result) Summary() string {
+	var lines []string
+	for _, e := range r.Errors {
+		lines = append(lines, "- "+e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isGo reports whether a code block is labeled as Go.
+func isGo(block markdown.CodeBlock) bool {
+	return block.Language == "go" || block.Language == "golang"
+}
+
+// Check parses every ```go block of an answer with go/parser and formats it with go/format. Blocks may
+// be whole files or fragments: declaration or statement lists. It returns the answer with the blocks
+// that parse replaced by their formatted code, and the result of the check.
+func Check(answer string) (string, Result) {
+	var result Result
+	checked := markdown.MapFencedCode(answer, func(block markdown.CodeBlock) string {
+		if !isGo(block) {
+			return block.Code
+		}
+		result.Blocks++
+		formatted, err := format.Source([]byte(block.Code))
+		if err != nil {
+			result.Errors = append(result.Errors, BlockError{Block: result.Blocks, Err: err})
+			return block.Code
+		}
+		return string(formatted)
+	})
+	return checked, result
+}
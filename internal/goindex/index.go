@@ -0,0 +1,418 @@
+// internal/goindex/index.go
+
+package goindex
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"KernelSandersBot/internal/sourcetree"
+)
+
+// Symbol kinds.
+const (
+	KindFunc      = "func"
+	KindMethod    = "method"
+	KindStruct    = "struct"
+	KindInterface = "interface"
+	KindType      = "type"
+	KindConst     = "const"
+	KindVar       = "var"
+)
+
+// Symbol is a top-level declaration of an uploaded Go file.
+type Symbol struct {
+	Name      string
+	Kind      string
+	Package   string // Package name
+	Dir       string // Directory of the package within the upload
+	Receiver  string // Receiver type of a method, without pointer
+	File      string
+	Line      int // First line of the declaration, including its doc comment
+	EndLine   int
+	Signature string // One-line summary such as "func (a *App) Run() error"
+}
+
+// QualifiedName returns Type.Method for methods and the plain name otherwise.
+func (s Symbol) QualifiedName() string {
+	if s.Receiver != "" {
+		return s.Receiver + "." + s.Name
+	}
+	return s.Name
+}
+
+// Package summarizes a Go package of the upload.
+type Package struct {
+	Name  string
+	Dir   string
+	Files []string
+	Kinds map[string]int // Number of symbols of each kind
+}
+
+// Index is a symbol index over the Go files of an uploaded source tree.
+type Index struct {
+	Packages []Package
+	Symbols  []Symbol
+	Errors   []string // Files with syntax errors
+
+	byName     map[string][]int
+	interfaces map[string][]string // Interface name -> method names
+	methodSets map[string][]string // Type name -> method names, value and pointer receivers combined
+}
+
+// Build parses the .go files of a tree and indexes their declarations. Files with syntax errors are
+// recorded in Errors; whatever part of them parses is still indexed.
+func Build(tree *sourcetree.Tree) *Index {
+	ix := &Index{
+		byName:     make(map[string][]int),
+		interfaces: make(map[string][]string),
+		methodSets: make(map[string][]string),
+	}
+	packages := make(map[string]*Package)
+	fset := token.NewFileSet()
+
+	for _, file := range tree.Files {
+		if !strings.HasSuffix(file.Path, ".go") {
+			continue
+		}
+		parsed, err := parser.ParseFile(fset, file.Path, file.Content, parser.ParseComments)
+		if err != nil && parsed == nil {
+			ix.Errors = append(ix.Errors, file.Path)
+			continue
+		}
+		if err != nil {
+			ix.Errors = append(ix.Errors, file.Path)
+		}
+
+		dir := path.Dir(file.Path)
+		pkgKey := dir + "|" + parsed.Name.Name
+		pkg, ok := packages[pkgKey]
+		if !ok {
+			pkg = &Package{Name: parsed.Name.Name, Dir: dir, Kinds: make(map[string]int)}
+			packages[pkgKey] = pkg
+		}
+		pkg.Files = append(pkg.Files, file.Path)
+
+		fi := fileIndexer{ix: ix, fset: fset, pkg: pkg, file: file.Path}
+		for _, decl := range parsed.Decls {
+			fi.decl(decl)
+		}
+	}
+
+	for _, pkg := range packages {
+		ix.Packages = append(ix.Packages, *pkg)
+	}
+	sort.Slice(ix.Packages, func(i, j int) bool { return ix.Packages[i].Dir < ix.Packages[j].Dir })
+	for i, sym := range ix.Symbols {
+		ix.byName[sym.Name] = append(ix.byName[sym.Name], i)
+		if sym.Receiver != "" {
+			ix.byName[sym.QualifiedName()] = append(ix.byName[sym.QualifiedName()], i)
+		}
+	}
+	return ix
+}
+
+// fileIndexer adds the declarations of one parsed file to an index.
+type fileIndexer struct {
+	ix   *Index
+	fset *token.FileSet
+	pkg  *Package
+	file string
+}
+
+// add records a symbol spanning from start (or its doc comment) to end.
+func (fi *fileIndexer) add(sym Symbol, doc *ast.CommentGroup, start, end token.Pos) {
+	if doc != nil {
+		start = doc.Pos()
+	}
+	sym.Package = fi.pkg.Name
+	sym.Dir = fi.pkg.Dir
+	sym.File = fi.file
+	sym.Line = fi.fset.Position(start).Line
+	sym.EndLine = fi.fset.Position(end).Line
+	fi.ix.Symbols = append(fi.ix.Symbols, sym)
+	fi.pkg.Kinds[sym.Kind]++
+}
+
+// decl indexes a top-level declaration.
+func (fi *fileIndexer) decl(decl ast.Decl) {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		sym := Symbol{Name: d.Name.Name, Kind: KindFunc, Signature: fi.funcSignature(d)}
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			sym.Kind = KindMethod
+			sym.Receiver = receiverName(d.Recv.List[0].Type)
+			fi.ix.methodSets[sym.Receiver] = append(fi.ix.methodSets[sym.Receiver], sym.Name)
+		}
+		fi.add(sym, d.Doc, d.Pos(), d.End())
+	case *ast.GenDecl:
+		for _, spec := range d.Specs {
+			// A lone spec spans the whole declaration so its keyword and doc comment are included;
+			// specs in a group only carry their own doc comment
+			start, end := spec.Pos(), spec.End()
+			var doc *ast.CommentGroup
+			if len(d.Specs) == 1 {
+				start, end, doc = d.Pos(), d.End(), d.Doc
+			}
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				if s.Doc != nil {
+					doc = s.Doc
+				}
+				sym := Symbol{Name: s.Name.Name, Kind: KindType, Signature: "type " + s.Name.Name + " " + fi.typeSummary(s.Type)}
+				switch t := s.Type.(type) {
+				case *ast.StructType:
+					sym.Kind = KindStruct
+				case *ast.InterfaceType:
+					sym.Kind = KindInterface
+					fi.ix.interfaces[s.Name.Name] = interfaceMethods(t)
+				}
+				fi.add(sym, doc, start, end)
+			case *ast.ValueSpec:
+				if s.Doc != nil {
+					doc = s.Doc
+				}
+				kind := KindVar
+				if d.Tok == token.CONST {
+					kind = KindConst
+				}
+				for _, name := range s.Names {
+					if name.Name == "_" {
+						continue
+					}
+					fi.add(Symbol{Name: name.Name, Kind: kind, Signature: kind + " " + name.Name}, doc, start, end)
+				}
+			}
+		}
+	}
+}
+
+// funcSignature renders a function declaration without its body.
+func (fi *fileIndexer) funcSignature(d *ast.FuncDecl) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fi.fset, &ast.FuncDecl{Recv: d.Recv, Name: d.Name, Type: d.Type})
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+// typeSummary renders a type expression, abbreviating struct and interface bodies.
+func (fi *fileIndexer) typeSummary(expr ast.Expr) string {
+	switch expr.(type) {
+	case *ast.StructType:
+		return "struct"
+	case *ast.InterfaceType:
+		return "interface"
+	}
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fi.fset, expr)
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+// receiverName returns the type name of a method receiver, without pointer or type parameters.
+func receiverName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(t.X)
+	case *ast.IndexExpr:
+		return receiverName(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
+
+// interfaceMethods returns the method names of an interface. Embedded interfaces are recorded by
+// name with a leading "*" and expanded later.
+func interfaceMethods(t *ast.InterfaceType) []string {
+	var methods []string
+	for _, field := range t.Methods.List {
+		if len(field.Names) == 0 {
+			if ident, ok := field.Type.(*ast.Ident); ok {
+				methods = append(methods, "*"+ident.Name)
+			}
+			continue
+		}
+		for _, name := range field.Names {
+			methods = append(methods, name.Name)
+		}
+	}
+	return methods
+}
+
+// qualifiedPattern matches "Name", "Type.Method" or "pkg.Name".
+var qualifiedPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Lookup returns the symbols matching a name: a plain identifier, Type.Method or package.Name.
+func (ix *Index) Lookup(name string) []Symbol {
+	name = strings.TrimSuffix(strings.TrimSpace(name), "()")
+	if !qualifiedPattern.MatchString(name) {
+		return nil
+	}
+	var found []Symbol
+	for _, i := range ix.byName[name] {
+		found = append(found, ix.Symbols[i])
+	}
+	if len(found) == 0 {
+		if dot := strings.Index(name, "."); dot >= 0 {
+			pkg, rest := name[:dot], name[dot+1:]
+			for _, i := range ix.byName[rest] {
+				if ix.Symbols[i].Package == pkg && ix.Symbols[i].Receiver == "" {
+					found = append(found, ix.Symbols[i])
+				}
+			}
+		}
+	}
+	return found
+}
+
+// SymbolsIn returns the symbols of the packages whose name or directory matches pkg.
+func (ix *Index) SymbolsIn(pkg string) []Symbol {
+	var found []Symbol
+	for _, sym := range ix.Symbols {
+		if sym.Package == pkg || sym.Dir == pkg {
+			found = append(found, sym)
+		}
+	}
+	return found
+}
+
+// methodSet returns the method names of an interface, expanding embedded interfaces of the index.
+func (ix *Index) methodSet(iface string, seen map[string]bool) []string {
+	if seen[iface] {
+		return nil
+	}
+	seen[iface] = true
+	var methods []string
+	for _, m := range ix.interfaces[iface] {
+		if strings.HasPrefix(m, "*") {
+			methods = append(methods, ix.methodSet(m[1:], seen)...)
+			continue
+		}
+		methods = append(methods, m)
+	}
+	return methods
+}
+
+// Implementers returns the types that declare every method of an interface. Matching is by method
+// name only, so it can report false positives where signatures differ.
+func (ix *Index) Implementers(iface string) []string {
+	required := ix.methodSet(iface, map[string]bool{})
+	if len(required) == 0 {
+		return nil
+	}
+	var types []string
+	for typeName, methods := range ix.methodSets {
+		if hasAll(methods, required) {
+			types = append(types, typeName)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Implements returns the non-empty interfaces of the index whose methods a type declares.
+func (ix *Index) Implements(typeName string) []string {
+	methods := ix.methodSets[typeName]
+	if len(methods) == 0 {
+		return nil
+	}
+	var ifaces []string
+	for iface := range ix.interfaces {
+		if required := ix.methodSet(iface, map[string]bool{}); len(required) > 0 && iface != typeName && hasAll(methods, required) {
+			ifaces = append(ifaces, iface)
+		}
+	}
+	sort.Strings(ifaces)
+	return ifaces
+}
+
+// hasAll reports whether have contains every name in want.
+func hasAll(have, want []string) bool {
+	set := make(map[string]bool, len(have))
+	for _, name := range have {
+		set[name] = true
+	}
+	for _, name := range want {
+		if !set[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// mentionPattern matches identifiers and Type.Method references in free text.
+var mentionPattern = regexp.MustCompile("`?[A-Za-z_][A-Za-z0-9_]*(\\.[A-Za-z_][A-Za-z0-9_]*)?(\\(\\))?`?")
+
+// Mentioned returns the symbols referred to in a question, most specific first, up to limit.
+// To avoid matching ordinary words, a plain lowercase word only counts when written as code:
+// in backticks or followed by "()". Identifiers with capitals or underscores and Type.Method
+// references always count.
+func (ix *Index) Mentioned(text string, limit int) []Symbol {
+	seen := make(map[int]bool)
+	var found []Symbol
+	for _, match := range mentionPattern.FindAllString(text, -1) {
+		asCode := strings.HasPrefix(match, "`") || strings.HasSuffix(match, "()") || strings.HasSuffix(match, "()`")
+		name := strings.TrimSuffix(strings.Trim(match, "`"), "()")
+		if len(name) < 3 {
+			continue
+		}
+		if !asCode && !strings.Contains(name, ".") && strings.ToLower(name) == name && !strings.Contains(name, "_") {
+			continue
+		}
+		for _, sym := range ix.Lookup(name) {
+			i := ix.position(sym)
+			if seen[i] {
+				continue
+			}
+			seen[i] = true
+			found = append(found, sym)
+			if len(found) >= limit {
+				return found
+			}
+		}
+	}
+	return found
+}
+
+// position returns the index of a symbol in ix.Symbols.
+func (ix *Index) position(sym Symbol) int {
+	for _, i := range ix.byName[sym.Name] {
+		s := ix.Symbols[i]
+		if s.File == sym.File && s.Line == sym.Line && s.Name == sym.Name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Source returns the lines of a symbol's declaration from the tree, capped at maxLines.
+func Source(tree *sourcetree.Tree, sym Symbol, maxLines int) string {
+	file, ok := tree.Get(sym.File)
+	if !ok {
+		return ""
+	}
+	lines := strings.Split(file.Content, "\n")
+	start, end := sym.Line, sym.EndLine
+	if start < 1 || start > len(lines) {
+		return ""
+	}
+	if end > len(lines) {
+		end = len(lines)
+	}
+	truncated := false
+	if end-start+1 > maxLines {
+		end = start + maxLines - 1
+		truncated = true
+	}
+	source := strings.Join(lines[start-1:end], "\n")
+	if truncated {
+		source += "\n// ... truncated"
+	}
+	return source
+}
@@ -0,0 +1,202 @@
+// internal/markdown/code_blocks.go
+
+package markdown
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// CodeBlock represents a fenced or indented code block found in Markdown text.
+type CodeBlock struct {
+	Language string
+	Code     string
+}
+
+// LineCount returns the number of lines in the code block.
+func (cb CodeBlock) LineCount() int {
+	if cb.Code == "" {
+		return 0
+	}
+	return strings.Count(strings.TrimRight(cb.Code, "\n"), "\n") + 1
+}
+
+// FileExtension returns the file extension matching the block's language, detecting it from the code if no language was given.
+func (cb CodeBlock) FileExtension() string {
+	language := cb.Language
+	if language == "" {
+		language = DetectLanguage(cb.Code)
+	}
+	if ext, ok := languageExtensions[language]; ok {
+		return ext
+	}
+	return "txt"
+}
+
+// languageExtensions maps common code fence languages to file extensions.
+var languageExtensions = map[string]string{
+	"go":         "go",
+	"golang":     "go",
+	"python":     "py",
+	"py":         "py",
+	"javascript": "js",
+	"js":         "js",
+	"typescript": "ts",
+	"ts":         "ts",
+	"tsx":        "tsx",
+	"jsx":        "jsx",
+	"java":       "java",
+	"kotlin":     "kt",
+	"c":          "c",
+	"cpp":        "cpp",
+	"c++":        "cpp",
+	"csharp":     "cs",
+	"cs":         "cs",
+	"rust":       "rs",
+	"rs":         "rs",
+	"ruby":       "rb",
+	"rb":         "rb",
+	"php":        "php",
+	"swift":      "swift",
+	"bash":       "sh",
+	"sh":         "sh",
+	"shell":      "sh",
+	"zsh":        "sh",
+	"powershell": "ps1",
+	"ps1":        "ps1",
+	"sql":        "sql",
+	"html":       "html",
+	"css":        "css",
+	"json":       "json",
+	"yaml":       "yaml",
+	"yml":        "yaml",
+	"toml":       "toml",
+	"xml":        "xml",
+	"dockerfile": "dockerfile",
+	"makefile":   "mk",
+	"diff":       "diff",
+	"patch":      "diff",
+	"markdown":   "md",
+	"md":         "md",
+}
+
+// DetectLanguage guesses the language of an unlabeled code block from a few telltale markers.
+// It returns an empty string when no guess can be made.
+func DetectLanguage(code string) string {
+	trimmed := strings.TrimSpace(code)
+	switch {
+	case strings.HasPrefix(trimmed, "package ") || strings.Contains(code, "func ") && strings.Contains(code, ":="):
+		return "go"
+	case strings.HasPrefix(trimmed, "#!/bin/bash") || strings.HasPrefix(trimmed, "#!/bin/sh"):
+		return "bash"
+	case strings.HasPrefix(trimmed, "diff --git") || strings.HasPrefix(trimmed, "--- "):
+		return "diff"
+	case strings.Contains(code, "def ") && strings.Contains(code, "):") || strings.HasPrefix(trimmed, "import ") && !strings.Contains(code, ";"):
+		return "python"
+	case strings.Contains(code, "function ") || strings.Contains(code, "const ") && strings.Contains(code, "=>"):
+		return "javascript"
+	case strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "["):
+		return "json"
+	case strings.HasPrefix(trimmed, "<"):
+		return "html"
+	}
+	return ""
+}
+
+// ExtractCodeBlocks returns every code block in the Markdown text in document order.
+func ExtractCodeBlocks(md string) []CodeBlock {
+	parser := blackfriday.New(blackfriday.WithExtensions(blackfriday.CommonExtensions))
+	root := parser.Parse([]byte(md))
+
+	var blocks []CodeBlock
+	root.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && node.Type == blackfriday.CodeBlock {
+			blocks = append(blocks, CodeBlock{
+				Language: CodeBlockLanguage(node),
+				Code:     string(node.Literal),
+			})
+		}
+		return blackfriday.GoToNext
+	})
+	return blocks
+}
+
+// fencePattern matches the opening line of a fenced code block: up to three spaces of indentation,
+// a run of backticks or tildes, and an optional info string.
+var fencePattern = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^` \t\r\n]*)")
+
+// FenceCode wraps code in a fenced code block whose fence is longer than any run of backticks in the
+// code, so the code cannot close the block early.
+func FenceCode(language, code string) string {
+	longest, run := 0, 0
+	for _, r := range code {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", 3)
+	if longest >= 3 {
+		fence = strings.Repeat("`", longest+1)
+	}
+	if !strings.HasSuffix(code, "\n") {
+		code += "\n"
+	}
+	return fence + language + "\n" + code + fence + "\n"
+}
+
+// MapFencedCode rewrites the fenced code blocks of Markdown text, leaving everything else untouched.
+// fn receives each block, with the fence's indentation removed from its lines, and returns its new code.
+// Unclosed fences are left as they are.
+func MapFencedCode(md string, fn func(CodeBlock) string) string {
+	lines := strings.SplitAfter(md, "\n")
+	var out strings.Builder
+	for i := 0; i < len(lines); i++ {
+		m := fencePattern.FindStringSubmatch(lines[i])
+		if m == nil {
+			out.WriteString(lines[i])
+			continue
+		}
+		indent, fence := m[1], m[2]
+		end := -1
+		for j := i + 1; j < len(lines); j++ {
+			closing := strings.TrimSpace(lines[j])
+			if strings.HasPrefix(closing, fence) && strings.Trim(closing, fence[:1]) == "" {
+				end = j
+				break
+			}
+		}
+		if end < 0 {
+			out.WriteString(lines[i])
+			continue
+		}
+
+		var code strings.Builder
+		for _, line := range lines[i+1 : end] {
+			code.WriteString(strings.TrimPrefix(line, indent))
+		}
+		newCode := fn(CodeBlock{Language: strings.ToLower(m[3]), Code: code.String()})
+		if newCode != "" && !strings.HasSuffix(newCode, "\n") {
+			newCode += "\n"
+		}
+
+		out.WriteString(lines[i])
+		for _, line := range strings.SplitAfter(newCode, "\n") {
+			if line != "" {
+				if line != "\n" {
+					line = indent + line
+				}
+				out.WriteString(line)
+			}
+		}
+		out.WriteString(lines[end])
+		i = end
+	}
+	return out.String()
+}
@@ -0,0 +1,39 @@
+// internal/markdown/code_blocks_test.go
+
+package markdown
+
+import "testing"
+
+func TestFenceCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		code     string
+		want     string
+	}{
+		{"plain code", "go", "x := 1\n", "```go\nx := 1\n```\n"},
+		{"adds final newline", "", "x := 1", "```\nx := 1\n```\n"},
+		{"short backtick runs", "go", "s := `raw`\n", "```go\ns := `raw`\n```\n"},
+		{"code with a fence", "go", "doc := \"```\"\n", "````go\ndoc := \"```\"\n````\n"},
+		{"longest run wins", "", "`````\n```\n", "``````\n`````\n```\n``````\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FenceCode(tt.language, tt.code)
+			if got != tt.want {
+				t.Errorf("FenceCode(%q, %q) = %q, want %q", tt.language, tt.code, got, tt.want)
+			}
+			blocks := 0
+			MapFencedCode(got, func(cb CodeBlock) string {
+				blocks++
+				if cb.Code != tt.code && cb.Code != tt.code+"\n" {
+					t.Errorf("fenced code = %q, want %q", cb.Code, tt.code)
+				}
+				return cb.Code
+			})
+			if blocks != 1 {
+				t.Errorf("FenceCode output has %d code blocks, want 1", blocks)
+			}
+		})
+	}
+}
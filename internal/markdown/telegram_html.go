@@ -0,0 +1,360 @@
+// internal/markdown/telegram_html.go
+
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// telegramEscaper escapes the characters Telegram requires to be escaped in HTML parse mode.
+var telegramEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// attributeEscaper additionally escapes quotes for use inside attribute values.
+var attributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;")
+
+// EscapeTelegramHTML escapes text so it is rendered literally by Telegram's HTML parse mode.
+func EscapeTelegramHTML(text string) string {
+	return telegramEscaper.Replace(text)
+}
+
+// RenderTelegramHTML converts Markdown into the subset of HTML supported by Telegram's HTML parse mode.
+// Constructs Telegram cannot display (headings, tables, rules, raw HTML) are mapped to the closest supported form.
+func RenderTelegramHTML(md string) string {
+	parser := blackfriday.New(blackfriday.WithExtensions(blackfriday.CommonExtensions))
+	root := parser.Parse([]byte(md))
+
+	r := &telegramRenderer{}
+	root.Walk(r.visit)
+	return strings.TrimSpace(r.buf.String())
+}
+
+// listState tracks the numbering of an open list.
+type listState struct {
+	ordered bool
+	index   int
+}
+
+// telegramRenderer walks a blackfriday AST and writes Telegram-compatible HTML.
+type telegramRenderer struct {
+	buf   bytes.Buffer
+	lists []listState
+}
+
+// visit renders a single node; it is used as a blackfriday.NodeVisitor.
+func (r *telegramRenderer) visit(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+	switch node.Type {
+	case blackfriday.Text:
+		r.buf.WriteString(EscapeTelegramHTML(string(node.Literal)))
+	case blackfriday.Softbreak, blackfriday.Hardbreak:
+		r.buf.WriteString("\n")
+	case blackfriday.Emph:
+		r.tag("i", entering)
+	case blackfriday.Strong:
+		r.tag("b", entering)
+	case blackfriday.Del:
+		r.tag("s", entering)
+	case blackfriday.Code:
+		r.buf.WriteString("<code>" + EscapeTelegramHTML(string(node.Literal)) + "</code>")
+	case blackfriday.HTMLSpan, blackfriday.HTMLBlock:
+		// Telegram rejects unknown tags, so raw HTML is shown as text.
+		r.buf.WriteString(EscapeTelegramHTML(string(node.Literal)))
+		if node.Type == blackfriday.HTMLBlock {
+			r.buf.WriteString("\n\n")
+		}
+	case blackfriday.Link:
+		r.link(string(node.LinkData.Destination), entering)
+	case blackfriday.Image:
+		// Images cannot be embedded in a message; link to them using the alt text.
+		r.link(string(node.LinkData.Destination), entering)
+	case blackfriday.Paragraph:
+		if !entering {
+			if r.inTightList(node) {
+				r.buf.WriteString("\n")
+			} else {
+				r.buf.WriteString("\n\n")
+			}
+		}
+	case blackfriday.Heading:
+		if entering {
+			r.buf.WriteString("<b>")
+		} else {
+			r.buf.WriteString("</b>\n\n")
+		}
+	case blackfriday.HorizontalRule:
+		r.buf.WriteString("——————\n\n")
+	case blackfriday.BlockQuote:
+		if entering {
+			r.buf.WriteString("<blockquote>")
+		} else {
+			r.trimTrailingNewlines()
+			r.buf.WriteString("</blockquote>\n\n")
+		}
+	case blackfriday.CodeBlock:
+		r.codeBlock(node)
+	case blackfriday.List:
+		r.list(node, entering)
+	case blackfriday.Item:
+		r.item(entering)
+	case blackfriday.Table:
+		r.buf.WriteString("<pre>" + EscapeTelegramHTML(renderTableText(node)) + "</pre>\n\n")
+		return blackfriday.SkipChildren
+	}
+	return blackfriday.GoToNext
+}
+
+// tag writes an opening or closing tag depending on the walk direction.
+func (r *telegramRenderer) tag(name string, entering bool) {
+	if entering {
+		r.buf.WriteString("<" + name + ">")
+	} else {
+		r.buf.WriteString("</" + name + ">")
+	}
+}
+
+// link writes an anchor for safe destinations; other destinations are rendered as plain text.
+func (r *telegramRenderer) link(destination string, entering bool) {
+	if !isSafeLink(destination) {
+		return
+	}
+	if entering {
+		r.buf.WriteString("<a href=\"" + attributeEscaper.Replace(destination) + "\">")
+	} else {
+		r.buf.WriteString("</a>")
+	}
+}
+
+// codeBlock writes a pre block, tagging it with the language when one was given.
+func (r *telegramRenderer) codeBlock(node *blackfriday.Node) {
+	code := EscapeTelegramHTML(strings.TrimRight(string(node.Literal), "\n"))
+	language := CodeBlockLanguage(node)
+	if language != "" {
+		r.buf.WriteString("<pre><code class=\"language-" + attributeEscaper.Replace(language) + "\">" + code + "</code></pre>\n\n")
+		return
+	}
+	r.buf.WriteString("<pre>" + code + "</pre>\n\n")
+}
+
+// list opens or closes a list, keeping track of the numbering for ordered lists.
+func (r *telegramRenderer) list(node *blackfriday.Node, entering bool) {
+	if entering {
+		if len(r.lists) > 0 {
+			// Nested lists start on their own line below the parent item text.
+			r.ensureNewline()
+		}
+		r.lists = append(r.lists, listState{ordered: node.ListFlags&blackfriday.ListTypeOrdered != 0})
+		return
+	}
+	r.lists = r.lists[:len(r.lists)-1]
+	if len(r.lists) == 0 {
+		r.trimTrailingNewlines()
+		r.buf.WriteString("\n\n")
+	}
+}
+
+// item writes the bullet or number for a list item.
+func (r *telegramRenderer) item(entering bool) {
+	if len(r.lists) == 0 {
+		return
+	}
+	if !entering {
+		r.ensureNewline()
+		return
+	}
+	current := &r.lists[len(r.lists)-1]
+	current.index++
+	r.buf.WriteString(strings.Repeat("    ", len(r.lists)-1))
+	if current.ordered {
+		r.buf.WriteString(fmt.Sprintf("%d. ", current.index))
+	} else {
+		r.buf.WriteString("• ")
+	}
+}
+
+// inTightList reports whether a paragraph belongs to a list item and should not be followed by a blank line.
+func (r *telegramRenderer) inTightList(node *blackfriday.Node) bool {
+	return node.Parent != nil && node.Parent.Type == blackfriday.Item
+}
+
+// ensureNewline terminates the current line if it is not already terminated.
+func (r *telegramRenderer) ensureNewline() {
+	b := r.buf.Bytes()
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		r.buf.WriteString("\n")
+	}
+}
+
+// trimTrailingNewlines removes newlines written at the end of the buffer so closing tags hug the content.
+func (r *telegramRenderer) trimTrailingNewlines() {
+	b := r.buf.Bytes()
+	n := len(b)
+	for n > 0 && b[n-1] == '\n' {
+		n--
+	}
+	r.buf.Truncate(n)
+}
+
+// CodeBlockLanguage returns the language from a fenced code block's info string, if any.
+func CodeBlockLanguage(node *blackfriday.Node) string {
+	fields := strings.Fields(string(node.CodeBlockData.Info))
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(fields[0])
+}
+
+// isSafeLink reports whether a link destination uses a scheme Telegram accepts in anchors.
+func isSafeLink(destination string) bool {
+	lower := strings.ToLower(destination)
+	for _, scheme := range []string{"http://", "https://", "tg://", "mailto:"} {
+		if strings.HasPrefix(lower, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// renderTableText flattens a table into aligned plain-text rows, since Telegram has no table markup.
+func renderTableText(table *blackfriday.Node) string {
+	var rows [][]string
+	table.Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering {
+			return blackfriday.GoToNext
+		}
+		switch node.Type {
+		case blackfriday.TableRow:
+			rows = append(rows, []string{})
+		case blackfriday.TableCell:
+			if len(rows) > 0 {
+				rows[len(rows)-1] = append(rows[len(rows)-1], plainText(node))
+			}
+			return blackfriday.SkipChildren
+		}
+		return blackfriday.GoToNext
+	})
+
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := utf8.RuneCountInString(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	var sb strings.Builder
+	for _, row := range rows {
+		for i, cell := range row {
+			if i > 0 {
+				sb.WriteString(" | ")
+			}
+			sb.WriteString(cell)
+			if i < len(row)-1 {
+				sb.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// plainText concatenates the literal text beneath a node.
+func plainText(node *blackfriday.Node) string {
+	var sb strings.Builder
+	node.Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && (n.Type == blackfriday.Text || n.Type == blackfriday.Code) {
+			sb.Write(n.Literal)
+		}
+		return blackfriday.GoToNext
+	})
+	return sb.String()
+}
+
+// TruncateHTML shortens Telegram HTML to at most limit bytes without splitting tags, entities or
+// UTF-8 sequences, appending an ellipsis and closing any tags left open by the cut.
+func TruncateHTML(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	const ellipsis = "..."
+
+	var (
+		out      strings.Builder
+		openTags []string
+	)
+	closingLength := func() int {
+		n := 0
+		for _, name := range openTags {
+			n += len("</>") + len(name)
+		}
+		return n
+	}
+
+	for i := 0; i < len(text); {
+		token, name, closing := nextHTMLToken(text[i:])
+		// Closing tags always fit because their length is already reserved; opening
+		// tags must leave room for their own closing tag.
+		cost := len(token)
+		if name != "" && closing {
+			cost = 0
+		} else if name != "" {
+			cost += len("</>") + len(name)
+		}
+		if out.Len()+closingLength()+cost+len(ellipsis) > limit {
+			break
+		}
+		out.WriteString(token)
+		i += len(token)
+
+		switch {
+		case name == "":
+		case closing:
+			for j := len(openTags) - 1; j >= 0; j-- {
+				if openTags[j] == name {
+					openTags = append(openTags[:j], openTags[j+1:]...)
+					break
+				}
+			}
+		default:
+			openTags = append(openTags, name)
+		}
+	}
+
+	out.WriteString(ellipsis)
+	for j := len(openTags) - 1; j >= 0; j-- {
+		out.WriteString("</" + openTags[j] + ">")
+	}
+	return out.String()
+}
+
+// nextHTMLToken returns the next indivisible piece of text: a whole tag, a whole entity or a single rune.
+// For tags it also returns the tag name and whether it is a closing tag.
+func nextHTMLToken(s string) (token, name string, closing bool) {
+	switch s[0] {
+	case '<':
+		end := strings.IndexByte(s, '>')
+		if end < 0 {
+			return s, "", false
+		}
+		token = s[:end+1]
+		inner := strings.TrimPrefix(token[1:end], "/")
+		closing = strings.HasPrefix(token, "</")
+		if fields := strings.Fields(inner); len(fields) > 0 {
+			name = strings.ToLower(fields[0])
+		}
+		return token, name, closing
+	case '&':
+		if end := strings.IndexByte(s, ';'); end > 0 && end < 10 {
+			return s[:end+1], "", false
+		}
+	}
+	_, size := utf8.DecodeRuneInString(s)
+	return s[:size], "", false
+}
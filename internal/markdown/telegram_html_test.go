@@ -0,0 +1,103 @@
+// internal/markdown/telegram_html_test.go
+
+package markdown
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRenderTelegramHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		md   string
+		want string
+	}{
+		{"emphasis", "**bold** and *italic* and ~~gone~~", "<b>bold</b> and <i>italic</i> and <s>gone</s>"},
+		{"escapes text", "a < b && c > d", "a &lt; b &amp;&amp; c &gt; d"},
+		{"inline code", "use `x<y>` here", "use <code>x&lt;y&gt;</code> here"},
+		{"fenced code with language", "```Go\nif a < b {\n}\n```", "<pre><code class=\"language-go\">if a &lt; b {\n}</code></pre>"},
+		{"fenced code without language", "```\nx := 1\n```", "<pre>x := 1</pre>"},
+		{"heading", "# Title\n\ntext", "<b>Title</b>\n\ntext"},
+		{"safe link", "[docs](https://example.com/?a=1&b=2)", "<a href=\"https://example.com/?a=1&amp;b=2\">docs</a>"},
+		{"unsafe link", "[click](javascript:void)", "click"},
+		{"raw html", "<div>hi</div>", "&lt;div&gt;hi&lt;/div&gt;"},
+		{"unordered list", "- one\n- two", "• one\n• two"},
+		{"ordered list", "1. one\n2. two", "1. one\n2. two"},
+		{"nested list", "- one\n  - inner\n- two", "• one\n    • inner\n• two"},
+		{"blockquote", "> quoted", "<blockquote>quoted</blockquote>"},
+		{"table", "| a | bb |\n|---|----|\n| ccc | d |", "<pre>a   | bb\nccc | d</pre>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenderTelegramHTML(tt.md); got != tt.want {
+				t.Errorf("RenderTelegramHTML(%q) = %q, want %q", tt.md, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateHTML(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  string
+	}{
+		{"fits", "<b>short</b>", 100, "<b>short</b>"},
+		{"plain text", "abcdefghij", 8, "abcde..."},
+		{"closes open tags", "<b>bold text here</b>", 16, "<b>bold t...</b>"},
+		{"mid pre block", "<pre><code class=\"language-go\">func main() {}</code></pre>", 50, "<pre><code class=\"language-go\">fun...</code></pre>"},
+		{"nested tags", "<b><i>abcdefgh</i></b>", 21, "<b><i>abcd...</i></b>"},
+		{"does not split entities", "a &amp; b", 8, "a ..."},
+		{"does not split runes", "héllo wörld", 5, "h..."},
+		{"drops tags that do not fit", "abc<b>def</b>", 9, "abc..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TruncateHTML(tt.text, tt.limit)
+			if got != tt.want {
+				t.Errorf("TruncateHTML(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+			if len(got) > tt.limit && len(tt.text) > tt.limit {
+				t.Errorf("TruncateHTML(%q, %d) returned %d bytes", tt.text, tt.limit, len(got))
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("TruncateHTML(%q, %d) returned invalid UTF-8 %q", tt.text, tt.limit, got)
+			}
+		})
+	}
+}
+
+func TestTruncateHTMLBalancesRenderedOutput(t *testing.T) {
+	html := RenderTelegramHTML("**Intro** with a [link](https://example.com)\n\n```go\n" +
+		strings.Repeat("fmt.Println(\"<a & b>\")\n", 40) + "```\n\n> quote")
+	for limit := 20; limit < len(html); limit += 7 {
+		got := TruncateHTML(html, limit)
+		if len(got) > limit {
+			t.Fatalf("limit %d: got %d bytes", limit, len(got))
+		}
+		var open []string
+		for i := 0; i < len(got); {
+			token, name, closing := nextHTMLToken(got[i:])
+			i += len(token)
+			switch {
+			case name == "":
+				if strings.HasPrefix(token, "&") && !strings.HasSuffix(token, ";") {
+					t.Fatalf("limit %d: split entity in %q", limit, got)
+				}
+			case closing:
+				if len(open) == 0 || open[len(open)-1] != name {
+					t.Fatalf("limit %d: unbalanced </%s> in %q", limit, name, got)
+				}
+				open = open[:len(open)-1]
+			default:
+				open = append(open, name)
+			}
+		}
+		if len(open) > 0 {
+			t.Fatalf("limit %d: unclosed %v in %q", limit, open, got)
+		}
+	}
+}
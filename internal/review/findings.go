@@ -0,0 +1,201 @@
+// internal/review/findings.go
+
+package review
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+
+	"KernelSandersBot/internal/sourcetree"
+)
+
+// Severities from most to least serious.
+var Severities = []string{"critical", "high", "medium", "low", "info"}
+
+// Finding is one issue reported by a code review.
+type Finding struct {
+	File         string `json:"file"`
+	Line         int    `json:"line"` // 0 for findings about a whole file
+	Severity     string `json:"severity"`
+	Category     string `json:"category"`
+	Message      string `json:"message"`
+	SuggestedFix string `json:"suggested_fix"`
+}
+
+// report is the JSON document the model must return.
+type report struct {
+	Findings []Finding `json:"findings"`
+}
+
+// Prompt is the system prompt of a review. It fixes the JSON schema the reply must follow.
+const Prompt = `You are a meticulous senior code reviewer. Review the code the user sends for bugs, security issues, ` +
+	`concurrency problems, error handling, performance and maintainability. Every line of code is prefixed with its ` +
+	`line number. Reply with a single JSON object and nothing else, in exactly this form:
+{"findings":[{"file":"path/as/given.go","line":42,"severity":"high","category":"bug","message":"What is wrong and why it matters.","suggested_fix":"A concrete change, code if helpful."}]}
+Rules: "file" must be one of the file paths given; "line" is the line number the finding refers to, or 0 for the whole file; ` +
+	`"severity" is one of critical, high, medium, low, info; "category" is a short lowercase word such as bug, security, ` +
+	`concurrency, error-handling, performance, style or maintainability. Report only real, specific issues, most serious first. ` +
+	`If there are none, reply {"findings":[]}.`
+
+// Format renders the files of a tree for review, each line prefixed with its number. Files are added
+// in order until maxBytes is reached; the paths of files left out are returned.
+func Format(tree *sourcetree.Tree, maxBytes int) (string, []string) {
+	var sb strings.Builder
+	var skipped []string
+	for _, file := range tree.Files {
+		var fb strings.Builder
+		fb.WriteString(fmt.Sprintf("File: %s\n", file.Path))
+		for i, line := range strings.Split(file.Content, "\n") {
+			fb.WriteString(fmt.Sprintf("%d| %s\n", i+1, line))
+		}
+		fb.WriteString("\n")
+		if sb.Len()+fb.Len() > maxBytes {
+			skipped = append(skipped, file.Path)
+			continue
+		}
+		sb.WriteString(fb.String())
+	}
+	return sb.String(), skipped
+}
+
+// Parse decodes and validates the model's reply against the reviewed tree. Malformed JSON is an error;
+// individual findings that do not fit the schema or point outside the reviewed files are dropped and
+// counted. Findings are returned most severe first.
+func Parse(reply string, tree *sourcetree.Tree) ([]Finding, int, error) {
+	var r report
+	if err := json.Unmarshal([]byte(extractJSON(reply)), &r); err != nil {
+		return nil, 0, fmt.Errorf("reply is not valid JSON: %v", err)
+	}
+	if r.Findings == nil {
+		return nil, 0, errors.New(`reply has no "findings" array`)
+	}
+
+	var valid []Finding
+	dropped := 0
+	for _, f := range r.Findings {
+		if err := validate(&f, tree); err != nil {
+			dropped++
+			continue
+		}
+		valid = append(valid, f)
+	}
+	Sort(valid)
+	return valid, dropped, nil
+}
+
+// extractJSON strips a Markdown code fence around a JSON reply, if present.
+func extractJSON(reply string) string {
+	reply = strings.TrimSpace(reply)
+	if strings.HasPrefix(reply, "```") {
+		reply = strings.TrimPrefix(reply, "```json")
+		reply = strings.TrimPrefix(reply, "```")
+		reply = strings.TrimSuffix(strings.TrimSpace(reply), "```")
+	}
+	return strings.TrimSpace(reply)
+}
+
+// validate normalizes a finding and checks it against the schema and the reviewed files.
+func validate(f *Finding, tree *sourcetree.Tree) error {
+	f.File = sourcetree.CleanPath(f.File)
+	f.Severity = strings.ToLower(strings.TrimSpace(f.Severity))
+	f.Category = strings.ToLower(strings.TrimSpace(f.Category))
+	f.Message = strings.TrimSpace(f.Message)
+	f.SuggestedFix = strings.TrimSpace(f.SuggestedFix)
+
+	file, ok := tree.Get(f.File)
+	if !ok {
+		return fmt.Errorf("unknown file %q", f.File)
+	}
+	if f.Line < 0 || f.Line > strings.Count(file.Content, "\n")+1 {
+		return fmt.Errorf("line %d is outside %s", f.Line, f.File)
+	}
+	if severityRank(f.Severity) < 0 {
+		return fmt.Errorf("unknown severity %q", f.Severity)
+	}
+	if f.Category == "" || f.Message == "" {
+		return errors.New("category and message are required")
+	}
+	return nil
+}
+
+// severityRank returns the position of a severity in Severities, or -1.
+func severityRank(severity string) int {
+	for i, s := range Severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// Sort orders findings by severity, then file and line.
+func Sort(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if ra, rb := severityRank(a.Severity), severityRank(b.Severity); ra != rb {
+			return ra < rb
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+}
+
+// Counts returns the number of findings of each severity.
+func Counts(findings []Finding) map[string]int {
+	counts := make(map[string]int)
+	for _, f := range findings {
+		counts[f.Severity]++
+	}
+	return counts
+}
+
+// Location returns "file:line", or just the file for file-level findings.
+func (f Finding) Location() string {
+	if f.Line == 0 {
+		return f.File
+	}
+	return fmt.Sprintf("%s:%d", f.File, f.Line)
+}
+
+// Markdown renders a review as a Markdown document whose findings table is sortable on the web response page.
+func Markdown(title string, findings []Finding, skipped []string) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# %s\n\n", title))
+
+	counts := Counts(findings)
+	var summary []string
+	for _, s := range Severities {
+		if counts[s] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[s], s))
+		}
+	}
+	if len(findings) == 0 {
+		sb.WriteString("No issues found.\n")
+	} else {
+		sb.WriteString(fmt.Sprintf("%d findings: %s. Click a column header to sort.\n\n", len(findings), strings.Join(summary, ", ")))
+		sb.WriteString("| Severity | Category | Location | Message | Suggested fix |\n")
+		sb.WriteString("|---|---|---|---|---|\n")
+		for _, f := range findings {
+			sb.WriteString(fmt.Sprintf("| %s | %s | %s | %s | %s |\n",
+				tableCell(f.Severity), tableCell(f.Category), tableCell(f.Location()), tableCell(f.Message), tableCell(f.SuggestedFix)))
+		}
+	}
+	if len(skipped) > 0 {
+		sb.WriteString(fmt.Sprintf("\n%d file(s) were not reviewed because of the size limit: %s\n", len(skipped), strings.Join(skipped, ", ")))
+	}
+	return sb.String()
+}
+
+// tableCell escapes text for a single Markdown table cell.
+func tableCell(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, "|", `\|`)
+	text = strings.ReplaceAll(text, "\r", "")
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
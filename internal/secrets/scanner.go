@@ -0,0 +1,200 @@
+// internal/secrets/scanner.go
+
+package secrets
+
+import (
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"KernelSandersBot/internal/sourcetree"
+)
+
+// Finding records a secret that was redacted from an uploaded file.
+type Finding struct {
+	File string
+	Line int
+	Kind string
+}
+
+// rule describes a class of secret. Group selects the submatch to redact; 0 redacts the whole match.
+// An optional accept function rejects matches that are unlikely to be real secrets.
+type rule struct {
+	kind    string
+	pattern *regexp.Regexp
+	group   int
+	accept  func(value string) bool
+}
+
+// rules lists the secret patterns checked in every upload, most specific first.
+var rules = []rule{
+	{kind: "private_key", pattern: regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----[\s\S]*?-----END (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`)},
+	{kind: "aws_access_key_id", pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA)[0-9A-Z]{16}\b`)},
+	{kind: "aws_secret_access_key", pattern: regexp.MustCompile(`(?i)(?:aws_?secret_?(?:access_?)?key|secret_?access_?key)["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`), group: 1},
+	{kind: "jwt", pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`)},
+	{kind: "openai_api_key", pattern: regexp.MustCompile(`\bsk-(?:proj-|ant-)?[A-Za-z0-9_-]{20,}`)},
+	{kind: "github_token", pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{kind: "slack_token", pattern: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{kind: "google_api_key", pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{kind: "stripe_key", pattern: regexp.MustCompile(`\b(?:sk|rk)_live_[0-9A-Za-z]{24,}\b`)},
+	{kind: "telegram_bot_token", pattern: regexp.MustCompile(`\b\d{8,10}:AA[A-Za-z0-9_-]{33}\b`)},
+	{kind: "connection_string_password", pattern: regexp.MustCompile(`\b[a-z][a-z0-9+.-]*://[^\s:/@'"]+:([^\s@/'"]{4,})@`), group: 1},
+	{kind: "credential_assignment", pattern: regexp.MustCompile(`(?i)\b[\w.-]*(?:api_?key|secret|token|passwd|password|pwd|credentials?|auth_?key)[\w.-]*["']?\s*(?::=|[:=])\s*["']([^"'\s]{8,})["']`), group: 1, accept: notIdentifier},
+}
+
+// identifierPattern matches lowercase names such as "user-password-field" that commonly follow "password =".
+var identifierPattern = regexp.MustCompile(`^[a-z_.:-]+$`)
+
+// highEntropyPattern finds quoted tokens long enough to be keys.
+var highEntropyPattern = regexp.MustCompile(`["'\x60]([A-Za-z0-9+/=_-]{32,})["'\x60]`)
+
+// lockFiles hold integrity hashes that look like secrets but are not.
+var lockFiles = map[string]struct{}{
+	"go.sum": {}, "package-lock.json": {}, "yarn.lock": {}, "pnpm-lock.yaml": {}, "cargo.lock": {},
+	"poetry.lock": {}, "composer.lock": {}, "gemfile.lock": {},
+}
+
+// placeholderMarkers identify example values that should not be treated as secrets.
+var placeholderMarkers = []string{"your", "example", "xxxx", "changeme", "placeholder", "dummy", "redacted", "<", "${", "{{", "getenv", "env[", "process.env"}
+
+// span is a byte range of content to be redacted.
+type span struct {
+	start, end int
+	kind       string
+}
+
+// RedactTree redacts secrets in every file of the tree in place and returns what was found.
+func RedactTree(tree *sourcetree.Tree) []Finding {
+	var findings []Finding
+	for i, file := range tree.Files {
+		redacted, fileFindings := RedactText(file.Path, file.Content)
+		tree.Files[i].Content = redacted
+		findings = append(findings, fileFindings...)
+	}
+	return findings
+}
+
+// RedactText replaces secrets in content with [REDACTED:kind] markers and reports their locations.
+func RedactText(fileName, content string) (string, []Finding) {
+	var spans []span
+	for _, r := range rules {
+		for _, m := range r.pattern.FindAllStringSubmatchIndex(content, -1) {
+			start, end := m[2*r.group], m[2*r.group+1]
+			if start < 0 || (r.group > 0 && isPlaceholder(content[start:end])) {
+				continue
+			}
+			if r.accept != nil && !r.accept(content[start:end]) {
+				continue
+			}
+			spans = append(spans, span{start: start, end: end, kind: r.kind})
+		}
+	}
+
+	if _, isLock := lockFiles[strings.ToLower(path.Base(fileName))]; !isLock {
+		for _, m := range highEntropyPattern.FindAllStringSubmatchIndex(content, -1) {
+			token := content[m[2]:m[3]]
+			if isHighEntropy(token) && !isPlaceholder(token) {
+				spans = append(spans, span{start: m[2], end: m[3], kind: "high_entropy_string"})
+			}
+		}
+	}
+
+	if len(spans) == 0 {
+		return content, nil
+	}
+
+	// Earlier rules win over later ones for overlapping matches, so sort stably by start only.
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var (
+		sb       strings.Builder
+		findings []Finding
+		cursor   int
+		line     = 1
+	)
+	for _, s := range spans {
+		if s.start < cursor {
+			// Overlaps a span that was already redacted; extend that redaction so no tail of this one leaks.
+			if s.end > cursor {
+				line += strings.Count(content[cursor:s.end], "\n")
+				cursor = s.end
+			}
+			continue
+		}
+		line += strings.Count(content[cursor:s.start], "\n")
+		sb.WriteString(content[cursor:s.start])
+		sb.WriteString("[REDACTED:" + s.kind + "]")
+		findings = append(findings, Finding{File: fileName, Line: line, Kind: s.kind})
+		line += strings.Count(content[s.start:s.end], "\n")
+		cursor = s.end
+	}
+	sb.WriteString(content[cursor:])
+	return sb.String(), findings
+}
+
+// isPlaceholder reports whether a value looks like documentation rather than a real secret.
+func isPlaceholder(value string) bool {
+	lower := strings.ToLower(value)
+	if strings.HasPrefix(lower, "[redacted:") {
+		return true
+	}
+	for _, marker := range placeholderMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// notIdentifier reports whether a value assigned to a credential-like name looks like a secret rather than a label.
+func notIdentifier(value string) bool {
+	return !identifierPattern.MatchString(value)
+}
+
+// isHighEntropy reports whether a token is random enough to be a key rather than an identifier or path.
+func isHighEntropy(token string) bool {
+	hasDigit, hasUpper, hasLower := false, false, false
+	for _, c := range token {
+		switch {
+		case c >= '0' && c <= '9':
+			hasDigit = true
+		case c >= 'A' && c <= 'Z':
+			hasUpper = true
+		case c >= 'a' && c <= 'z':
+			hasLower = true
+		}
+	}
+	if isHex(token) {
+		return len(token) >= 40 && shannonEntropy(token) > 3.0
+	}
+	// Identifiers and prose rarely mix all three character classes at high entropy.
+	return hasDigit && hasUpper && hasLower && shannonEntropy(token) > 4.2
+}
+
+// isHex reports whether the token consists only of hexadecimal digits.
+func isHex(token string) bool {
+	for _, c := range strings.ToLower(token) {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// shannonEntropy returns the per-character Shannon entropy of s in bits.
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	total := 0
+	for _, c := range s {
+		counts[c]++
+		total++
+	}
+	entropy := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
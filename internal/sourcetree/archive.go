@@ -0,0 +1,330 @@
+// internal/sourcetree/archive.go
+
+package sourcetree
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits bounds the work done when unpacking an uploaded archive.
+type Limits struct {
+	MaxUploadBytes      int64 // Maximum size of the uploaded file itself
+	MaxTotalBytes       int64 // Maximum number of bytes extracted across all entries
+	MaxFileBytes        int64 // Maximum size of a single extracted file
+	MaxEntries          int   // Maximum number of entries read from an archive
+	MaxCompressionRatio int64 // Maximum uncompressed/compressed ratio before an entry is treated as a zip bomb
+}
+
+// DefaultLimits are the unpacking limits used for Telegram uploads.
+var DefaultLimits = Limits{
+	MaxUploadBytes:      20 << 20, // Telegram's bot download limit
+	MaxTotalBytes:       8 << 20,
+	MaxFileBytes:        1 << 20,
+	MaxEntries:          5000,
+	MaxCompressionRatio: 100,
+}
+
+// ErrUnsupportedUpload is returned for files that are neither archives nor recognized source files.
+var ErrUnsupportedUpload = errors.New("unsupported upload type")
+
+// ErrLimitExceeded is returned when an upload exceeds one of the configured Limits.
+var ErrLimitExceeded = errors.New("upload exceeds size limits")
+
+// ImportReport describes what was kept and skipped while importing an upload.
+type ImportReport struct {
+	FilesImported      int
+	SkippedBinary      []string
+	SkippedVendored    int
+	SkippedUnsupported int
+	SkippedOversized   []string
+}
+
+// Skipped returns the total number of entries that were not imported.
+func (r *ImportReport) Skipped() int {
+	return len(r.SkippedBinary) + r.SkippedVendored + r.SkippedUnsupported + len(r.SkippedOversized)
+}
+
+// sourceExtensions lists the file extensions accepted as source code.
+var sourceExtensions = map[string]struct{}{
+	".txt": {}, ".md": {}, ".go": {}, ".mod": {}, ".sum": {}, ".py": {}, ".js": {}, ".jsx": {}, ".ts": {},
+	".tsx": {}, ".mjs": {}, ".cjs": {}, ".java": {}, ".kt": {}, ".kts": {}, ".scala": {}, ".c": {}, ".h": {},
+	".cc": {}, ".cpp": {}, ".hpp": {}, ".cs": {}, ".rs": {}, ".rb": {}, ".php": {}, ".swift": {}, ".m": {},
+	".sh": {}, ".bash": {}, ".zsh": {}, ".ps1": {}, ".psm1": {}, ".bat": {}, ".sql": {}, ".html": {},
+	".htm": {}, ".css": {}, ".scss": {}, ".less": {}, ".vue": {}, ".svelte": {}, ".json": {}, ".yaml": {},
+	".yml": {}, ".toml": {}, ".ini": {}, ".cfg": {}, ".conf": {}, ".xml": {}, ".gradle": {}, ".proto": {},
+	".graphql": {}, ".tf": {}, ".hcl": {}, ".lua": {}, ".r": {}, ".dart": {}, ".ex": {}, ".exs": {},
+	".erl": {}, ".hs": {}, ".clj": {}, ".elm": {}, ".csv": {}, ".log": {}, ".env.example": {},
+}
+
+// sourceFileNames lists extensionless file names accepted as source code.
+var sourceFileNames = map[string]struct{}{
+	"makefile": {}, "dockerfile": {}, "jenkinsfile": {}, "procfile": {}, "gemfile": {}, "rakefile": {},
+	".gitignore": {}, ".dockerignore": {}, ".gitattributes": {},
+}
+
+// vendoredDirectories lists directory names whose contents are dependencies or build output rather than user code.
+var vendoredDirectories = map[string]struct{}{
+	"vendor": {}, "node_modules": {}, ".git": {}, ".hg": {}, ".svn": {}, "dist": {}, "build": {},
+	"target": {}, "bin": {}, "obj": {}, "__pycache__": {}, ".venv": {}, "venv": {}, ".idea": {},
+	".vscode": {}, ".next": {}, ".gradle": {}, "third_party": {}, "bower_components": {}, "__MACOSX": {},
+}
+
+// IsArchive reports whether the file name denotes a supported archive format.
+func IsArchive(fileName string) bool {
+	lower := strings.ToLower(fileName)
+	return strings.HasSuffix(lower, ".zip") || strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+// IsSourceFile reports whether the file name looks like a source or text file.
+func IsSourceFile(fileName string) bool {
+	base := strings.ToLower(path.Base(CleanPath(fileName)))
+	if _, ok := sourceFileNames[base]; ok {
+		return true
+	}
+	for ext := range sourceExtensions {
+		if strings.HasSuffix(base, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSupportedUpload reports whether the file name can be imported as a source tree or applied to one as a patch.
+func IsSupportedUpload(fileName string) bool {
+	return IsArchive(fileName) || IsSourceFile(fileName) || IsPatch(fileName)
+}
+
+// FromUpload builds a source tree from an uploaded file. Archives are unpacked within limits,
+// .txt files are parsed in the copy_source_code script format and other source files become a single-file tree.
+// The directory shared by every file of an archive or .txt upload is stripped from the paths.
+func FromUpload(fileName string, data []byte, limits Limits) (*Tree, *ImportReport, error) {
+	if int64(len(data)) > limits.MaxUploadBytes {
+		return nil, nil, fmt.Errorf("%w: upload is %d bytes, limit is %d", ErrLimitExceeded, len(data), limits.MaxUploadBytes)
+	}
+
+	lower := strings.ToLower(fileName)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return fromZip(data, limits)
+	case strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz"):
+		return fromTarGz(data, limits)
+	case strings.HasSuffix(lower, ".txt"):
+		if !isText(data) {
+			return nil, nil, fmt.Errorf("%w: %s is not a text file", ErrUnsupportedUpload, fileName)
+		}
+		tree := Parse(string(data), fileName)
+		tree.trimCommonDirectory()
+		return tree, &ImportReport{FilesImported: len(tree.Files)}, nil
+	case IsSourceFile(fileName):
+		if !isText(data) {
+			return nil, nil, fmt.Errorf("%w: %s is not a text file", ErrUnsupportedUpload, fileName)
+		}
+		tree := &Tree{Files: []File{{Path: CleanPath(fileName), Content: string(data)}}}
+		return tree, &ImportReport{FilesImported: 1}, nil
+	}
+	return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedUpload, fileName)
+}
+
+// archiveImporter accumulates archive entries into a tree while enforcing limits.
+type archiveImporter struct {
+	limits     Limits
+	tree       *Tree
+	report     *ImportReport
+	entries    int
+	totalBytes int64
+}
+
+func newArchiveImporter(limits Limits) *archiveImporter {
+	return &archiveImporter{limits: limits, tree: &Tree{}, report: &ImportReport{}}
+}
+
+// accept decides whether an entry should be read, returning false for entries that are skipped.
+func (ai *archiveImporter) accept(name string, declaredSize int64) (bool, error) {
+	ai.entries++
+	if ai.entries > ai.limits.MaxEntries {
+		return false, fmt.Errorf("%w: archive has more than %d entries", ErrLimitExceeded, ai.limits.MaxEntries)
+	}
+
+	cleaned := CleanPath(name)
+	if isVendored(cleaned) {
+		ai.report.SkippedVendored++
+		return false, nil
+	}
+	if !IsSourceFile(cleaned) {
+		ai.report.SkippedUnsupported++
+		return false, nil
+	}
+	if declaredSize > ai.limits.MaxFileBytes {
+		ai.report.SkippedOversized = append(ai.report.SkippedOversized, cleaned)
+		return false, nil
+	}
+	return true, nil
+}
+
+// add reads an accepted entry, never reading more than the per-file and total limits allow.
+func (ai *archiveImporter) add(name string, r io.Reader) error {
+	cleaned := CleanPath(name)
+	data, err := io.ReadAll(io.LimitReader(r, ai.limits.MaxFileBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > ai.limits.MaxFileBytes {
+		// The declared size lied; treat the entry as oversized rather than trusting the header.
+		ai.report.SkippedOversized = append(ai.report.SkippedOversized, cleaned)
+		return nil
+	}
+
+	ai.totalBytes += int64(len(data))
+	if ai.totalBytes > ai.limits.MaxTotalBytes {
+		return fmt.Errorf("%w: extracted content exceeds %d bytes", ErrLimitExceeded, ai.limits.MaxTotalBytes)
+	}
+
+	if !isText(data) {
+		ai.report.SkippedBinary = append(ai.report.SkippedBinary, cleaned)
+		return nil
+	}
+	ai.tree.Set(cleaned, string(data))
+	ai.report.FilesImported++
+	return nil
+}
+
+// fromZip unpacks a zip archive into a source tree.
+func fromZip(data []byte, limits Limits) (*Tree, *ImportReport, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read zip archive: %w", err)
+	}
+
+	ai := newArchiveImporter(limits)
+	for _, entry := range zr.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		declared := int64(entry.UncompressedSize64)
+		ok, err := ai.accept(entry.Name, declared)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			continue
+		}
+		if entry.CompressedSize64 > 0 && declared/int64(entry.CompressedSize64) > limits.MaxCompressionRatio {
+			return nil, nil, fmt.Errorf("%w: %s has a suspicious compression ratio", ErrLimitExceeded, entry.Name)
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open %s: %w", entry.Name, err)
+		}
+		err = ai.add(entry.Name, rc)
+		rc.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ai.tree.Sort()
+	ai.tree.trimCommonDirectory()
+	return ai.tree, ai.report, nil
+}
+
+// fromTarGz unpacks a gzip-compressed tar archive into a source tree.
+func fromTarGz(data []byte, limits Limits) (*Tree, *ImportReport, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read gzip stream: %w", err)
+	}
+	defer gz.Close()
+
+	// Bound the whole decompressed stream, headers and skipped entries included, to defuse gzip bombs.
+	maxStream := int64(len(data)) * limits.MaxCompressionRatio
+	stream := &limitedReader{r: gz, remaining: maxStream}
+	tr := tar.NewReader(stream)
+
+	ai := newArchiveImporter(limits)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if stream.exceeded {
+				return nil, nil, fmt.Errorf("%w: archive decompresses beyond %d bytes", ErrLimitExceeded, maxStream)
+			}
+			return nil, nil, fmt.Errorf("failed to read tar archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		ok, err := ai.accept(header.Name, header.Size)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			continue
+		}
+		if err := ai.add(header.Name, tr); err != nil {
+			if stream.exceeded {
+				return nil, nil, fmt.Errorf("%w: archive decompresses beyond %d bytes", ErrLimitExceeded, maxStream)
+			}
+			return nil, nil, err
+		}
+	}
+
+	ai.tree.Sort()
+	ai.tree.trimCommonDirectory()
+	return ai.tree, ai.report, nil
+}
+
+// limitedReader fails once more than remaining bytes have been read and remembers that it did.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.remaining <= 0 {
+		lr.exceeded = true
+		return 0, ErrLimitExceeded
+	}
+	if int64(len(p)) > lr.remaining {
+		p = p[:lr.remaining]
+	}
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	return n, err
+}
+
+// isVendored reports whether any directory in the path is a dependency or build output directory.
+func isVendored(filePath string) bool {
+	parts := strings.Split(filePath, "/")
+	for _, dir := range parts[:len(parts)-1] {
+		if _, ok := vendoredDirectories[dir]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isText reports whether data looks like UTF-8 text rather than a binary file.
+func isText(data []byte) bool {
+	sample := data
+	if len(sample) > 8000 {
+		sample = sample[:8000]
+		// Drop a multi-byte rune cut off by the sample boundary.
+		for i := 1; i < utf8.UTFMax && len(sample) > 0 && !utf8.Valid(sample); i++ {
+			sample = sample[:len(sample)-1]
+		}
+	}
+	return bytes.IndexByte(sample, 0) < 0 && utf8.Valid(sample)
+}
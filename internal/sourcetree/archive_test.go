@@ -0,0 +1,317 @@
+// internal/sourcetree/archive_test.go
+
+package sourcetree
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// archiveEntry is a file placed in a test archive.
+type archiveEntry struct {
+	name    string
+	content string
+}
+
+func makeZip(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeTarGz(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// paths returns the paths of a tree's files.
+func paths(tree *Tree) []string {
+	var out []string
+	for _, f := range tree.Files {
+		out = append(out, f.Path)
+	}
+	return out
+}
+
+func TestFromUploadArchives(t *testing.T) {
+	entries := []archiveEntry{
+		{"project/main.go", "package main\n"},
+		{"project/pkg/util.go", "package pkg\n"},
+		{"project/README.md", "# readme\n"},
+		{"project/vendor/dep/dep.go", "package dep\n"},
+		{"project/node_modules/x/index.js", "module.exports = 1\n"},
+		{"project/logo.png", "\x89PNG"},
+		{"project/data.json", "{\"a\":\x00}"},
+		{"project/bad.txt", "\xff\xfe\xfd"},
+	}
+	archives := map[string][]byte{
+		"src.zip":    makeZip(t, entries),
+		"src.tar.gz": makeTarGz(t, entries),
+		"src.tgz":    makeTarGz(t, entries),
+	}
+	for name, data := range archives {
+		t.Run(name, func(t *testing.T) {
+			tree, report, err := FromUpload(name, data, DefaultLimits)
+			if err != nil {
+				t.Fatalf("FromUpload: %v", err)
+			}
+			wantPaths := []string{"README.md", "main.go", "pkg/util.go"}
+			if got := paths(tree); !reflect.DeepEqual(got, wantPaths) {
+				t.Errorf("paths = %v, want %v", got, wantPaths)
+			}
+			if report.FilesImported != 3 {
+				t.Errorf("FilesImported = %d, want 3", report.FilesImported)
+			}
+			if report.SkippedVendored != 2 {
+				t.Errorf("SkippedVendored = %d, want 2", report.SkippedVendored)
+			}
+			if report.SkippedUnsupported != 1 {
+				t.Errorf("SkippedUnsupported = %d, want 1", report.SkippedUnsupported)
+			}
+			wantBinary := []string{"project/data.json", "project/bad.txt"}
+			if !reflect.DeepEqual(report.SkippedBinary, wantBinary) {
+				t.Errorf("SkippedBinary = %v, want %v", report.SkippedBinary, wantBinary)
+			}
+			if report.Skipped() != 5 {
+				t.Errorf("Skipped() = %d, want 5", report.Skipped())
+			}
+		})
+	}
+}
+
+func TestFromUploadLimits(t *testing.T) {
+	small := Limits{MaxUploadBytes: 1 << 20, MaxTotalBytes: 25, MaxFileBytes: 15, MaxEntries: 3, MaxCompressionRatio: 100}
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		limits  Limits
+		wantErr bool
+		// wantOversized lists the files skipped for their size when no error is expected.
+		wantOversized []string
+	}{
+		{
+			name:    "within limits",
+			entries: []archiveEntry{{"a.go", "package a\n"}, {"b.go", "package b\n"}},
+			limits:  small,
+		},
+		{
+			name:    "too many entries",
+			entries: []archiveEntry{{"a.go", "1"}, {"b.go", "2"}, {"c.go", "3"}, {"d.go", "4"}},
+			limits:  small,
+			wantErr: true,
+		},
+		{
+			name:    "skipped entries count toward the entry limit",
+			entries: []archiveEntry{{"a.bin", "1"}, {"vendor/b.go", "2"}, {"c.go", "3"}, {"d.go", "4"}},
+			limits:  small,
+			wantErr: true,
+		},
+		{
+			name:          "oversized file is skipped",
+			entries:       []archiveEntry{{"big.go", strings.Repeat("x", 16)}, {"ok.go", "ok"}},
+			limits:        small,
+			wantOversized: []string{"big.go"},
+		},
+		{
+			name:    "total size exceeded",
+			entries: []archiveEntry{{"a.go", strings.Repeat("a", 15)}, {"b.go", strings.Repeat("b", 15)}},
+			limits:  small,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		for _, format := range []string{"zip", "tar.gz"} {
+			t.Run(tt.name+"/"+format, func(t *testing.T) {
+				var data []byte
+				if format == "zip" {
+					data = makeZip(t, tt.entries)
+				} else {
+					data = makeTarGz(t, tt.entries)
+				}
+				_, report, err := FromUpload("upload."+format, data, tt.limits)
+				if tt.wantErr {
+					if !errors.Is(err, ErrLimitExceeded) {
+						t.Fatalf("err = %v, want ErrLimitExceeded", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("FromUpload: %v", err)
+				}
+				if !reflect.DeepEqual(report.SkippedOversized, tt.wantOversized) {
+					t.Errorf("SkippedOversized = %v, want %v", report.SkippedOversized, tt.wantOversized)
+				}
+			})
+		}
+	}
+}
+
+func TestFromUploadRejectsBombs(t *testing.T) {
+	// Highly repetitive content compresses far beyond the allowed ratio.
+	bomb := strings.Repeat("a", 512<<10)
+
+	t.Run("zip entry ratio", func(t *testing.T) {
+		data := makeZip(t, []archiveEntry{{"bomb.go", bomb}})
+		if _, _, err := FromUpload("bomb.zip", data, DefaultLimits); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("err = %v, want ErrLimitExceeded", err)
+		}
+	})
+
+	t.Run("tar.gz stream", func(t *testing.T) {
+		// The bomb is an unsupported entry that is never imported, but decompressing past it is still bounded.
+		data := makeTarGz(t, []archiveEntry{{"bomb.bin", bomb + bomb + bomb + bomb}, {"main.go", "package main\n"}})
+		if _, _, err := FromUpload("bomb.tar.gz", data, DefaultLimits); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("err = %v, want ErrLimitExceeded", err)
+		}
+	})
+
+	t.Run("upload size", func(t *testing.T) {
+		limits := DefaultLimits
+		limits.MaxUploadBytes = 10
+		if _, _, err := FromUpload("main.go", []byte("package main\n"), limits); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("err = %v, want ErrLimitExceeded", err)
+		}
+	})
+}
+
+func TestFromUploadSingleFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		fileName  string
+		data      string
+		wantPaths []string
+		wantErr   error
+	}{
+		{"source file", "cmd/main.go", "package main\n", []string{"cmd/main.go"}, nil},
+		{"script text", "source.txt", scriptText("/home/me/project/cmd/main.go", "/home/me/project/go.mod"), []string{"cmd/main.go", "go.mod"}, nil},
+		{"dockerfile", "Dockerfile", "FROM golang\n", []string{"Dockerfile"}, nil},
+		{"binary source file", "main.go", "package\x00main", nil, ErrUnsupportedUpload},
+		{"unsupported", "photo.jpg", "\xff\xd8", nil, ErrUnsupportedUpload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, _, err := FromUpload(tt.fileName, []byte(tt.data), DefaultLimits)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromUpload: %v", err)
+			}
+			if got := paths(tree); !reflect.DeepEqual(got, tt.wantPaths) {
+				t.Errorf("paths = %v, want %v", got, tt.wantPaths)
+			}
+		})
+	}
+}
+
+// scriptText returns the copy_source_code script format for empty files at the given paths.
+func scriptText(filePaths ...string) string {
+	var sb strings.Builder
+	for _, filePath := range filePaths {
+		sb.WriteString(fileSeparator + "\nFile: " + filePath + "\n" + fileSeparator + "\n\n")
+	}
+	return sb.String()
+}
+
+func TestParseKeepsStoredPaths(t *testing.T) {
+	// A stored project whose files all live in one directory must not lose it when parsed again
+	tree, _, err := FromUpload("source.txt", []byte(scriptText("/src/app/internal/a.go", "/src/app/internal/b.go", "/src/app/main.go")), DefaultLimits)
+	if err != nil {
+		t.Fatalf("FromUpload: %v", err)
+	}
+	want := []string{"internal/a.go", "internal/b.go", "main.go"}
+	if got := paths(tree); !reflect.DeepEqual(got, want) {
+		t.Fatalf("paths = %v, want %v", got, want)
+	}
+
+	stored := &Tree{Files: tree.Files[:2]}
+	for i := 0; i < 2; i++ {
+		stored = Parse(stored.Format(), "source_code.txt")
+	}
+	if got, want := paths(stored), want[:2]; !reflect.DeepEqual(got, want) {
+		t.Errorf("paths after re-parsing = %v, want %v", got, want)
+	}
+}
+
+func TestIsText(t *testing.T) {
+	// A multi-byte rune straddling the 8000-byte sample boundary must not make text look binary.
+	straddling := strings.Repeat("a", 7999) + "é" + "tail"
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{"ascii", "package main\n", true},
+		{"utf8", "naïve — café", true},
+		{"nul byte", "abc\x00def", false},
+		{"invalid utf8", "abc\xffdef", false},
+		{"rune across sample boundary", straddling, true},
+		{"binary after sample", strings.Repeat("a", 8000) + "\x00", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isText([]byte(tt.data)); got != tt.want {
+				t.Errorf("isText = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsVendored(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"main.go", false},
+		{"vendor/x/y.go", true},
+		{"web/node_modules/a.js", true},
+		{"cmd/build.go", false},
+		{"cmd/build/main.go", true},
+		{"vendor.go", false},
+	}
+	for _, tt := range tests {
+		if got := isVendored(tt.path); got != tt.want {
+			t.Errorf("isVendored(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+// internal/sourcetree/patch.go
+
+package sourcetree
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// devNull is the path unified diffs use for the missing side of created or deleted files.
+const devNull = "/dev/null"
+
+// hunkHeaderPattern matches "@@ -oldStart,oldLines +newStart,newLines @@".
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// Hunks are searched for at most this many lines away from the position their header gives. Hunks with
+// little context match in many places, so they are held closer to their header.
+const (
+	maxHunkOffset      = 100
+	maxShortHunkOffset = 10
+	shortHunkLines     = 3
+)
+
+// ErrNoPatchContent is returned when a file contains no unified diff hunks.
+var ErrNoPatchContent = errors.New("no unified diff content found")
+
+// FilePatch holds the hunks of a unified diff that apply to one file.
+type FilePatch struct {
+	OldPath string
+	NewPath string
+	Hunks   []Hunk
+}
+
+// IsNew reports whether the patch creates a file.
+func (fp FilePatch) IsNew() bool {
+	return fp.OldPath == devNull
+}
+
+// IsDelete reports whether the patch deletes a file.
+func (fp FilePatch) IsDelete() bool {
+	return fp.NewPath == devNull
+}
+
+// Path returns the path of the file the patch applies to.
+func (fp FilePatch) Path() string {
+	if fp.IsDelete() {
+		return fp.OldPath
+	}
+	return fp.NewPath
+}
+
+// Hunk is a single "@@" section of a unified diff.
+type Hunk struct {
+	Header   string
+	OldStart int
+	Lines    []string // Each line keeps its ' ', '-' or '+' prefix
+}
+
+// oldAndNew splits the hunk into the lines it expects to find and the lines that replace them.
+func (h Hunk) oldAndNew() ([]string, []string) {
+	var oldLines, newLines []string
+	for _, line := range h.Lines {
+		body := line[1:]
+		switch line[0] {
+		case ' ':
+			oldLines = append(oldLines, body)
+			newLines = append(newLines, body)
+		case '-':
+			oldLines = append(oldLines, body)
+		case '+':
+			newLines = append(newLines, body)
+		}
+	}
+	return oldLines, newLines
+}
+
+// IsPatch reports whether the file name denotes a unified diff.
+func IsPatch(fileName string) bool {
+	lower := strings.ToLower(fileName)
+	return strings.HasSuffix(lower, ".patch") || strings.HasSuffix(lower, ".diff")
+}
+
+// ParsePatch parses unified diff text, such as the output of git diff, into per-file patches.
+func ParsePatch(text string) ([]FilePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var patches []FilePatch
+
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "--- ") || i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+			continue
+		}
+		patch := FilePatch{
+			OldPath: patchPath(lines[i][4:]),
+			NewPath: patchPath(lines[i+1][4:]),
+		}
+		i += 2
+
+		for i < len(lines) {
+			match := hunkHeaderPattern.FindStringSubmatch(lines[i])
+			if match == nil {
+				break
+			}
+			hunk := Hunk{Header: lines[i], OldStart: atoiDefault(match[1], 0)}
+			oldRemaining := atoiDefault(match[2], 1)
+			newRemaining := atoiDefault(match[4], 1)
+			i++
+
+			// Hunk bodies are delimited by their line counts, so removed lines that look like headers are handled correctly.
+			for i < len(lines) && (oldRemaining > 0 || newRemaining > 0) {
+				line := lines[i]
+				switch {
+				case strings.HasPrefix(line, "\\"):
+					// "\ No newline at end of file"
+				case line == "" || line[0] == ' ':
+					hunk.Lines = append(hunk.Lines, " "+strings.TrimPrefix(line, " "))
+					oldRemaining--
+					newRemaining--
+				case line[0] == '-':
+					hunk.Lines = append(hunk.Lines, line)
+					oldRemaining--
+				case line[0] == '+':
+					hunk.Lines = append(hunk.Lines, line)
+					newRemaining--
+				default:
+					return nil, fmt.Errorf("malformed hunk %q in %s", hunk.Header, patch.Path())
+				}
+				i++
+			}
+			for i < len(lines) && strings.HasPrefix(lines[i], "\\") {
+				i++
+			}
+			patch.Hunks = append(patch.Hunks, hunk)
+		}
+
+		patches = append(patches, patch)
+		i-- // Re-examine the current line in the outer loop
+	}
+
+	if len(patches) == 0 {
+		return nil, ErrNoPatchContent
+	}
+	return patches, nil
+}
+
+// patchPath strips timestamps and the a/ or b/ prefixes git adds to diff paths.
+func patchPath(raw string) string {
+	if i := strings.IndexByte(raw, '\t'); i >= 0 {
+		raw = raw[:i]
+	}
+	raw = strings.TrimSpace(raw)
+	if raw == devNull {
+		return devNull
+	}
+	if strings.HasPrefix(raw, "a/") || strings.HasPrefix(raw, "b/") {
+		raw = raw[2:]
+	}
+	return CleanPath(raw)
+}
+
+// atoiDefault parses an integer, returning def for empty or invalid input.
+func atoiDefault(s string, def int) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// HunkResult records the outcome of applying a single hunk.
+type HunkResult struct {
+	File    string
+	Header  string
+	Applied bool
+	Offset  int    // Line offset at which an applied hunk was found relative to its header
+	Reason  string // Why a hunk was rejected
+}
+
+// PatchReport summarizes the outcome of applying a patch to a tree.
+type PatchReport struct {
+	Hunks        []HunkResult
+	FilesCreated []string
+	FilesDeleted []string
+}
+
+// AppliedCount returns the number of hunks that applied.
+func (r *PatchReport) AppliedCount() int {
+	n := 0
+	for _, h := range r.Hunks {
+		if h.Applied {
+			n++
+		}
+	}
+	return n
+}
+
+// Changed reports whether applying the patch modified the tree.
+func (r *PatchReport) Changed() bool {
+	return r.AppliedCount() > 0 || len(r.FilesCreated) > 0 || len(r.FilesDeleted) > 0
+}
+
+// ApplyPatch applies file patches to the tree. Hunks whose context cannot be found are rejected
+// individually; the remaining hunks still apply.
+func (t *Tree) ApplyPatch(patches []FilePatch) *PatchReport {
+	report := &PatchReport{}
+	for _, patch := range patches {
+		path := patch.Path()
+		switch {
+		case patch.IsDelete():
+			if t.Delete(path) {
+				report.FilesDeleted = append(report.FilesDeleted, path)
+			} else {
+				report.Hunks = append(report.Hunks, HunkResult{File: path, Header: "delete", Reason: "file not found"})
+			}
+			continue
+		case patch.IsNew():
+			if _, exists := t.Get(path); exists {
+				report.Hunks = append(report.Hunks, HunkResult{File: path, Header: "create", Reason: "file already exists"})
+				continue
+			}
+			var created []string
+			for _, hunk := range patch.Hunks {
+				_, newLines := hunk.oldAndNew()
+				created = append(created, newLines...)
+			}
+			t.Set(path, strings.Join(created, "\n")+"\n")
+			report.FilesCreated = append(report.FilesCreated, path)
+			continue
+		}
+
+		file, exists := t.Get(patch.OldPath)
+		if !exists {
+			for _, hunk := range patch.Hunks {
+				report.Hunks = append(report.Hunks, HunkResult{File: path, Header: hunk.Header, Reason: "file not found"})
+			}
+			continue
+		}
+
+		content := file.Content
+		trailingNewline := strings.HasSuffix(content, "\n")
+		lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+		if content == "" {
+			lines = nil
+		}
+
+		delta := 0
+		for _, hunk := range patch.Hunks {
+			oldLines, newLines := hunk.oldAndNew()
+			expected := hunk.OldStart - 1 + delta
+			if len(oldLines) == 0 {
+				// Pure insertion hunks reference the line after which to insert.
+				expected = hunk.OldStart + delta
+			}
+			pos, ok := findLines(lines, oldLines, expected)
+			if !ok {
+				report.Hunks = append(report.Hunks, HunkResult{File: path, Header: hunk.Header, Reason: "context not found"})
+				continue
+			}
+
+			updated := make([]string, 0, len(lines)-len(oldLines)+len(newLines))
+			updated = append(updated, lines[:pos]...)
+			updated = append(updated, newLines...)
+			updated = append(updated, lines[pos+len(oldLines):]...)
+			lines = updated
+
+			report.Hunks = append(report.Hunks, HunkResult{File: path, Header: hunk.Header, Applied: true, Offset: pos - expected})
+			delta += len(newLines) - len(oldLines)
+		}
+
+		newContent := strings.Join(lines, "\n")
+		if trailingNewline || content == "" {
+			newContent += "\n"
+		}
+		if patch.NewPath != patch.OldPath {
+			t.Delete(patch.OldPath)
+		}
+		t.Set(path, newContent)
+	}
+	return report
+}
+
+// findLines locates needle in lines, searching outward from the expected index no further than
+// maxHunkOffset lines, or maxShortHunkOffset for needles shorter than shortHunkLines.
+// Exact matches are preferred; a second pass ignores trailing whitespace differences.
+func findLines(lines, needle []string, expected int) (int, bool) {
+	if expected < 0 {
+		expected = 0
+	}
+	if expected > len(lines) {
+		expected = len(lines)
+	}
+	if len(needle) == 0 {
+		return expected, true
+	}
+	maxOffset := maxHunkOffset
+	if len(needle) < shortHunkLines {
+		maxOffset = maxShortHunkOffset
+	}
+
+	for _, equal := range []func(a, b string) bool{
+		func(a, b string) bool { return a == b },
+		func(a, b string) bool { return strings.TrimRight(a, " \t") == strings.TrimRight(b, " \t") },
+	} {
+		for distance := 0; distance <= maxOffset && distance <= len(lines); distance++ {
+			for _, pos := range []int{expected - distance, expected + distance} {
+				if pos < 0 || pos+len(needle) > len(lines) {
+					continue
+				}
+				if matchesAt(lines, needle, pos, equal) {
+					return pos, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+// matchesAt reports whether needle matches lines starting at pos.
+func matchesAt(lines, needle []string, pos int, equal func(a, b string) bool) bool {
+	for i, line := range needle {
+		if !equal(lines[pos+i], line) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,318 @@
+// internal/sourcetree/patch_test.go
+
+package sourcetree
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		diff    string
+		want    []FilePatch
+		wantErr bool
+	}{
+		{
+			name: "git diff",
+			diff: "diff --git a/cmd/main.go b/cmd/main.go\n" +
+				"index 1111111..2222222 100644\n" +
+				"--- a/cmd/main.go\n" +
+				"+++ b/cmd/main.go\n" +
+				"@@ -1,3 +1,3 @@\n" +
+				" package main\n" +
+				"-var x = 1\n" +
+				"+var x = 2\n" +
+				" \n",
+			want: []FilePatch{{OldPath: "cmd/main.go", NewPath: "cmd/main.go", Hunks: []Hunk{{
+				Header:   "@@ -1,3 +1,3 @@",
+				OldStart: 1,
+				Lines:    []string{" package main", "-var x = 1", "+var x = 2", " "},
+			}}}},
+		},
+		{
+			name: "timestamps and default counts",
+			diff: "--- old.txt\t2024-01-01 00:00:00\n" +
+				"+++ new.txt\t2024-01-02 00:00:00\n" +
+				"@@ -4 +4 @@\n" +
+				"-a\n" +
+				"+b\n",
+			want: []FilePatch{{OldPath: "old.txt", NewPath: "new.txt", Hunks: []Hunk{{
+				Header: "@@ -4 +4 @@", OldStart: 4, Lines: []string{"-a", "+b"},
+			}}}},
+		},
+		{
+			name: "removed lines that look like file headers",
+			diff: "--- a/notes.md\n" +
+				"+++ b/notes.md\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				" keep\n" +
+				"--- a/draft\n" +
+				"+++ b/draft\n",
+			want: []FilePatch{{OldPath: "notes.md", NewPath: "notes.md", Hunks: []Hunk{{
+				Header: "@@ -1,2 +1,2 @@", OldStart: 1, Lines: []string{" keep", "--- a/draft", "+++ b/draft"},
+			}}}},
+		},
+		{
+			name: "no newline markers",
+			diff: "--- a/x.go\n" +
+				"+++ b/x.go\n" +
+				"@@ -1 +1 @@\n" +
+				"-old\n" +
+				"\\ No newline at end of file\n" +
+				"+new\n" +
+				"\\ No newline at end of file\n" +
+				"--- a/y.go\n" +
+				"+++ b/y.go\n" +
+				"@@ -1 +1 @@\n" +
+				"-1\n" +
+				"+2\n",
+			want: []FilePatch{
+				{OldPath: "x.go", NewPath: "x.go", Hunks: []Hunk{{Header: "@@ -1 +1 @@", OldStart: 1, Lines: []string{"-old", "+new"}}}},
+				{OldPath: "y.go", NewPath: "y.go", Hunks: []Hunk{{Header: "@@ -1 +1 @@", OldStart: 1, Lines: []string{"-1", "+2"}}}},
+			},
+		},
+		{
+			name: "create and delete",
+			diff: "--- /dev/null\n" +
+				"+++ b/new.go\n" +
+				"@@ -0,0 +1,2 @@\n" +
+				"+package x\n" +
+				"+\n" +
+				"--- a/old.go\n" +
+				"+++ /dev/null\n" +
+				"@@ -1 +0,0 @@\n" +
+				"-package x\n",
+			want: []FilePatch{
+				{OldPath: devNull, NewPath: "new.go", Hunks: []Hunk{{Header: "@@ -0,0 +1,2 @@", Lines: []string{"+package x", "+"}}}},
+				{OldPath: "old.go", NewPath: devNull, Hunks: []Hunk{{Header: "@@ -1 +0,0 @@", OldStart: 1, Lines: []string{"-package x"}}}},
+			},
+		},
+		{
+			name: "crlf line endings",
+			diff: "--- a/x.go\r\n+++ b/x.go\r\n@@ -1 +1 @@\r\n-a\r\n+b\r\n",
+			want: []FilePatch{{OldPath: "x.go", NewPath: "x.go", Hunks: []Hunk{{Header: "@@ -1 +1 @@", OldStart: 1, Lines: []string{"-a", "+b"}}}}},
+		},
+		{
+			name:    "malformed hunk body",
+			diff:    "--- a/x.go\n+++ b/x.go\n@@ -1,2 +1,2 @@\n-a\n*b\n",
+			wantErr: true,
+		},
+		{
+			name:    "no diff content",
+			diff:    "just some text\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePatch(tt.diff)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParsePatch succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePatch: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePatch =\n%#v\nwant\n%#v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParsePatch("nothing here"); !errors.Is(err, ErrNoPatchContent) {
+		t.Errorf("err = %v, want ErrNoPatchContent", err)
+	}
+}
+
+// numbered returns n lines "line 001" to "line n", each followed by a newline.
+func numbered(n int) string {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&sb, "line %03d\n", i)
+	}
+	return sb.String()
+}
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       map[string]string
+		diff        string
+		want        map[string]string
+		wantApplied []bool
+		wantOffsets []int
+		wantCreated []string
+		wantDeleted []string
+	}{
+		{
+			name:        "modify",
+			files:       map[string]string{"a.go": "package a\n\nvar x = 1\n"},
+			diff:        "--- a/a.go\n+++ b/a.go\n@@ -1,3 +1,3 @@\n package a\n \n-var x = 1\n+var x = 2\n",
+			want:        map[string]string{"a.go": "package a\n\nvar x = 2\n"},
+			wantApplied: []bool{true},
+			wantOffsets: []int{0},
+		},
+		{
+			name:        "hunk found at an offset",
+			files:       map[string]string{"a.txt": "new 1\nnew 2\nnew 3\n" + numbered(6)},
+			diff:        "--- a/a.txt\n+++ b/a.txt\n@@ -2,3 +2,3 @@\n line 002\n-line 003\n+LINE 3\n line 004\n",
+			want:        map[string]string{"a.txt": "new 1\nnew 2\nnew 3\nline 001\nline 002\nLINE 3\nline 004\nline 005\nline 006\n"},
+			wantApplied: []bool{true},
+			wantOffsets: []int{3},
+		},
+		{
+			name:  "multiple hunks shift later positions",
+			files: map[string]string{"a.txt": numbered(12)},
+			diff: "--- a/a.txt\n+++ b/a.txt\n" +
+				"@@ -2,2 +2,4 @@\n line 002\n+extra 1\n+extra 2\n line 003\n" +
+				"@@ -10,3 +12,2 @@\n line 010\n-line 011\n line 012\n",
+			want: map[string]string{"a.txt": "line 001\nline 002\nextra 1\nextra 2\nline 003\nline 004\nline 005\nline 006\n" +
+				"line 007\nline 008\nline 009\nline 010\nline 012\n"},
+			wantApplied: []bool{true, true},
+			wantOffsets: []int{0, 0},
+		},
+		{
+			name:        "pure insertion",
+			files:       map[string]string{"a.txt": "one\ntwo\n"},
+			diff:        "--- a/a.txt\n+++ b/a.txt\n@@ -1,0 +2 @@\n+between\n",
+			want:        map[string]string{"a.txt": "one\nbetween\ntwo\n"},
+			wantApplied: []bool{true},
+			wantOffsets: []int{0},
+		},
+		{
+			name:        "trailing whitespace differences",
+			files:       map[string]string{"a.txt": "keep  \nold\n"},
+			diff:        "--- a/a.txt\n+++ b/a.txt\n@@ -1,2 +1,2 @@\n keep\n-old\n+new\n",
+			want:        map[string]string{"a.txt": "keep\nnew\n"},
+			wantApplied: []bool{true},
+			wantOffsets: []int{0},
+		},
+		{
+			name:        "missing newline at end of file is preserved",
+			files:       map[string]string{"a.txt": "a\nb"},
+			diff:        "--- a/a.txt\n+++ b/a.txt\n@@ -2 +2 @@\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+			want:        map[string]string{"a.txt": "a\nc"},
+			wantApplied: []bool{true},
+			wantOffsets: []int{0},
+		},
+		{
+			name:  "rejected hunk does not stop the others",
+			files: map[string]string{"a.txt": numbered(8)},
+			diff: "--- a/a.txt\n+++ b/a.txt\n" +
+				"@@ -1,2 +1,2 @@\n-no such line\n+x\n line 002\n" +
+				"@@ -7,2 +7,2 @@\n line 007\n-line 008\n+LINE 8\n",
+			want:        map[string]string{"a.txt": strings.Replace(numbered(8), "line 008", "LINE 8", 1)},
+			wantApplied: []bool{false, true},
+			wantOffsets: []int{0, 0},
+		},
+		{
+			name:        "short context far from its header is rejected",
+			files:       map[string]string{"a.go": numbered(40) + "}\n"},
+			diff:        "--- a/a.go\n+++ b/a.go\n@@ -2 +2,2 @@\n }\n+// end\n",
+			want:        map[string]string{"a.go": numbered(40) + "}\n"},
+			wantApplied: []bool{false},
+			wantOffsets: []int{0},
+		},
+		{
+			name:        "short context near its header applies",
+			files:       map[string]string{"a.go": numbered(5) + "}\n"},
+			diff:        "--- a/a.go\n+++ b/a.go\n@@ -2 +2,2 @@\n }\n+// end\n",
+			want:        map[string]string{"a.go": numbered(5) + "}\n// end\n"},
+			wantApplied: []bool{true},
+			wantOffsets: []int{4},
+		},
+		{
+			name:        "create",
+			files:       map[string]string{},
+			diff:        "--- /dev/null\n+++ b/pkg/new.go\n@@ -0,0 +1,2 @@\n+package pkg\n+\n",
+			want:        map[string]string{"pkg/new.go": "package pkg\n\n"},
+			wantCreated: []string{"pkg/new.go"},
+		},
+		{
+			name:        "create over an existing file is rejected",
+			files:       map[string]string{"new.go": "keep\n"},
+			diff:        "--- /dev/null\n+++ b/new.go\n@@ -0,0 +1 @@\n+replace\n",
+			want:        map[string]string{"new.go": "keep\n"},
+			wantApplied: []bool{false},
+			wantOffsets: []int{0},
+		},
+		{
+			name:        "delete",
+			files:       map[string]string{"old.go": "package x\n", "keep.go": "package x\n"},
+			diff:        "--- a/old.go\n+++ /dev/null\n@@ -1 +0,0 @@\n-package x\n",
+			want:        map[string]string{"keep.go": "package x\n"},
+			wantDeleted: []string{"old.go"},
+		},
+		{
+			name:        "delete of a missing file is rejected",
+			files:       map[string]string{},
+			diff:        "--- a/old.go\n+++ /dev/null\n@@ -1 +0,0 @@\n-package x\n",
+			want:        map[string]string{},
+			wantApplied: []bool{false},
+			wantOffsets: []int{0},
+		},
+		{
+			name:        "rename with changes",
+			files:       map[string]string{"old.go": "package x\nvar a = 1\n"},
+			diff:        "--- a/old.go\n+++ b/new.go\n@@ -1,2 +1,2 @@\n package x\n-var a = 1\n+var a = 2\n",
+			want:        map[string]string{"new.go": "package x\nvar a = 2\n"},
+			wantApplied: []bool{true},
+			wantOffsets: []int{0},
+		},
+		{
+			name:        "patch to a missing file is rejected",
+			files:       map[string]string{},
+			diff:        "--- a/a.go\n+++ b/a.go\n@@ -1 +1 @@\n-a\n+b\n",
+			want:        map[string]string{},
+			wantApplied: []bool{false},
+			wantOffsets: []int{0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := &Tree{}
+			for path, content := range tt.files {
+				tree.Set(path, content)
+			}
+			patches, err := ParsePatch(tt.diff)
+			if err != nil {
+				t.Fatalf("ParsePatch: %v", err)
+			}
+			report := tree.ApplyPatch(patches)
+
+			got := make(map[string]string)
+			for _, f := range tree.Files {
+				got[f.Path] = f.Content
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+
+			var applied []bool
+			var offsets []int
+			for _, h := range report.Hunks {
+				applied = append(applied, h.Applied)
+				offsets = append(offsets, h.Offset)
+			}
+			if !reflect.DeepEqual(applied, tt.wantApplied) {
+				t.Errorf("applied = %v, want %v (report %+v)", applied, tt.wantApplied, report.Hunks)
+			}
+			if !reflect.DeepEqual(offsets, tt.wantOffsets) {
+				t.Errorf("offsets = %v, want %v", offsets, tt.wantOffsets)
+			}
+			if !reflect.DeepEqual(report.FilesCreated, tt.wantCreated) {
+				t.Errorf("created = %v, want %v", report.FilesCreated, tt.wantCreated)
+			}
+			if !reflect.DeepEqual(report.FilesDeleted, tt.wantDeleted) {
+				t.Errorf("deleted = %v, want %v", report.FilesDeleted, tt.wantDeleted)
+			}
+		})
+	}
+}
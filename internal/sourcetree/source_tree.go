@@ -0,0 +1,164 @@
+// internal/sourcetree/source_tree.go
+
+package sourcetree
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// fileSeparator is the line the copy_source_code scripts write around each file header.
+const fileSeparator = "----------------------------------------"
+
+// File represents a single source file in a user's uploaded tree.
+type File struct {
+	Path    string
+	Content string
+}
+
+// Tree represents a user's uploaded source tree as an ordered list of files.
+type Tree struct {
+	Files []File
+}
+
+// Parse reads a source tree from the text format produced by the copy_source_code scripts, keeping
+// the paths as written. Text without any file headers is treated as a single file named fallbackName.
+func Parse(text, fallbackName string) *Tree {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	tree := &Tree{}
+
+	current := -1
+	var content []string
+	flush := func() {
+		if current >= 0 {
+			// The scripts separate files with a blank line that is not part of the content.
+			tree.Files[current].Content = strings.TrimSuffix(strings.Join(content, "\n"), "\n")
+		}
+		content = nil
+	}
+
+	for i := 0; i < len(lines); i++ {
+		if isFileHeader(lines, i) {
+			flush()
+			tree.Files = append(tree.Files, File{Path: CleanPath(strings.TrimPrefix(lines[i+1], "File: "))})
+			current = len(tree.Files) - 1
+			i += 2
+			continue
+		}
+		if current >= 0 {
+			content = append(content, lines[i])
+		}
+	}
+	flush()
+
+	if len(tree.Files) == 0 && strings.TrimSpace(text) != "" {
+		tree.Files = append(tree.Files, File{Path: CleanPath(fallbackName), Content: text})
+	}
+	return tree
+}
+
+// trimCommonDirectory strips the directory prefix shared by every file, since the scripts write absolute
+// paths. It runs once when a tree is imported, so stored trees keep their paths when parsed again.
+func (t *Tree) trimCommonDirectory() {
+	if len(t.Files) == 0 {
+		return
+	}
+	common := path.Dir(t.Files[0].Path)
+	for _, file := range t.Files[1:] {
+		for common != "." && !strings.HasPrefix(file.Path, common+"/") {
+			common = path.Dir(common)
+		}
+	}
+	if common == "." {
+		return
+	}
+	for i := range t.Files {
+		t.Files[i].Path = strings.TrimPrefix(t.Files[i].Path, common+"/")
+	}
+}
+
+// isFileHeader reports whether lines[i:i+3] form a separator/File:/separator header.
+func isFileHeader(lines []string, i int) bool {
+	return i+2 < len(lines) &&
+		strings.TrimRight(lines[i], "\r") == fileSeparator &&
+		strings.HasPrefix(lines[i+1], "File: ") &&
+		strings.TrimRight(lines[i+2], "\r") == fileSeparator
+}
+
+// Format serializes the tree in the same text format the copy_source_code scripts produce.
+func (t *Tree) Format() string {
+	var sb strings.Builder
+	for _, file := range t.Files {
+		sb.WriteString(fileSeparator + "\n")
+		sb.WriteString("File: " + file.Path + "\n")
+		sb.WriteString(fileSeparator + "\n")
+		sb.WriteString(file.Content)
+		sb.WriteString("\n\n")
+	}
+	return sb.String()
+}
+
+// Size returns the total number of content bytes in the tree.
+func (t *Tree) Size() int {
+	size := 0
+	for _, file := range t.Files {
+		size += len(file.Content)
+	}
+	return size
+}
+
+// Get returns the file at the given path.
+func (t *Tree) Get(filePath string) (File, bool) {
+	filePath = CleanPath(filePath)
+	for _, file := range t.Files {
+		if file.Path == filePath {
+			return file, true
+		}
+	}
+	return File{}, false
+}
+
+// Set creates or replaces the file at the given path.
+func (t *Tree) Set(filePath, content string) {
+	filePath = CleanPath(filePath)
+	for i, file := range t.Files {
+		if file.Path == filePath {
+			t.Files[i].Content = content
+			return
+		}
+	}
+	t.Files = append(t.Files, File{Path: filePath, Content: content})
+}
+
+// Delete removes the file at the given path, reporting whether it existed.
+func (t *Tree) Delete(filePath string) bool {
+	filePath = CleanPath(filePath)
+	for i, file := range t.Files {
+		if file.Path == filePath {
+			t.Files = append(t.Files[:i], t.Files[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Sort orders the files by path.
+func (t *Tree) Sort() {
+	sort.Slice(t.Files, func(i, j int) bool {
+		return t.Files[i].Path < t.Files[j].Path
+	})
+}
+
+// Summary returns a one-line description of the tree's size.
+func (t *Tree) Summary() string {
+	return fmt.Sprintf("%d file(s), %d bytes", len(t.Files), t.Size())
+}
+
+// CleanPath normalizes a file path to a relative, slash-separated form without leading "./" or "/".
+func CleanPath(filePath string) string {
+	filePath = strings.TrimSpace(strings.ReplaceAll(filePath, "\\", "/"))
+	filePath = path.Clean("/" + filePath)
+	return strings.TrimPrefix(filePath, "/")
+}
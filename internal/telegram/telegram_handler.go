@@ -13,6 +13,9 @@ import (
 	"time"
 
 	"KernelSandersBot/internal/handlers"
+	"KernelSandersBot/internal/markdown"
+	"KernelSandersBot/internal/secrets"
+	"KernelSandersBot/internal/sourcetree"
 	"KernelSandersBot/internal/types"
 )
 
@@ -91,22 +94,27 @@ func (th *TelegramHandler) HandleTelegramMessage(update *types.TelegramUpdate) (
 
 	// Implement Task 1: Remove "/upload" from group chats and inform users to message directly
 	if isGroup && strings.HasPrefix(message.Text, "/upload@"+th.Processor.GetBotUsername()) {
-		errMsg := "✅ *Privacy Notice*\n\nFor privacy reasons, please message me directly by clicking @" + th.Processor.GetBotUsername() + " to upload your source code files."
+		errMsg := "✅ <b>Privacy Notice</b>\n\nFor privacy reasons, please message me directly by clicking @" + th.Processor.GetBotUsername() + " to upload your source code files."
 		if err := th.Processor.SendMessage(message.Chat.ID, errMsg, message.MessageID); err != nil {
 			log.Printf("Failed to send privacy notice message: %v", err)
 		}
 		return "", nil
 	}
 
-	// In group chats, ignore any commands other than those explicitly tagged
-	if isGroup && !isTagged {
-		// Ignore messages not tagged
+	// Replying to one of the bot's messages continues that conversation without tagging the bot
+	replyToMessageID := 0
+	if reply := message.ReplyToMessage; reply != nil && reply.From.IsBot && strings.EqualFold(reply.From.Username, th.Processor.GetBotUsername()) {
+		replyToMessageID = reply.MessageID
+	}
+
+	// In group chats, ignore messages that neither tag the bot nor reply to it
+	if isGroup && !isTagged && replyToMessageID == 0 {
 		return "", nil
 	}
 
-	// Process messages in private chats or tagged messages in group chats
-	if !isGroup || isTagged {
-		if err := th.Processor.ProcessMessage(chatID, userID, username, userQuestion, messageID); err != nil {
+	// Process messages in private chats, and tagged messages or replies to the bot in group chats
+	if !isGroup || isTagged || replyToMessageID != 0 {
+		if err := th.Processor.ProcessMessage(chatID, userID, username, userQuestion, messageID, replyToMessageID); err != nil {
 			log.Printf("Error processing message: %v", err)
 			return "", nil
 		}
@@ -122,22 +130,31 @@ func (th *TelegramHandler) HandleDocument(message *types.TelegramMessage) (strin
 		return "", errors.New("no document found in the message")
 	}
 
-	// Only accept text files
-	if !strings.HasSuffix(strings.ToLower(document.FileName), ".txt") {
-		errMsg := "❌ *Unsupported File Type*\n\nPlease upload a .txt file containing your source code."
+	// Only accept source files and archives of source files
+	if !sourcetree.IsSupportedUpload(document.FileName) {
+		errMsg := "❌ <b>Unsupported File Type</b>\n\nPlease upload a .txt file, a source code file, a .zip / .tar.gz archive of your project, or a .patch / .diff to update it."
 		if err := th.Processor.SendMessage(message.Chat.ID, errMsg, message.MessageID); err != nil {
 			log.Printf("Failed to send unsupported file type message: %v", err)
 		}
 		return "", nil
 	}
 
+	// Reject oversized uploads before downloading them
+	if int64(document.FileSize) > sourcetree.DefaultLimits.MaxUploadBytes {
+		errMsg := fmt.Sprintf("❌ <b>File Too Large</b>\n\nUploads are limited to %d MB.", sourcetree.DefaultLimits.MaxUploadBytes>>20)
+		if err := th.Processor.SendMessage(message.Chat.ID, errMsg, message.MessageID); err != nil {
+			log.Printf("Failed to send file too large message: %v", err)
+		}
+		return "", nil
+	}
+
 	// Determine chat type
 	chatType := message.Chat.Type
 	isGroup := chatType == "group" || chatType == "supergroup"
 
 	// In group chats, ensure caption contains @BOT_USERNAME
 	if isGroup && message.Text != "" && !strings.Contains(strings.ToLower(message.Text), "@"+strings.ToLower(th.Processor.GetBotUsername())) {
-		errMsg := fmt.Sprintf("❌ *Upload Tag Missing*\n\nPlease tag me using @%s in the caption to upload files in group chats.", th.Processor.GetBotUsername())
+		errMsg := fmt.Sprintf("❌ <b>Upload Tag Missing</b>\n\nPlease tag me using @%s in the caption to upload files in group chats.", th.Processor.GetBotUsername())
 		if err := th.Processor.SendMessage(message.Chat.ID, errMsg, message.MessageID); err != nil {
 			log.Printf("Failed to send upload tag missing message: %v", err)
 		}
@@ -154,7 +171,7 @@ func (th *TelegramHandler) HandleDocument(message *types.TelegramMessage) (strin
 	fileURL, err := th.getFileURL(document.FileID)
 	if err != nil {
 		log.Printf("Failed to get file URL: %v", err)
-		errMsg := "❌ *File Retrieval Error*\n\nFailed to retrieve the uploaded file. Please try again."
+		errMsg := "❌ <b>File Retrieval Error</b>\n\nFailed to retrieve the uploaded file. Please try again."
 		if err := th.Processor.SendMessage(message.Chat.ID, errMsg, message.MessageID); err != nil {
 			log.Printf("Failed to send file retrieval error message: %v", err)
 		}
@@ -164,17 +181,49 @@ func (th *TelegramHandler) HandleDocument(message *types.TelegramMessage) (strin
 	fileContent, err := th.downloadFile(fileURL)
 	if err != nil {
 		log.Printf("Failed to download file: %v", err)
-		errMsg := "❌ *File Download Error*\n\nFailed to download the uploaded file. Please ensure the file is accessible."
+		errMsg := "❌ <b>File Download Error</b>\n\nFailed to download the uploaded file. Please ensure the file is accessible."
 		if err := th.Processor.SendMessage(message.Chat.ID, errMsg, message.MessageID); err != nil {
 			log.Printf("Failed to send file download error message: %v", err)
 		}
 		return "", err
 	}
 
+	// Patches update the stored source tree instead of replacing it
+	if sourcetree.IsPatch(document.FileName) {
+		return th.applyPatchUpload(message, fileContent)
+	}
+
+	// Unpack archives and filter out binaries and vendored directories
+	tree, report, err := sourcetree.FromUpload(document.FileName, fileContent, sourcetree.DefaultLimits)
+	if err != nil {
+		log.Printf("Failed to import upload %s: %v", document.FileName, err)
+		errMsg := "❌ <b>File Processing Error</b>\n\nThe uploaded file could not be read as source code."
+		if errors.Is(err, sourcetree.ErrLimitExceeded) {
+			errMsg = fmt.Sprintf("❌ <b>Upload Too Large</b>\n\n%s", markdown.EscapeTelegramHTML(err.Error()))
+		}
+		if err := th.Processor.SendMessage(message.Chat.ID, errMsg, message.MessageID); err != nil {
+			log.Printf("Failed to send import error message: %v", err)
+		}
+		return "", nil
+	}
+	if len(tree.Files) == 0 {
+		errMsg := "❌ <b>No Source Files Found</b>\n\nThe upload did not contain any supported source files."
+		if err := th.Processor.SendMessage(message.Chat.ID, errMsg, message.MessageID); err != nil {
+			log.Printf("Failed to send empty upload message: %v", err)
+		}
+		return "", nil
+	}
+
+	// Redact credentials before the code is stored or sent to the model
+	findings := secrets.RedactTree(tree)
+
 	// Store the file content associated with the user
-	if err := th.Processor.StoreUserSourceCode(message.From.ID, fileContent); err != nil {
+	if err := th.Processor.StoreUserSourceCode(message.From.ID, tree.Format()); err != nil {
 		log.Printf("Failed to store user source code: %v", err)
-		errMsg := "❌ *File Processing Error*\n\nFailed to process the uploaded file. Please try again."
+		errMsg := "❌ <b>File Processing Error</b>\n\nFailed to process the uploaded file. Please try again."
+		if errors.Is(err, types.ErrProjectQuotaExceeded) {
+			errMsg = fmt.Sprintf("❌ <b>Project Quota Exceeded</b>\n\n%s\n\nUse /projects to review your projects.", markdown.EscapeTelegramHTML(err.Error()))
+		}
 		if err := th.Processor.SendMessage(message.Chat.ID, errMsg, message.MessageID); err != nil {
 			log.Printf("Failed to send storage error message: %v", err)
 		}
@@ -187,38 +236,126 @@ func (th *TelegramHandler) HandleDocument(message *types.TelegramMessage) (strin
 
 	// Send confirmation message with UTC and EDT upload and deletion times
 	confirmationMsg := fmt.Sprintf(
-		"✅ *File Uploaded Successfully*\n\nYour source code has been uploaded and will be stored until:\n\n"+
-			"• *Upload Time:* UTC: %s | EDT: %s\n"+
-			"• *Deletion Time:* UTC: %s | EDT: %s\n\n"+
-			"Please save any work or prompts that may be useful in the future.",
+		"✅ <b>File Uploaded Successfully</b>\n\nYour source code has been uploaded to project <b>%s</b> and will be stored until:\n\n"+
+			"• <b>Upload Time:</b> UTC: %s | EDT: %s\n"+
+			"• <b>Deletion Time:</b> UTC: %s | EDT: %s\n\n"+
+			"%s%s\n\n"+
+			"An analysis of your project follows shortly. Please save any work or prompts that may be useful in the future.",
+		markdown.EscapeTelegramHTML(th.Processor.GetActiveProject(message.From.ID)),
 		uploadedAt.UTC().Format(time.RFC1123),
 		uploadedAt.In(time.FixedZone("EDT", -4*3600)).Format(time.RFC1123),
 		deletionTime.UTC().Format(time.RFC1123),
 		deletionTime.In(time.FixedZone("EDT", -4*3600)).Format(time.RFC1123),
+		formatImportReport(tree, report),
+		formatSecretFindings(findings),
 	)
 	if err := th.Processor.SendMessage(message.Chat.ID, confirmationMsg, message.MessageID); err != nil {
 		log.Printf("Failed to send confirmation message: %v", err)
 	}
 
-	// Send analysis summary using the Processor's AnalyzeUserCode method
-	summary, err := th.Processor.AnalyzeUserCode(message.From.ID)
+	// Analysis makes many model requests, so it runs in the background and its digest follows
+	go th.sendAnalysis(message)
+
+	return "", nil
+}
+
+// sendAnalysis analyzes the user's active project and sends the digest, which links to the full report.
+func (th *TelegramHandler) sendAnalysis(message *types.TelegramMessage) {
+	digest, err := th.Processor.AnalyzeUserCode(message.From.ID)
 	if err != nil {
 		log.Printf("Failed to analyze user code: %v", err)
-		// Optionally notify the user about the failure
-		return "", nil
+		return
 	}
 
-	analysisMsg := fmt.Sprintf(
-		"📄 *Code Analysis Summary:*\n\n%s\n\nYou can reference your source code using `#source_code` in your questions.",
-		summary,
-	)
-	if err := th.Processor.SendMessage(message.Chat.ID, analysisMsg, message.MessageID); err != nil {
+	if err := th.Processor.SendMessage(message.Chat.ID, digest, message.MessageID); err != nil {
 		log.Printf("Failed to send code analysis summary message: %v", err)
 	}
+}
+
+// applyPatchUpload applies an uploaded unified diff to the user's stored source tree and reports the hunks applied or rejected.
+func (th *TelegramHandler) applyPatchUpload(message *types.TelegramMessage, patchContent []byte) (string, error) {
+	patches, err := sourcetree.ParsePatch(string(patchContent))
+	if err != nil {
+		log.Printf("Failed to parse patch from user %d: %v", message.From.ID, err)
+		errMsg := fmt.Sprintf("❌ <b>Invalid Patch</b>\n\n%s\n\nUpload the output of <code>git diff</code> or <code>diff -u</code>.", markdown.EscapeTelegramHTML(err.Error()))
+		if err := th.Processor.SendMessage(message.Chat.ID, errMsg, message.MessageID); err != nil {
+			log.Printf("Failed to send invalid patch message: %v", err)
+		}
+		return "", nil
+	}
 
+	code, exists := th.Processor.GetUserSourceCode(message.From.ID)
+	if !exists {
+		errMsg := "❗ <b>No Source Code Found</b>\n\nUpload your source code before applying a patch to it."
+		if err := th.Processor.SendMessage(message.Chat.ID, errMsg, message.MessageID); err != nil {
+			log.Printf("Failed to send no source code message: %v", err)
+		}
+		return "", nil
+	}
+
+	tree := sourcetree.Parse(code, "source_code.txt")
+	report := tree.ApplyPatch(patches)
+	var findings []secrets.Finding
+	if report.Changed() {
+		// Patched lines may introduce new credentials
+		findings = secrets.RedactTree(tree)
+		if err := th.Processor.StoreUserSourceCode(message.From.ID, tree.Format()); err != nil {
+			log.Printf("Failed to store patched source code: %v", err)
+			errMsg := "❌ <b>File Processing Error</b>\n\nThe patch applied but the result could not be stored. Please try again."
+			if errors.Is(err, types.ErrProjectQuotaExceeded) {
+				errMsg = fmt.Sprintf("❌ <b>Project Quota Exceeded</b>\n\n%s", markdown.EscapeTelegramHTML(err.Error()))
+			}
+			if err := th.Processor.SendMessage(message.Chat.ID, errMsg, message.MessageID); err != nil {
+				log.Printf("Failed to send storage error message: %v", err)
+			}
+			return "", err
+		}
+	}
+
+	reply := formatPatchReport(report, th.Processor.GetActiveProject(message.From.ID)) + formatSecretFindings(findings)
+	if err := th.Processor.SendMessage(message.Chat.ID, reply, message.MessageID); err != nil {
+		log.Printf("Failed to send patch report message: %v", err)
+	}
 	return "", nil
 }
 
+// formatPatchReport lists the outcome of every hunk, keeping the message within Telegram's limits.
+func formatPatchReport(report *sourcetree.PatchReport, project string) string {
+	const maxListedHunks = 40
+
+	var sb strings.Builder
+	if report.Changed() {
+		sb.WriteString(fmt.Sprintf("🩹 <b>Patch Applied to %s</b>\n\n", markdown.EscapeTelegramHTML(project)))
+	} else {
+		sb.WriteString(fmt.Sprintf("❌ <b>Patch Not Applied to %s</b>\n\nNo changes were made to your stored source.\n\n", markdown.EscapeTelegramHTML(project)))
+	}
+	sb.WriteString(fmt.Sprintf("<b>Hunks:</b> %d applied, %d rejected\n", report.AppliedCount(), len(report.Hunks)-report.AppliedCount()))
+	for _, path := range report.FilesCreated {
+		sb.WriteString(fmt.Sprintf("➕ created <code>%s</code>\n", markdown.EscapeTelegramHTML(path)))
+	}
+	for _, path := range report.FilesDeleted {
+		sb.WriteString(fmt.Sprintf("➖ deleted <code>%s</code>\n", markdown.EscapeTelegramHTML(path)))
+	}
+
+	for i, hunk := range report.Hunks {
+		if i == maxListedHunks {
+			sb.WriteString(fmt.Sprintf("… and %d more hunk(s)\n", len(report.Hunks)-maxListedHunks))
+			break
+		}
+		status := "✅"
+		detail := ""
+		switch {
+		case !hunk.Applied:
+			status = "❌"
+			detail = " — " + hunk.Reason
+		case hunk.Offset != 0:
+			detail = fmt.Sprintf(" (offset %+d lines)", hunk.Offset)
+		}
+		sb.WriteString(fmt.Sprintf("%s <code>%s</code> %s%s\n", status, markdown.EscapeTelegramHTML(hunk.File), markdown.EscapeTelegramHTML(hunk.Header), markdown.EscapeTelegramHTML(detail)))
+	}
+	return sb.String()
+}
+
 // getFileURL retrieves the download URL for the given file ID using Telegram's getFile API.
 func (th *TelegramHandler) getFileURL(fileID string) (string, error) {
 	// Retrieve the Telegram token from the processor
@@ -247,24 +384,59 @@ func (th *TelegramHandler) getFileURL(fileID string) (string, error) {
 	return downloadURL, nil
 }
 
-// downloadFile downloads the file content from the given URL.
-func (th *TelegramHandler) downloadFile(url string) (string, error) {
+// downloadFile downloads the file content from the given URL, refusing bodies larger than the upload limit.
+func (th *TelegramHandler) downloadFile(url string) ([]byte, error) {
 	resp, err := http.Get(url)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", errors.New("failed to download file from Telegram")
+		return nil, errors.New("failed to download file from Telegram")
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+	bodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, sourcetree.DefaultLimits.MaxUploadBytes+1))
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if int64(len(bodyBytes)) > sourcetree.DefaultLimits.MaxUploadBytes {
+		return nil, errors.New("downloaded file exceeds the upload limit")
 	}
 
-	return string(bodyBytes), nil
+	return bodyBytes, nil
+}
+
+// formatImportReport describes which files were stored and which were skipped during an upload.
+func formatImportReport(tree *sourcetree.Tree, report *sourcetree.ImportReport) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📦 <b>Stored:</b> %s", tree.Summary()))
+	if report.Skipped() == 0 {
+		return sb.String()
+	}
+	sb.WriteString(fmt.Sprintf("\n⏭️ <b>Skipped:</b> %d vendored, %d unsupported, %d binary, %d oversized",
+		report.SkippedVendored, report.SkippedUnsupported, len(report.SkippedBinary), len(report.SkippedOversized)))
+	return sb.String()
+}
+
+// formatSecretFindings tells the uploader which credentials were redacted and where.
+// It returns an empty string when nothing was found.
+func formatSecretFindings(findings []secrets.Finding) string {
+	const maxListedFindings = 20
+
+	if len(findings) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("\n\n🔐 <b>Secrets Redacted:</b> %d possible credential(s) were replaced with <code>[REDACTED:kind]</code> before storage. Rotate any that are real.\n", len(findings)))
+	for i, finding := range findings {
+		if i == maxListedFindings {
+			sb.WriteString(fmt.Sprintf("… and %d more\n", len(findings)-maxListedFindings))
+			break
+		}
+		sb.WriteString(fmt.Sprintf("• <code>%s:%d</code> — %s\n", markdown.EscapeTelegramHTML(finding.File), finding.Line, markdown.EscapeTelegramHTML(finding.Kind)))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
 }
 
 // isTaggedMention checks if the mention is directed at the bot.
@@ -0,0 +1,220 @@
+// internal/testgen/testgen.go
+
+package testgen
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"strings"
+
+	"KernelSandersBot/internal/goindex"
+	"KernelSandersBot/internal/markdown"
+	"KernelSandersBot/internal/sourcetree"
+)
+
+// Limits on the code sent to the model.
+const (
+	maxTargetBytes = 40 << 10
+	maxSymbolLines = 400
+)
+
+// language describes how tests are written for one kind of source file.
+type language struct {
+	Name      string
+	Framework string
+	FileName  func(base string) string // Test file name for a source file name without extension
+}
+
+// languages maps source file extensions to their test conventions.
+var languages = map[string]language{
+	".go": {"Go", "the standard testing package, as table-driven tests: a slice of named cases run with t.Run",
+		func(base string) string { return base + "_test.go" }},
+	".py": {"Python", "pytest, as table-driven tests with @pytest.mark.parametrize",
+		func(base string) string { return "test_" + base + ".py" }},
+	".js": {"JavaScript", "Jest, as table-driven tests with test.each",
+		func(base string) string { return base + ".test.js" }},
+	".ts": {"TypeScript", "Jest, as table-driven tests with test.each",
+		func(base string) string { return base + ".test.ts" }},
+}
+
+// Target is the code tests are generated for: a whole file or one Go function, method or type.
+type Target struct {
+	Name     string // File path, or the qualified name of a symbol
+	File     string
+	Package  string // Go package name; empty for other languages
+	Code     string
+	language language
+}
+
+// IsGo reports whether the target is Go code, whose tests are checked with go/parser.
+func (t Target) IsGo() bool {
+	return t.language.Name == "Go"
+}
+
+// TestFileName returns the conventional name of the generated test file.
+func (t Target) TestFileName() string {
+	base := path.Base(t.File)
+	return t.language.FileName(strings.TrimSuffix(base, path.Ext(base)))
+}
+
+// Select finds the target named by arg: a file path of the tree or, for Go, a function, method or type
+// such as ParseConfig, Server.Start or config.Load.
+func Select(tree *sourcetree.Tree, arg string) (Target, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return Target{}, errors.New("no file or function given")
+	}
+
+	if file, ok := findFile(tree, sourcetree.CleanPath(arg)); ok {
+		lang, ok := languages[strings.ToLower(path.Ext(file.Path))]
+		if !ok {
+			return Target{}, fmt.Errorf("tests can be generated for Go, Python, JavaScript and TypeScript files, not %s", path.Base(file.Path))
+		}
+		target := Target{Name: file.Path, File: file.Path, Code: file.Content, language: lang}
+		if target.IsGo() {
+			target.Package = packageName(file.Content)
+		}
+		return target, nil
+	}
+
+	index := goindex.Build(tree)
+	symbols := index.Lookup(arg)
+	switch {
+	case len(symbols) == 0:
+		return Target{}, fmt.Errorf("no file or Go symbol named %s", arg)
+	case len(symbols) > 1:
+		var names []string
+		for _, sym := range symbols {
+			names = append(names, fmt.Sprintf("%s.%s (%s:%d)", sym.Package, sym.QualifiedName(), sym.File, sym.Line))
+		}
+		return Target{}, fmt.Errorf("%s is ambiguous: %s", arg, strings.Join(names, ", "))
+	}
+
+	sym := symbols[0]
+	file, _ := tree.Get(sym.File)
+	var code strings.Builder
+	code.WriteString(fileHeader(file.Content))
+	code.WriteString(goindex.Source(tree, sym, maxSymbolLines) + "\n")
+	// Tests of a method need the type it belongs to
+	if sym.Receiver != "" {
+		for _, recv := range index.Lookup(sym.Receiver) {
+			if recv.Package == sym.Package && recv.Receiver == "" {
+				code.WriteString("\n" + goindex.Source(tree, recv, maxSymbolLines) + "\n")
+				break
+			}
+		}
+	}
+	return Target{
+		Name:     sym.Package + "." + sym.QualifiedName(),
+		File:     sym.File,
+		Package:  sym.Package,
+		Code:     code.String(),
+		language: languages[".go"],
+	}, nil
+}
+
+// findFile returns the file at filePath, or the only file whose path ends with it, such as "config.go"
+// for "internal/config/config.go".
+func findFile(tree *sourcetree.Tree, filePath string) (sourcetree.File, bool) {
+	if file, ok := tree.Get(filePath); ok || filePath == "" {
+		return file, ok
+	}
+	var found []sourcetree.File
+	for _, file := range tree.Files {
+		if strings.HasSuffix(file.Path, "/"+filePath) {
+			found = append(found, file)
+		}
+	}
+	if len(found) != 1 {
+		return sourcetree.File{}, false
+	}
+	return found[0], true
+}
+
+// packageName returns the package clause of Go source, or an empty string.
+func packageName(src string) string {
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, parser.PackageClauseOnly)
+	if err != nil {
+		return ""
+	}
+	return f.Name.Name
+}
+
+// fileHeader returns the package clause and imports of Go source.
+func fileHeader(src string) string {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ImportsOnly)
+	if err != nil {
+		return ""
+	}
+	end := fset.Position(f.Name.End()).Offset
+	if len(f.Imports) > 0 {
+		for _, decl := range f.Decls {
+			if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+				end = fset.Position(gen.End()).Offset
+			}
+		}
+	}
+	return src[:end] + "\n\n"
+}
+
+// Messages returns the system and user prompts asking for tests of the target.
+func Messages(t Target) (string, string) {
+	system := fmt.Sprintf("You are an expert %s developer who writes thorough unit tests. Write tests for the code the user "+
+		"sends using %s. Cover normal cases, edge cases and error paths, with descriptive case names. ", t.language.Name, t.language.Framework)
+	if t.IsGo() {
+		system += fmt.Sprintf("The test file must be a complete, compilable Go file in package %s, with all imports it uses; "+
+			"call unexported identifiers directly. ", t.Package)
+	}
+	system += "Reply with the complete test file in a single fenced code block and nothing else."
+
+	code := t.Code
+	if len(code) > maxTargetBytes {
+		code = code[:maxTargetBytes] + "\n... (truncated)"
+	}
+	user := fmt.Sprintf("Write tests for %s from %s, to be saved as %s:\n\n```\n%s\n```", t.Name, t.File, t.TestFileName(), code)
+	return system, user
+}
+
+// Extract returns the test file from the model's reply: its largest code block, or the whole reply if it has none.
+func Extract(reply string) string {
+	var code string
+	for _, block := range markdown.ExtractCodeBlocks(reply) {
+		if len(block.Code) > len(code) {
+			code = block.Code
+		}
+	}
+	if code == "" {
+		code = strings.TrimSpace(reply)
+	}
+	return strings.TrimSpace(code) + "\n"
+}
+
+// Validate checks generated tests. Go tests must parse with go/parser, belong to the target's package
+// (or its _test package) and declare at least one Test function; other languages are only checked for content.
+func Validate(t Target, code string) error {
+	if strings.TrimSpace(code) == "" {
+		return errors.New("the reply contains no code")
+	}
+	if !t.IsGo() {
+		return nil
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), t.TestFileName(), code, parser.AllErrors)
+	if err != nil {
+		return err
+	}
+	if t.Package != "" && f.Name.Name != t.Package && f.Name.Name != t.Package+"_test" {
+		return fmt.Errorf("the file is in package %s, expected %s", f.Name.Name, t.Package)
+	}
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && strings.HasPrefix(fn.Name.Name, "Test") {
+			return nil
+		}
+	}
+	return errors.New("the file declares no Test function")
+}
@@ -0,0 +1,311 @@
+// internal/tools/source_tools.go
+
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"KernelSandersBot/internal/goindex"
+	"KernelSandersBot/internal/sourcetree"
+	"KernelSandersBot/internal/types"
+)
+
+// Output limits keep each tool result small enough to leave room for the answer.
+const (
+	maxListedFiles   = 300
+	maxReadLines     = 400
+	maxResultBytes   = 24 << 10
+	maxGrepMatches   = 60
+	maxSymbolMatches = 30
+)
+
+// SourceTools exposes a user's uploaded source tree to the model as callable tools:
+// list_files, read_file, grep and find_symbol. It implements api.ToolExecutor.
+type SourceTools struct {
+	tree  *sourcetree.Tree
+	index *goindex.Index
+}
+
+// NewSourceTools creates the tools over a source tree and the symbol index of its Go files.
+func NewSourceTools(tree *sourcetree.Tree, index *goindex.Index) *SourceTools {
+	return &SourceTools{tree: tree, index: index}
+}
+
+// Definitions describes the tools in the format expected by the OpenAI API.
+func (st *SourceTools) Definitions() []types.OpenAITool {
+	return []types.OpenAITool{
+		tool("list_files", "List the files of the user's uploaded source tree with their line counts. "+
+			"Optionally restrict the listing to a directory or a glob pattern such as \"*.go\".",
+			`{"type":"object","properties":{"dir":{"type":"string","description":"Directory to list, e.g. internal/app"},"pattern":{"type":"string","description":"Glob matched against file names, e.g. *_test.go"}}}`),
+		tool("read_file", "Read a file of the uploaded source tree, optionally only a range of lines. Lines are numbered from 1.",
+			`{"type":"object","properties":{"path":{"type":"string"},"start_line":{"type":"integer"},"end_line":{"type":"integer"}},"required":["path"]}`),
+		tool("grep", "Search the uploaded source tree for lines matching a regular expression (RE2 syntax). "+
+			"Returns file:line: text for each match.",
+			`{"type":"object","properties":{"pattern":{"type":"string"},"path_prefix":{"type":"string","description":"Only search files under this path"}},"required":["pattern"]}`),
+		tool("find_symbol", "Find where a function, method, type, class, constant or variable is defined in the uploaded source tree.",
+			`{"type":"object","properties":{"name":{"type":"string","description":"Symbol name, e.g. HandleCommand or App.HandleCommand"}},"required":["name"]}`),
+	}
+}
+
+// tool builds a function tool definition.
+func tool(name, description, parameters string) types.OpenAITool {
+	return types.OpenAITool{
+		Type:     "function",
+		Function: types.OpenAIFunction{Name: name, Description: description, Parameters: json.RawMessage(parameters)},
+	}
+}
+
+// toolArgs holds the union of all tool arguments.
+type toolArgs struct {
+	Dir        string `json:"dir"`
+	Pattern    string `json:"pattern"`
+	Path       string `json:"path"`
+	StartLine  int    `json:"start_line"`
+	EndLine    int    `json:"end_line"`
+	PathPrefix string `json:"path_prefix"`
+	Name       string `json:"name"`
+}
+
+// Call runs a tool and returns its result. Errors are returned as text for the model to act on.
+func (st *SourceTools) Call(name, arguments string) string {
+	var args toolArgs
+	if strings.TrimSpace(arguments) != "" {
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return fmt.Sprintf("error: invalid arguments: %v", err)
+		}
+	}
+
+	var result string
+	var err error
+	switch name {
+	case "list_files":
+		result, err = st.listFiles(args.Dir, args.Pattern)
+	case "read_file":
+		result, err = st.readFile(args.Path, args.StartLine, args.EndLine)
+	case "grep":
+		result, err = st.grep(args.Pattern, args.PathPrefix)
+	case "find_symbol":
+		result, err = st.findSymbol(args.Name)
+	default:
+		err = fmt.Errorf("unknown tool %q", name)
+	}
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return truncate(result)
+}
+
+// listFiles lists the files under dir whose base name matches pattern.
+func (st *SourceTools) listFiles(dir, pattern string) (string, error) {
+	dir = strings.Trim(sourcetree.CleanPath(dir), "/")
+	if pattern != "" {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return "", fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+
+	var sb strings.Builder
+	count := 0
+	for _, file := range st.tree.Files {
+		if dir != "" && file.Path != dir && !strings.HasPrefix(file.Path, dir+"/") {
+			continue
+		}
+		if pattern != "" {
+			if ok, _ := path.Match(pattern, path.Base(file.Path)); !ok {
+				continue
+			}
+		}
+		count++
+		if count <= maxListedFiles {
+			sb.WriteString(fmt.Sprintf("%s (%d lines)\n", file.Path, lineCount(file.Content)))
+		}
+	}
+	if count == 0 {
+		return "no matching files", nil
+	}
+	if count > maxListedFiles {
+		sb.WriteString(fmt.Sprintf("... %d more files; narrow the listing with dir or pattern\n", count-maxListedFiles))
+	}
+	return sb.String(), nil
+}
+
+// readFile returns lines start to end of a file, numbered, capped at maxReadLines.
+func (st *SourceTools) readFile(filePath string, start, end int) (string, error) {
+	file, ok := st.tree.Get(filePath)
+	if !ok {
+		return "", fmt.Errorf("file %q not found; use list_files to see the available paths", filePath)
+	}
+	lines := strings.Split(file.Content, "\n")
+	if start < 1 {
+		start = 1
+	}
+	if end < start || end > len(lines) {
+		end = len(lines)
+	}
+	if start > len(lines) {
+		return "", fmt.Errorf("%s has only %d lines", file.Path, len(lines))
+	}
+	truncated := false
+	if end-start+1 > maxReadLines {
+		end = start + maxReadLines - 1
+		truncated = true
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s lines %d-%d of %d\n", file.Path, start, end, len(lines)))
+	for i := start; i <= end; i++ {
+		sb.WriteString(fmt.Sprintf("%d: %s\n", i, lines[i-1]))
+	}
+	if truncated {
+		sb.WriteString(fmt.Sprintf("... truncated; read from line %d to continue\n", end+1))
+	}
+	return sb.String(), nil
+}
+
+// grep returns the lines matching a regular expression in files under pathPrefix.
+func (st *SourceTools) grep(pattern, pathPrefix string) (string, error) {
+	if pattern == "" {
+		return "", fmt.Errorf("pattern is required")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid regular expression: %v", err)
+	}
+	pathPrefix = sourcetree.CleanPath(pathPrefix)
+
+	var sb strings.Builder
+	count := 0
+	for _, file := range st.tree.Files {
+		if pathPrefix != "" && !strings.HasPrefix(file.Path, pathPrefix) {
+			continue
+		}
+		for i, line := range strings.Split(file.Content, "\n") {
+			if !re.MatchString(line) {
+				continue
+			}
+			count++
+			if count <= maxGrepMatches {
+				sb.WriteString(fmt.Sprintf("%s:%d: %s\n", file.Path, i+1, strings.TrimSpace(line)))
+			}
+		}
+	}
+	if count == 0 {
+		return "no matches", nil
+	}
+	if count > maxGrepMatches {
+		sb.WriteString(fmt.Sprintf("... %d more matches; use a narrower pattern or path_prefix\n", count-maxGrepMatches))
+	}
+	return sb.String(), nil
+}
+
+// findSymbol returns the definitions of a symbol: Go declarations from the symbol index, where
+// "Type.Method" selects a method, and pattern matches in files of other languages.
+func (st *SourceTools) findSymbol(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	receiver := ""
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		receiver, name = name[:i], name[i+1:]
+	}
+	if name == "" || !identifierPattern.MatchString(name) {
+		return "", fmt.Errorf("invalid symbol name %q", name)
+	}
+
+	var sb strings.Builder
+	count := 0
+
+	// Go files are answered from the symbol index, which also knows interface implementers
+	qualified := name
+	if receiver != "" {
+		qualified = receiver + "." + name
+	}
+	for _, sym := range st.index.Lookup(qualified) {
+		count++
+		if count <= maxSymbolMatches {
+			sb.WriteString(fmt.Sprintf("%s:%d: %s\n", sym.File, sym.Line, sym.Signature))
+			if sym.Kind == goindex.KindInterface {
+				if impl := st.index.Implementers(sym.Name); len(impl) > 0 {
+					sb.WriteString(fmt.Sprintf("  implemented by: %s\n", strings.Join(impl, ", ")))
+				}
+			}
+		}
+	}
+
+	definitions := definitionPatterns(regexp.QuoteMeta(name))
+	for _, file := range st.tree.Files {
+		if strings.HasSuffix(file.Path, ".go") {
+			continue
+		}
+		for i, line := range strings.Split(file.Content, "\n") {
+			if !matchesAny(definitions, line) {
+				continue
+			}
+			count++
+			if count <= maxSymbolMatches {
+				sb.WriteString(fmt.Sprintf("%s:%d: %s\n", file.Path, i+1, strings.TrimSpace(line)))
+			}
+		}
+	}
+	if count == 0 {
+		return fmt.Sprintf("no definition of %s found; try grep for usages", name), nil
+	}
+	if count > maxSymbolMatches {
+		sb.WriteString(fmt.Sprintf("... %d more definitions\n", count-maxSymbolMatches))
+	}
+	return sb.String(), nil
+}
+
+// identifierPattern matches a bare identifier.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// definitionPatterns returns patterns matching common definition forms of a symbol in non-Go files:
+// Python, JavaScript/TypeScript, Rust, Java and C-like languages.
+func definitionPatterns(name string) []*regexp.Regexp {
+	forms := []string{
+		`^\s*type\s+` + name + `\b`,                                         // TypeScript type aliases
+		`^\s*(export\s+)?(const|var|let)\s+` + name + `\b`,                  // JavaScript constants and variables
+		`^\s*(async\s+)?def\s+` + name + `\s*\(`,                            // Python functions
+		`^\s*(export\s+)?(default\s+)?(abstract\s+)?class\s+` + name + `\b`, // Classes
+		`^\s*(export\s+)?(async\s+)?function\s*\*?\s*` + name + `\s*\(`,     // JavaScript functions
+		`^\s*(pub(\([a-z]+\))?\s+)?(fn|struct|enum|trait)\s+` + name + `\b`, // Rust items
+		`^\s*(export\s+)?(interface|enum)\s+` + name + `\b`,                 // TypeScript and Java
+	}
+	patterns := make([]*regexp.Regexp, len(forms))
+	for i, form := range forms {
+		patterns[i] = regexp.MustCompile(form)
+	}
+	return patterns
+}
+
+// matchesAny reports whether any pattern matches the line.
+func matchesAny(patterns []*regexp.Regexp, line string) bool {
+	for _, re := range patterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// lineCount returns the number of lines in content.
+func lineCount(content string) int {
+	if content == "" {
+		return 0
+	}
+	return strings.Count(content, "\n") + 1
+}
+
+// truncate caps a tool result at maxResultBytes.
+func truncate(result string) string {
+	if len(result) <= maxResultBytes {
+		return result
+	}
+	cut := strings.LastIndex(result[:maxResultBytes], "\n")
+	if cut < 0 {
+		cut = maxResultBytes
+	}
+	return result[:cut] + "\n... output truncated\n"
+}
@@ -3,12 +3,16 @@
 package types
 
 import (
+	"encoding/json"
+	"errors"
 	"time"
 )
 
 // UserFile represents a user's uploaded file with timestamps.
 type UserFile struct {
 	FileName        string
+	Project         string
+	SizeBytes       int64
 	UploadedAtUTC   time.Time
 	UploadedAtEDT   time.Time
 	DeletionTimeUTC time.Time
@@ -80,16 +84,52 @@ type TelegramEntity struct {
 
 // OpenAIMessage represents a message in the OpenAI conversation.
 type OpenAIMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`   // Set on assistant messages that call tools
+	ToolCallID string           `json:"tool_call_id,omitempty"` // Set on "tool" messages carrying a call's result
 }
 
 // OpenAIQuery represents the payload sent to OpenAI's API.
 type OpenAIQuery struct {
-	Model       string          `json:"model"`
-	Messages    []OpenAIMessage `json:"messages"`
-	Temperature float64         `json:"temperature"`
-	MaxTokens   int             `json:"max_tokens"`
+	Model          string                `json:"model"`
+	Messages       []OpenAIMessage       `json:"messages"`
+	Temperature    float64               `json:"temperature"`
+	MaxTokens      int                   `json:"max_tokens"`
+	Tools          []OpenAITool          `json:"tools,omitempty"`
+	ToolChoice     string                `json:"tool_choice,omitempty"`
+	ResponseFormat *OpenAIResponseFormat `json:"response_format,omitempty"`
+}
+
+// OpenAIResponseFormat constrains the format of the reply, e.g. {"type": "json_object"}.
+type OpenAIResponseFormat struct {
+	Type string `json:"type"`
+}
+
+// OpenAITool describes a function the model may call.
+type OpenAITool struct {
+	Type     string         `json:"type"` // Always "function"
+	Function OpenAIFunction `json:"function"`
+}
+
+// OpenAIFunction describes a callable function and its JSON Schema parameters.
+type OpenAIFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// OpenAIToolCall is a function call requested by the model.
+type OpenAIToolCall struct {
+	ID       string             `json:"id"`
+	Type     string             `json:"type"`
+	Function OpenAIFunctionCall `json:"function"`
+}
+
+// OpenAIFunctionCall holds the name and JSON-encoded arguments of a requested call.
+type OpenAIFunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
 }
 
 // OpenAIResponse represents the response received from OpenAI's API.
@@ -131,3 +171,19 @@ type TelegramFileInfo struct {
 
 // Constants
 const FileRetentionTime = 4 * time.Hour
+
+// Project limits
+const (
+	DefaultProjectName = "default"
+	MaxProjectsPerUser = 5
+	MaxProjectBytes    = 4 << 20 // Size quota for a single project's stored source
+)
+
+// Saved conversation limits
+const (
+	SavedConversationRetention = 7 * 24 * time.Hour
+	MaxSavedConversations      = 10
+)
+
+// ErrProjectQuotaExceeded is returned when stored source code would exceed the project size quota.
+var ErrProjectQuotaExceeded = errors.New("project size quota exceeded")
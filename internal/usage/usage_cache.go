@@ -3,74 +3,154 @@
 package usage
 
 import (
-	"sync"
+	"strconv"
 	"time"
+
+	"KernelSandersBot/internal/expiring"
 )
 
 // UsageCache tracks user message usage for rate limiting.
 type UsageCache struct {
-	users    map[int][]time.Time
-	mutex    sync.Mutex
+	store    *expiring.Store // Recent usage timestamps by user; idle users expire after one window
 	limit    int
 	duration time.Duration
 }
 
 // NewUsageCache initializes a new UsageCache.
 func NewUsageCache() *UsageCache {
+	// Default limit of 10 messages per 10-minute window
+	return NewUsageCacheWithLimit(10, 10*time.Minute)
+}
+
+// NewUsageCacheWithLimit initializes a UsageCache allowing limit units per window of the given duration.
+func NewUsageCacheWithLimit(limit int, duration time.Duration) *UsageCache {
 	return &UsageCache{
-		users:    make(map[int][]time.Time),
-		limit:    10,               // Default limit of 10 messages per duration
-		duration: 10 * time.Minute, // 10-minute window
+		store:    expiring.New(expiring.Options{TTL: duration, CleanupInterval: duration}),
+		limit:    limit,
+		duration: duration,
 	}
 }
 
+// Close stops the cleanup of idle users.
+func (u *UsageCache) Close() {
+	u.store.Close()
+}
+
 // CanUserChat checks if a user is allowed to send a message based on usage in the last duration.
 func (u *UsageCache) CanUserChat(userID int) bool {
-	u.mutex.Lock()
-	defer u.mutex.Unlock()
-
-	// Filter out old timestamps.
-	validTimes := u.filterRecentMessages(userID)
-	u.users[userID] = validTimes
+	return u.CanUserSpend(userID, 1)
+}
 
-	// Check if user has exceeded the limit.
-	return len(validTimes) < u.limit
+// CanUserSpend checks if a user has cost units left in the current window. A query using a more expensive
+// model costs several units; costs above the limit are capped at the limit so every query stays possible.
+func (u *UsageCache) CanUserSpend(userID, cost int) bool {
+	// Check if the query would exceed the limit.
+	return len(u.recentUsage(userID, 0))+u.capCost(cost) <= u.limit
 }
 
 // AddUsage records a new message usage for the user.
 func (u *UsageCache) AddUsage(userID int) {
-	u.mutex.Lock()
-	defer u.mutex.Unlock()
+	u.AddUsageCost(userID, 1)
+}
+
+// AddUsageCost records a query costing the given number of units for the user.
+func (u *UsageCache) AddUsageCost(userID, cost int) {
+	u.recentUsage(userID, u.capCost(cost))
+}
 
-	u.users[userID] = append(u.users[userID], time.Now())
+// TrySpend records a query costing the given number of units if the user has them left in the current
+// window, and reports whether it did. The check and the record are one step, so concurrent queries
+// cannot overspend.
+func (u *UsageCache) TrySpend(userID, cost int) bool {
+	cost = u.capCost(cost)
+	spent := false
+	u.store.Update(strconv.Itoa(userID), func(value interface{}, found bool) (interface{}, bool) {
+		validTimes := u.withinWindow(value, found)
+		if len(validTimes)+cost <= u.limit {
+			now := time.Now()
+			for i := 0; i < cost; i++ {
+				validTimes = append(validTimes, now)
+			}
+			spent = true
+		}
+		return validTimes, len(validTimes) > 0
+	})
+	return spent
 }
 
 // TimeUntilLimitReset calculates the time remaining until the rate limit is lifted.
 func (u *UsageCache) TimeUntilLimitReset(userID int) time.Duration {
-	u.mutex.Lock()
-	defer u.mutex.Unlock()
+	return u.TimeUntilAffordable(userID, 1)
+}
 
-	validTimes := u.filterRecentMessages(userID)
-	if len(validTimes) < u.limit {
+// TimeUntilAffordable calculates the time remaining until the user has cost units available.
+func (u *UsageCache) TimeUntilAffordable(userID, cost int) time.Duration {
+	validTimes := u.recentUsage(userID, 0)
+	excess := len(validTimes) + u.capCost(cost) - u.limit
+	if excess <= 0 {
 		return 0 // No limit currently in place.
 	}
 
-	// Calculate time remaining until the oldest timestamp falls outside the duration window.
-	oldestTime := validTimes[0]
-	return u.duration - time.Since(oldestTime)
+	// Calculate time remaining until enough timestamps fall outside the duration window.
+	return u.duration - time.Since(validTimes[excess-1])
 }
 
-// filterRecentMessages filters messages within the allowed duration.
-func (u *UsageCache) filterRecentMessages(userID int) []time.Time {
-	if _, exists := u.users[userID]; !exists {
-		u.users[userID] = []time.Time{}
-		return u.users[userID]
+// History returns the timestamps of the user's messages within the current window.
+func (u *UsageCache) History(userID int) []time.Time {
+	return u.recentUsage(userID, 0)
+}
+
+// Limits returns the number of messages allowed per window and the window length.
+func (u *UsageCache) Limits() (int, time.Duration) {
+	return u.limit, u.duration
+}
+
+// DeleteUser forgets a user's usage history and returns the number of records removed.
+func (u *UsageCache) DeleteUser(userID int) int {
+	removed := 0
+	u.store.Update(strconv.Itoa(userID), func(value interface{}, found bool) (interface{}, bool) {
+		if found {
+			removed = len(value.([]time.Time))
+		}
+		return nil, false
+	})
+	return removed
+}
+
+// capCost limits a query's cost to between one unit and the whole window's limit.
+func (u *UsageCache) capCost(cost int) int {
+	if cost < 1 {
+		return 1
+	}
+	if cost > u.limit {
+		return u.limit
 	}
+	return cost
+}
+
+// recentUsage drops the user's timestamps that fell outside the window, records add new ones at the
+// current time, and returns a copy of the result.
+func (u *UsageCache) recentUsage(userID, add int) []time.Time {
+	var validTimes []time.Time
+	u.store.Update(strconv.Itoa(userID), func(value interface{}, found bool) (interface{}, bool) {
+		validTimes = u.withinWindow(value, found)
+		now := time.Now()
+		for i := 0; i < add; i++ {
+			validTimes = append(validTimes, now)
+		}
+		return validTimes, len(validTimes) > 0
+	})
+	return append([]time.Time(nil), validTimes...)
+}
 
-	validTimes := []time.Time{}
-	for _, t := range u.users[userID] {
-		if time.Since(t) <= u.duration {
-			validTimes = append(validTimes, t)
+// withinWindow returns the stored timestamps that are still inside the window.
+func (u *UsageCache) withinWindow(value interface{}, found bool) []time.Time {
+	var validTimes []time.Time
+	if found {
+		for _, t := range value.([]time.Time) {
+			if time.Since(t) <= u.duration {
+				validTimes = append(validTimes, t)
+			}
 		}
 	}
 	return validTimes